@@ -2,6 +2,9 @@ module github.com/standardrhyme/stegsecure
 
 go 1.17
 
-require bazil.org/fuse v0.0.0-20200524192727-fb710f7dfd05
+require (
+	bazil.org/fuse v0.0.0-20200524192727-fb710f7dfd05
+	golang.org/x/image v0.18.0
+)
 
 require golang.org/x/sys v0.0.0-20191210023423-ac6580df4449 // indirect
@@ -1,21 +1,179 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"image/color"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/standardrhyme/stegsecure/pkg/interceptionfs"
+	"github.com/standardrhyme/stegsecure/pkg/metadata"
+	"github.com/standardrhyme/stegsecure/pkg/pngchunk"
+	"github.com/standardrhyme/stegsecure/pkg/sanitize"
 	"github.com/standardrhyme/stegsecure/pkg/steganalysis"
 )
 
 var (
 	DEBUG = false
+
+	detectors = flag.String("detectors", strings.Join(steganalysis.DefaultDetectors, ","),
+		"comma-separated detectors to run, from: "+strings.Join(steganalysis.Names(), ", "))
+	jpegDetectors = flag.String("jpeg-detectors", strings.Join(steganalysis.DefaultJPEGDetectors, ","),
+		"comma-separated detectors to run on JPEG files instead of -detectors")
+	paletteDetectors = flag.String("palette-detectors", strings.Join(steganalysis.DefaultPaletteDetectors, ","),
+		"comma-separated detectors to run on GIFs and palette PNGs instead of -detectors")
+	fusion    = flag.String("fusion", "majority", "how to combine detectors: majority, max, weighted or all")
+	weights   = flag.String("weights", "", "comma-separated detector=weight pairs for -fusion weighted")
+	threshold = flag.Float64("threshold", 0.5, "probability threshold for -fusion max and weighted")
+	workers   = flag.Int("workers", 0, "number of goroutines to analyze each image with (0 means one per CPU)")
+	rsMasks   = flag.String("rs-masks", "", "comma-separated flipping masks of the rs detector, rows of 0s and 1s separated by / (01/10,10/01 if empty)")
+
+	heatmap     = flag.String("heatmap", "", "detector to score each tile of flagged images with")
+	heatmapTile = flag.Int("heatmap-tile", steganalysis.DefaultTileSize, "side of each heatmap tile, in pixels")
+	heatmapDir  = flag.String("heatmap-dir", "", "directory to write heatmap overlays of flagged images to")
+	heatmapCut  = flag.Float64("heatmap-threshold", 0.5, "score above which a heatmap tile counts as embedded")
+	localize    = flag.Bool("localize", false, "only sanitize the heatmap tiles flagged by -heatmap")
+
+	lsbSanitize  = flag.String("lsb-sanitize", "clear", "how to rewrite the LSBs of pixels: clear, random, noise or pattern")
+	depth        = flag.Int("depth", 1, "number of bit planes -lsb-sanitize rewrites, from 1 to 4")
+	planes       = flag.String("planes", "", "comma-separated -lsb-sanitize modes of bit planes 0, 1 and so on, overriding it")
+	background   = flag.String("background", "", "RRGGBB colour to flatten images with alpha against when sanitizing (alpha is sanitized if empty)")
+	seed         = flag.String("seed", "", "seed for -lsb-sanitize random and noise and -jpeg-sanitize randomize (random if empty)")
+	jpegSanitize = flag.String("jpeg-sanitize", "clear", "how to sanitize JPEG coefficients: clear, randomize or requantize")
+	quantScale   = flag.Float64("quant-scale", 2, "factor -jpeg-sanitize requantize coarsens the quantization tables by")
+	minPSNR      = flag.Float64("min-psnr", 0, "PSNR in dB below which sanitization falls back to gentler settings (0 for no floor)")
+	minSSIM      = flag.Float64("min-ssim", 0, "SSIM below which sanitization falls back to gentler settings (0 for no floor)")
+	truncate     = flag.Bool("truncate", true, "remove data appended after the end of images whose pixels are not flagged")
+	metadataMode = flag.String("metadata", "private", "metadata to strip from every file: private (GPS, serial numbers), all or keep")
+	thumbnail    = flag.String("thumbnail", "regenerate", "what to do to the EXIF thumbnails of sanitized images: regenerate, drop or keep")
+	stripChunks  = flag.Bool("strip-chunks", true, "remove suspicious PNG chunks from images whose pixels are not flagged")
+	pngChunks    = flag.String("png-chunks", strings.Join(pngchunk.DefaultKeep, ","), "comma-separated ancillary PNG chunks kept when stripping")
 )
 
+// parseWeights parses a list of detector=weight pairs.
+func parseWeights(s string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	if s == "" {
+		return weights, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid weight: %s", pair)
+		}
+
+		w, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		weights[parts[0]] = w
+	}
+
+	return weights, nil
+}
+
+// parseColor parses an RRGGBB hex colour, with or without a leading #. An
+// empty string is no colour.
+func parseColor(s string) (color.Color, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	v, err := strconv.ParseUint(strings.TrimPrefix(s, "#"), 16, 32)
+	if err != nil || len(strings.TrimPrefix(s, "#")) != 6 {
+		return nil, fmt.Errorf("Invalid colour: %s", s)
+	}
+	return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
 func testInterception(path string) {
+	rule, err := steganalysis.ParseFusionRule(*fusion)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w, err := parseWeights(*weights)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var masks []steganalysis.Mask
+	if *rsMasks != "" {
+		if masks, err = steganalysis.ParseMasks(*rsMasks); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	lsb, err := sanitize.ParseLSBMode(*lsbSanitize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var planeModes []sanitize.LSBMode
+	if *planes != "" {
+		if planeModes, err = sanitize.ParseLSBModes(*planes); err != nil {
+			log.Fatal(err)
+		}
+	}
+	bg, err := parseColor(*background)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mode, err := sanitize.ParseJPEGMode(*jpegSanitize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	strip, err := metadata.ParseMode(*metadataMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	thumbnails, err := metadata.ParseThumbnailMode(*thumbnail)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	notifier, err := steganalysis.NewNotifier(steganalysis.Config{
+		Detectors:        strings.Split(*detectors, ","),
+		JPEGDetectors:    strings.Split(*jpegDetectors, ","),
+		PaletteDetectors: strings.Split(*paletteDetectors, ","),
+		Fusion:           rule,
+		Weights:          w,
+		Threshold:        *threshold,
+		Workers:          *workers,
+		RSMasks:          masks,
+		Heatmap: steganalysis.HeatmapConfig{
+			Detector:  *heatmap,
+			TileSize:  *heatmapTile,
+			Threshold: *heatmapCut,
+			Dir:       *heatmapDir,
+			Localize:  *localize,
+		},
+		Sanitize: sanitize.Policy{
+			LSB:        lsb,
+			Depth:      *depth,
+			Planes:     planeModes,
+			Background: bg,
+			Seed:       []byte(*seed),
+			JPEG:       mode,
+			QuantScale: *quantScale,
+			PNGChunks:  strings.Split(*pngChunks, ","),
+			Metadata:   strip,
+			Thumbnail:  thumbnails,
+			MinPSNR:    *minPSNR,
+			MinSSIM:    *minSSIM,
+		},
+		Truncate:    *truncate,
+		StripChunks: *stripChunks,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Initialize the filesystem
-	fs, err := interceptionfs.Init(steganalysis.AnalyzeGo)
+	fs, err := interceptionfs.Init(notifier)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -48,14 +206,16 @@ func testInterception(path string) {
 }
 
 func main() {
+	flag.Parse()
+
 	if os.Geteuid() != 0 {
 		log.Fatalln("Must be run as root!")
 	}
 
-	if (len(os.Args[1:]) < 1) {
+	if (len(flag.Args()) < 1) {
 		fmt.Println("Mounting to testdir/Downloads. If you want to set the folder to mount to, use: sudo go run main.go MOUNTPATH")
 		testInterception("testdir/Downloads")
 	} else {
-		testInterception(os.Args[1])
+		testInterception(flag.Arg(0))
 	}
 }
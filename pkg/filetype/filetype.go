@@ -0,0 +1,140 @@
+// Package filetype recognizes image files by their first bytes rather than by
+// their names, which a download can get wrong or an embedder can choose to
+// hide a file behind.
+package filetype
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Type is a file format recognized by its magic bytes.
+type Type struct {
+	// Name is the name image.Decode reports for the format, e.g. "png".
+	Name string
+	// MIME is the media type of the format.
+	MIME string
+	// Extensions are the lowercase file name extensions used for the
+	// format, with the leading dot.
+	Extensions []string
+	// Magic are the byte patterns a file of the format may start with. A
+	// '?' matches any byte.
+	Magic []string
+}
+
+// match reports whether data starts with one of the type's magic patterns.
+func (t Type) match(data []byte) bool {
+	for _, magic := range t.Magic {
+		if matchMagic(data, magic) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchMagic(data []byte, magic string) bool {
+	if len(data) < len(magic) {
+		return false
+	}
+	for i := 0; i < len(magic); i++ {
+		if magic[i] != '?' && magic[i] != data[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchesName reports whether the extension of name is one of the type's. A
+// name without an extension claims no type, so it matches any.
+func (t Type) MatchesName(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		return true
+	}
+	for _, e := range t.Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   []Type
+)
+
+// Register adds a type to those Detect recognizes. Types are tried in the
+// order they were registered. It panics if the type has no name or magic, or
+// its name has already been registered.
+func Register(t Type) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if t.Name == "" || len(t.Magic) == 0 {
+		panic("filetype: Register called with an unnamed type or no magic")
+	}
+	for _, r := range registry {
+		if r.Name == t.Name {
+			panic("filetype: Register called twice for type " + t.Name)
+		}
+	}
+
+	registry = append(registry, t)
+}
+
+// Detect returns the type of the file starting with data.
+func Detect(data []byte) (Type, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	for _, t := range registry {
+		if t.match(data) {
+			return t, true
+		}
+	}
+	return Type{}, false
+}
+
+// Lookup returns the registered type with the given name.
+func Lookup(name string) (Type, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	for _, t := range registry {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Type{}, false
+}
+
+// ForName returns the registered type the extension of name claims.
+func ForName(name string) (Type, bool) {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		return Type{}, false
+	}
+
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	for _, t := range registry {
+		for _, e := range t.Extensions {
+			if e == ext {
+				return t, true
+			}
+		}
+	}
+	return Type{}, false
+}
+
+func init() {
+	Register(Type{Name: "png", MIME: "image/png", Extensions: []string{".png"}, Magic: []string{"\x89PNG\r\n\x1a\n"}})
+	Register(Type{Name: "jpeg", MIME: "image/jpeg", Extensions: []string{".jpg", ".jpeg", ".jpe", ".jfif"}, Magic: []string{"\xff\xd8\xff"}})
+	Register(Type{Name: "gif", MIME: "image/gif", Extensions: []string{".gif"}, Magic: []string{"GIF87a", "GIF89a"}})
+	Register(Type{Name: "bmp", MIME: "image/bmp", Extensions: []string{".bmp", ".dib"}, Magic: []string{"BM????\x00\x00\x00\x00"}})
+	Register(Type{Name: "tiff", MIME: "image/tiff", Extensions: []string{".tif", ".tiff"}, Magic: []string{"II*\x00", "MM\x00*"}})
+	Register(Type{Name: "webp", MIME: "image/webp", Extensions: []string{".webp"}, Magic: []string{"RIFF????WEBP"}})
+}
@@ -0,0 +1,61 @@
+package filetype
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	for file, want := range map[string]string{
+		"../../testfiles/samplesmaller.png":   "png",
+		"../../testfiles/sample.jpg":          "jpeg",
+		"../../testfiles/samplelossy.webp":    "webp",
+		"../../testfiles/samplelossless.webp": "webp",
+	} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := Detect(data)
+		if !ok || got.Name != want {
+			t.Errorf("Detect(%s) = %q, %v; want %q", file, got.Name, ok, want)
+		}
+	}
+
+	if got, ok := Detect([]byte("not an image")); ok {
+		t.Errorf("Detect(text) = %q; want no type", got.Name)
+	}
+	if got, ok := Detect([]byte("\xff\xd8")); ok {
+		t.Errorf("Detect(short) = %q; want no type", got.Name)
+	}
+}
+
+func TestMatchesName(t *testing.T) {
+	png, _ := Lookup("png")
+	for name, want := range map[string]bool{
+		"x.png":     true,
+		"x.PNG":     true,
+		"image":     true,
+		"photo.jpg": false,
+		"a.png.jpg": false,
+	} {
+		if got := png.MatchesName(name); got != want {
+			t.Errorf("MatchesName(%q) = %v; want %v", name, got, want)
+		}
+	}
+}
+
+func TestForName(t *testing.T) {
+	for name, want := range map[string]string{
+		"x.png":      "png",
+		"photo.JPEG": "jpeg",
+		"scan.tif":   "tiff",
+		"a.png.zip":  "",
+		"image":      "",
+	} {
+		got, ok := ForName(name)
+		if ok != (want != "") || got.Name != want {
+			t.Errorf("ForName(%q) = %q, %v; want %q", name, got.Name, ok, want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package jpegcoef
+
+import (
+	"math"
+)
+
+// dctBasis[x][u] is C(u)/2 cos((2x+1)u pi/16), the orthonormal 8-point DCT-II
+// basis.
+var dctBasis [8][8]float64
+
+func init() {
+	for x := 0; x < 8; x++ {
+		for u := 0; u < 8; u++ {
+			c := 0.5
+			if u == 0 {
+				c = 0.5 / math.Sqrt2
+			}
+			dctBasis[x][u] = c * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+}
+
+// InverseDCT dequantizes b with the quantization table q and returns its 8x8
+// samples row-major in out, shifted back to the 0..255 range but neither
+// rounded nor clamped.
+func InverseDCT(b *Block, q *[64]uint16, out *[64]float64) {
+	var coef, tmp [64]float64
+	var rows [8]bool
+	flat := true
+	for k := 0; k < 64; k++ {
+		if b[k] != 0 {
+			coef[ZigZag[k]] = float64(b[k]) * float64(q[k])
+			rows[ZigZag[k]/8] = true
+			flat = flat && k == 0
+		}
+	}
+
+	// Most blocks have only a few nonzero coefficients, so skip the work for
+	// all-zero rows, and for blocks with no AC coefficients at all.
+	if flat {
+		dc := coef[0]/8 + 128
+		for i := range out {
+			out[i] = dc
+		}
+		return
+	}
+
+	for v := 0; v < 8; v++ {
+		if !rows[v] {
+			continue
+		}
+		for x := 0; x < 8; x++ {
+			sum := float64(0)
+			for u := 0; u < 8; u++ {
+				sum += dctBasis[x][u] * coef[v*8+u]
+			}
+			tmp[v*8+x] = sum
+		}
+	}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			sum := float64(0)
+			for v := 0; v < 8; v++ {
+				if rows[v] {
+					sum += dctBasis[y][v] * tmp[v*8+x]
+				}
+			}
+			out[y*8+x] = sum + 128
+		}
+	}
+}
+
+// ForwardDCT transforms 8x8 samples in the 0..255 range, row-major, and
+// quantizes them with q into b.
+func ForwardDCT(in *[64]float64, q *[64]uint16, b *Block) {
+	var tmp, coef [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			sum := float64(0)
+			for x := 0; x < 8; x++ {
+				sum += dctBasis[x][u] * (in[y*8+x] - 128)
+			}
+			tmp[y*8+u] = sum
+		}
+	}
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			sum := float64(0)
+			for y := 0; y < 8; y++ {
+				sum += dctBasis[y][v] * tmp[y*8+u]
+			}
+			coef[v*8+u] = sum
+		}
+	}
+
+	for k := 0; k < 64; k++ {
+		b[k] = int16(math.Round(coef[ZigZag[k]] / float64(q[k])))
+	}
+}
+
+// Samples returns the 8-bit samples of c, dequantized with q, as a row-major
+// plane of 8*BlocksWide by 8*BlocksHigh samples. They are rounded and clamped
+// as a decoder would.
+func (c *Component) Samples(q *[64]uint16) []uint8 {
+	samples := make([]uint8, 64*c.BlocksWide*c.BlocksHigh)
+	c.SampleRows(q, samples, 0, c.BlocksHigh)
+	return samples
+}
+
+// SampleRows fills in the samples of the block rows [by0, by1) of a plane laid
+// out as by Samples, so that a plane can be filled in in parallel.
+func (c *Component) SampleRows(q *[64]uint16, samples []uint8, by0, by1 int) {
+	stride := 8 * c.BlocksWide
+
+	var out [64]float64
+	for by := by0; by < by1; by++ {
+		for bx := 0; bx < c.BlocksWide; bx++ {
+			InverseDCT(c.Block(bx, by), q, &out)
+			for y := 0; y < 8; y++ {
+				row := samples[(8*by+y)*stride+8*bx:]
+				for x := 0; x < 8; x++ {
+					row[x] = clamp(out[y*8+x])
+				}
+			}
+		}
+	}
+}
+
+// clamp rounds a sample to the nearest value in 0..255.
+func clamp(v float64) uint8 {
+	v = math.Round(v)
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
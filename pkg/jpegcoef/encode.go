@@ -0,0 +1,421 @@
+package jpegcoef
+
+import (
+	"bufio"
+	"io"
+)
+
+// Encode writes img, as returned by Decode, as a sequential JPEG. The kept
+// segments are copied unchanged, while the quantization tables, frame header
+// and scans are written from img, so its coefficients and tables can be
+// changed in between Decode and Encode. Progressive files are written as
+// sequential ones, with Huffman tables optimized for the coefficients and no
+// restart markers.
+func Encode(w io.Writer, img *Image) error {
+	e := &encoder{w: bufio.NewWriter(w), img: img}
+
+	e.marker(soi)
+	for _, seg := range img.Segments {
+		e.segment(seg.Marker, seg.Data)
+	}
+	e.writeDQT()
+	e.writeSOF()
+
+	// A scan interleaves at most 10 blocks per MCU, otherwise each component
+	// gets a scan of its own.
+	blocks := 0
+	for i := range img.Components {
+		blocks += img.Components[i].H * img.Components[i].V
+	}
+	if len(img.Components) == 1 || blocks > 10 {
+		for i := range img.Components {
+			e.writeScan([]*Component{&img.Components[i]})
+		}
+	} else {
+		comps := make([]*Component, len(img.Components))
+		for i := range img.Components {
+			comps[i] = &img.Components[i]
+		}
+		e.writeScan(comps)
+	}
+
+	e.marker(eoi)
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// encoder holds the state of an Encode call.
+type encoder struct {
+	w   *bufio.Writer
+	img *Image
+	err error
+
+	// Bits waiting to be written to the entropy-coded data.
+	acc uint32
+	n   uint
+}
+
+func (e *encoder) write(p []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+}
+
+func (e *encoder) marker(m byte) {
+	e.write([]byte{0xff, m})
+}
+
+func (e *encoder) segment(m byte, data []byte) {
+	n := len(data) + 2
+	e.write([]byte{0xff, m, byte(n >> 8), byte(n)})
+	e.write(data)
+}
+
+// writeDQT writes the quantization tables used by the components, with 16-bit
+// entries only where needed.
+func (e *encoder) writeDQT() {
+	var used [4]bool
+	for i := range e.img.Components {
+		used[e.img.Components[i].Tq] = true
+	}
+
+	var data []byte
+	for tq, q := range e.img.Quant {
+		if !used[tq] {
+			continue
+		}
+
+		wide := false
+		for _, v := range q {
+			wide = wide || v > 255
+		}
+		if !wide {
+			data = append(data, byte(tq))
+			for _, v := range q {
+				data = append(data, byte(v))
+			}
+			continue
+		}
+
+		data = append(data, 0x10|byte(tq))
+		for _, v := range q {
+			data = append(data, byte(v>>8), byte(v))
+		}
+	}
+	e.segment(dqt, data)
+}
+
+// writeSOF writes a baseline frame header, or an extended sequential one if
+// there are 16-bit quantization tables.
+func (e *encoder) writeSOF() {
+	img := e.img
+	marker := byte(sof0)
+	for i := range img.Components {
+		for _, v := range img.Quant[img.Components[i].Tq] {
+			if v > 255 {
+				marker = sof1
+			}
+		}
+	}
+
+	data := []byte{8, byte(img.Height >> 8), byte(img.Height), byte(img.Width >> 8), byte(img.Width), byte(len(img.Components))}
+	for i := range img.Components {
+		c := &img.Components[i]
+		data = append(data, byte(c.ID), byte(c.H<<4|c.V), byte(c.Tq))
+	}
+	e.segment(marker, data)
+}
+
+// scanBlocks calls fn on each block of a scan over comps in order, with the
+// index of its component in comps.
+func (e *encoder) scanBlocks(comps []*Component, fn func(ci int, b *Block)) {
+	img := e.img
+	if len(comps) == 1 {
+		// A non-interleaved scan only covers the blocks inside the image.
+		c := comps[0]
+		wide := ((img.Width*c.H+img.hmax-1)/img.hmax + 7) / 8
+		high := ((img.Height*c.V+img.vmax-1)/img.vmax + 7) / 8
+		for by := 0; by < high; by++ {
+			for bx := 0; bx < wide; bx++ {
+				fn(0, c.Block(bx, by))
+			}
+		}
+		return
+	}
+
+	for my := 0; my < img.mcusHigh; my++ {
+		for mx := 0; mx < img.mcusWide; mx++ {
+			for ci, c := range comps {
+				for v := 0; v < c.V; v++ {
+					for h := 0; h < c.H; h++ {
+						fn(ci, c.Block(mx*c.H+h, my*c.V+v))
+					}
+				}
+			}
+		}
+	}
+}
+
+// writeScan writes the tables and data of a sequential scan over comps. The
+// first component of the image gets Huffman tables 0, the rest share tables
+// 1, each optimized for the coefficients it codes.
+func (e *encoder) writeScan(comps []*Component) {
+	table := func(c *Component) int {
+		if c == &e.img.Components[0] {
+			return 0
+		}
+		return 1
+	}
+
+	// Count the symbols each table codes.
+	var dcFreq, acFreq [2][256]int
+	used := [2]bool{}
+	preds := make([]int32, len(comps))
+	e.scanBlocks(comps, func(ci int, b *Block) {
+		t := table(comps[ci])
+		used[t] = true
+		diff := int32(b[0]) - preds[ci]
+		preds[ci] = int32(b[0])
+		dcFreq[t][bitLength(diff)]++
+
+		run := 0
+		for k := 1; k < 64; k++ {
+			if b[k] == 0 {
+				run++
+				continue
+			}
+			for ; run > 15; run -= 16 {
+				acFreq[t][0xf0]++
+			}
+			acFreq[t][run<<4|bitLength(int32(b[k]))]++
+			run = 0
+		}
+		if run > 0 {
+			acFreq[t][0x00]++
+		}
+	})
+
+	var dc, ac [2]*huffmanEncoder
+	var tables []byte
+	for t := 0; t < 2; t++ {
+		if !used[t] {
+			continue
+		}
+		dc[t] = newHuffmanEncoder(dcFreq[t][:])
+		ac[t] = newHuffmanEncoder(acFreq[t][:])
+		tables = append(tables, byte(t))
+		tables = append(tables, dc[t].spec()...)
+		tables = append(tables, 0x10|byte(t))
+		tables = append(tables, ac[t].spec()...)
+	}
+	e.segment(dht, tables)
+
+	header := []byte{byte(len(comps))}
+	for _, c := range comps {
+		t := byte(table(c))
+		header = append(header, byte(c.ID), t<<4|t)
+	}
+	header = append(header, 0, 63, 0)
+	e.segment(sos, header)
+
+	for i := range preds {
+		preds[i] = 0
+	}
+	e.scanBlocks(comps, func(ci int, b *Block) {
+		t := table(comps[ci])
+		diff := int32(b[0]) - preds[ci]
+		preds[ci] = int32(b[0])
+		e.emitValue(dc[t], 0, diff)
+
+		run := 0
+		for k := 1; k < 64; k++ {
+			if b[k] == 0 {
+				run++
+				continue
+			}
+			for ; run > 15; run -= 16 {
+				e.emit(ac[t], 0xf0)
+			}
+			e.emitValue(ac[t], run, int32(b[k]))
+			run = 0
+		}
+		if run > 0 {
+			e.emit(ac[t], 0x00)
+		}
+	})
+	e.flushBits()
+}
+
+// bitLength returns the number of bits in the magnitude of v, its category in
+// the JPEG specification.
+func bitLength(v int32) int {
+	if v < 0 {
+		v = -v
+	}
+	n := 0
+	for ; v > 0; v >>= 1 {
+		n++
+	}
+	return n
+}
+
+// emit writes the Huffman code for a symbol.
+func (e *encoder) emit(h *huffmanEncoder, symbol int) {
+	e.writeBits(uint32(h.code[symbol]), uint(h.size[symbol]))
+}
+
+// emitValue writes the symbol for a run of zeros followed by v, and the bits
+// of v, which for negative values are those of v - 1.
+func (e *encoder) emitValue(h *huffmanEncoder, run int, v int32) {
+	n := bitLength(v)
+	e.emit(h, run<<4|n)
+	if v < 0 {
+		v--
+	}
+	e.writeBits(uint32(v)&(1<<uint(n)-1), uint(n))
+}
+
+// writeBits writes the low n <= 16 bits of bits to the entropy-coded data,
+// stuffing a 0x00 after each 0xff.
+func (e *encoder) writeBits(bits uint32, n uint) {
+	e.acc = e.acc<<n | bits
+	e.n += n
+	for e.n >= 8 {
+		b := byte(e.acc >> (e.n - 8))
+		if b == 0xff {
+			e.write([]byte{b, 0})
+		} else {
+			e.write([]byte{b})
+		}
+		e.n -= 8
+	}
+	e.acc &= 1<<e.n - 1
+}
+
+// flushBits pads the entropy-coded data to a byte with 1 bits.
+func (e *encoder) flushBits() {
+	if e.n > 0 {
+		e.writeBits(1<<(8-e.n)-1, 8-e.n)
+	}
+}
+
+// huffmanEncoder is a Huffman table built from symbol frequencies.
+type huffmanEncoder struct {
+	counts [16]int
+	vals   []byte
+	code   [256]uint16
+	size   [256]uint8
+}
+
+// newHuffmanEncoder builds a table of codes of at most 16 bits for the
+// symbols with nonzero frequencies, following section K.2 of the JPEG
+// specification.
+func newHuffmanEncoder(freq []int) *huffmanEncoder {
+	// A reserved symbol 256 with frequency 1 ensures no code is all 1 bits.
+	var f [257]int
+	copy(f[:], freq)
+	f[256] = 1
+
+	var codesize [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+
+	for {
+		// Find the least frequent symbol v1 and the next least v2,
+		// preferring the higher symbol on ties.
+		v1, v2 := -1, -1
+		for i := range f {
+			if f[i] == 0 {
+				continue
+			}
+			if v1 < 0 || f[i] <= f[v1] {
+				v2, v1 = v1, i
+			} else if v2 < 0 || f[i] <= f[v2] {
+				v2 = i
+			}
+		}
+		if v2 < 0 {
+			break
+		}
+
+		f[v1] += f[v2]
+		f[v2] = 0
+
+		codesize[v1]++
+		for others[v1] >= 0 {
+			v1 = others[v1]
+			codesize[v1]++
+		}
+		others[v1] = v2
+		codesize[v2]++
+		for others[v2] >= 0 {
+			v2 = others[v2]
+			codesize[v2]++
+		}
+	}
+
+	var bits [33]int
+	for _, s := range codesize {
+		if s > 0 {
+			bits[s]++
+		}
+	}
+
+	// Limit the codes to 16 bits.
+	for i := 32; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+	// Remove the reserved symbol, which has the longest code.
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]--
+
+	h := &huffmanEncoder{}
+	copy(h.counts[:], bits[1:17])
+	for size := 1; size <= 32; size++ {
+		for sym := 0; sym < 256; sym++ {
+			if codesize[sym] == size {
+				h.vals = append(h.vals, byte(sym))
+			}
+		}
+	}
+
+	// Assign the codes in order of length, as newHuffman does.
+	code, k := uint16(0), 0
+	for l := 1; l <= 16; l++ {
+		for n := 0; n < h.counts[l-1]; n++ {
+			h.code[h.vals[k]] = code
+			h.size[h.vals[k]] = uint8(l)
+			code++
+			k++
+		}
+		code <<= 1
+	}
+
+	return h
+}
+
+// spec returns the table as it is written in a DHT segment, after the table
+// class and destination.
+func (h *huffmanEncoder) spec() []byte {
+	spec := make([]byte, 16, 16+len(h.vals))
+	for i, n := range h.counts {
+		spec[i] = byte(n)
+	}
+	return append(spec, h.vals...)
+}
@@ -0,0 +1,145 @@
+package jpegcoef
+
+import (
+	"fmt"
+)
+
+// lutBits is the length of the codes looked up in a single step.
+const lutBits = 8
+
+// huffman is a decoding table built from a DHT segment, as in section F.2.2.3
+// of the JPEG specification.
+type huffman struct {
+	// lut maps the next lutBits bits to the code length<<8 | value of the
+	// code they start with, or 0 if that code is longer than lutBits.
+	lut [1 << lutBits]uint16
+
+	// For each code length l, the codes of that length are
+	// mincode[l]..maxcode[l], with values starting at vals[valptr[l]].
+	maxcode [17]int32
+	mincode [17]int32
+	valptr  [17]int32
+	vals    []byte
+}
+
+func newHuffman(counts [16]int, vals []byte) (*huffman, error) {
+	h := &huffman{vals: vals}
+
+	code, k := int32(0), int32(0)
+	for l := 1; l <= 16; l++ {
+		n := int32(counts[l-1])
+		h.mincode[l] = code
+		h.valptr[l] = k
+		h.maxcode[l] = -1
+		if n > 0 {
+			h.maxcode[l] = code + n - 1
+		}
+		if code+n > 1<<l {
+			return nil, fmt.Errorf("Invalid JPEG Huffman table")
+		}
+
+		if l <= lutBits {
+			for i := int32(0); i < n; i++ {
+				first := (code + i) << (lutBits - l)
+				for s := int32(0); s < 1<<(lutBits-l); s++ {
+					h.lut[first+s] = uint16(l)<<8 | uint16(vals[k+i])
+				}
+			}
+		}
+
+		code = (code + n) << 1
+		k += n
+	}
+
+	return h, nil
+}
+
+// bitReader reads the entropy-coded data of a scan, removing the 0x00 bytes
+// stuffed after each 0xff. At a marker it returns zero bits without consuming
+// the marker.
+type bitReader struct {
+	data []byte
+	pos  int
+	acc  uint32
+	n    uint
+}
+
+// fill tops up the accumulator to at least 25 bits.
+func (r *bitReader) fill() {
+	for r.n <= 24 {
+		var b byte
+		if r.pos < len(r.data) {
+			b = r.data[r.pos]
+			if b != 0xff {
+				r.pos++
+			} else if r.pos+1 < len(r.data) && r.data[r.pos+1] == 0 {
+				r.pos += 2
+			} else {
+				b = 0
+			}
+		}
+		r.acc |= uint32(b) << (24 - r.n)
+		r.n += 8
+	}
+}
+
+// bits reads n <= 16 bits.
+func (r *bitReader) bits(n uint) int32 {
+	if n == 0 {
+		return 0
+	}
+	if r.n < n {
+		r.fill()
+	}
+	v := r.acc >> (32 - n)
+	r.acc <<= n
+	r.n -= n
+	return int32(v)
+}
+
+// receiveExtend reads an s-bit magnitude and extends its sign, as in section
+// F.2.2.1 of the JPEG specification.
+func (r *bitReader) receiveExtend(s uint) int32 {
+	v := r.bits(s)
+	if s > 0 && v < 1<<(s-1) {
+		v += -1<<s + 1
+	}
+	return v
+}
+
+// decode reads one Huffman-coded value.
+func (r *bitReader) decode(h *huffman) (byte, error) {
+	if r.n < 16 {
+		r.fill()
+	}
+	if e := h.lut[r.acc>>(32-lutBits)]; e != 0 {
+		n := uint(e >> 8)
+		r.acc <<= n
+		r.n -= n
+		return byte(e), nil
+	}
+
+	code := int32(0)
+	for l := 1; l <= 16; l++ {
+		code = code<<1 | r.bits(1)
+		if code <= h.maxcode[l] {
+			return h.vals[h.valptr[l]+code-h.mincode[l]], nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid JPEG Huffman code")
+}
+
+// restart discards the rest of the current byte and skips the RSTn marker at
+// the end of a restart interval.
+func (r *bitReader) restart() {
+	r.acc, r.n = 0, 0
+	for r.pos+1 < len(r.data) {
+		if r.data[r.pos] == 0xff && r.data[r.pos+1] >= rst0 && r.data[r.pos+1] <= rst7 {
+			r.pos += 2
+			return
+		}
+		// Corrupt data before the marker; resynchronize on it.
+		r.pos++
+	}
+	r.pos = len(r.data)
+}
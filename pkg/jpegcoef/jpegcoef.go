@@ -0,0 +1,348 @@
+// Package jpegcoef reads the quantized DCT coefficients of a JPEG file without
+// decoding it to pixels. JPEG steganography (JSteg, F5, OutGuess) embeds in
+// these coefficients, so this is where it has to be detected and removed.
+//
+// Baseline and progressive Huffman-coded files are supported. Arithmetic
+// coding, lossless and hierarchical JPEG are not.
+package jpegcoef
+
+import (
+	"fmt"
+	"image"
+)
+
+// Markers, from section B.1.1.3 of the JPEG specification.
+const (
+	sof0  = 0xc0 // Baseline
+	sof1  = 0xc1 // Extended sequential, Huffman
+	sof2  = 0xc2 // Progressive, Huffman
+	sof15 = 0xcf
+	dht   = 0xc4
+	dac   = 0xcc
+	rst0  = 0xd0
+	rst7  = 0xd7
+	soi   = 0xd8
+	eoi   = 0xd9
+	sos   = 0xda
+	dqt   = 0xdb
+	dri   = 0xdd
+	tem   = 0x01
+)
+
+// Block holds the 64 quantized DCT coefficients of an 8x8 block in zigzag
+// order, so Block[0] is the DC coefficient and Block[1:] the AC coefficients
+// from lowest to highest frequency.
+type Block [64]int16
+
+// ZigZag maps the zigzag index of a coefficient to its row-major index in the
+// 8x8 block.
+var ZigZag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// Component is one colour component of a JPEG, e.g. Y, Cb or Cr.
+type Component struct {
+	ID int
+	// H and V are the horizontal and vertical sampling factors.
+	H, V int
+	// Tq selects the quantization table in Image.Quant.
+	Tq int
+
+	// BlocksWide and BlocksHigh are the dimensions of Blocks, which cover
+	// the image padded out to a whole number of MCUs.
+	BlocksWide int
+	BlocksHigh int
+	Blocks     []Block
+}
+
+// Block returns the block in column bx and row by.
+func (c *Component) Block(bx, by int) *Block {
+	return &c.Blocks[by*c.BlocksWide+bx]
+}
+
+// BlockBounds returns the pixels of the image covered by block (bx, by) of c,
+// which is larger than 8x8 for subsampled components.
+func (img *Image) BlockBounds(c *Component, bx, by int) image.Rectangle {
+	w := 8 * img.hmax / c.H
+	h := 8 * img.vmax / c.V
+	return image.Rect(bx*w, by*h, (bx+1)*w, (by+1)*h)
+}
+
+// Segment is a marker segment which is kept as is, such as APPn or COM.
+type Segment struct {
+	Marker byte
+	// Data is the segment payload, without the marker and length.
+	Data []byte
+}
+
+// Image is the coefficient-domain contents of a JPEG file.
+type Image struct {
+	Width  int
+	Height int
+	// Progressive is whether the file was progressive.
+	Progressive bool
+	Components  []Component
+	// Quant are the quantization tables, in zigzag order.
+	Quant [4][64]uint16
+
+	// Segments are the marker segments before the first scan other than the
+	// tables, frame header and restart interval, in file order.
+	Segments []Segment
+	// Length is the offset just past the EOI marker. Anything after it is
+	// not part of the image.
+	Length int
+
+	hmax, vmax int
+	mcusWide   int
+	mcusHigh   int
+}
+
+// Decode parses the coefficients of a JPEG file.
+func Decode(data []byte) (*Image, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != soi {
+		return nil, fmt.Errorf("Not a JPEG file")
+	}
+
+	d := &decoder{data: data, img: &Image{}}
+	pos := 2
+	for {
+		if pos >= len(data) {
+			return nil, fmt.Errorf("Unexpected end of JPEG")
+		}
+		if data[pos] != 0xff {
+			return nil, fmt.Errorf("Invalid JPEG marker at offset %d", pos)
+		}
+		// Any number of 0xff fill bytes may precede a marker.
+		for pos+1 < len(data) && data[pos+1] == 0xff {
+			pos++
+		}
+		if pos+1 >= len(data) {
+			return nil, fmt.Errorf("Unexpected end of JPEG")
+		}
+		marker := data[pos+1]
+		pos += 2
+
+		if marker == eoi {
+			d.img.Length = pos
+			if d.img.Components == nil {
+				return nil, fmt.Errorf("JPEG has no frame")
+			}
+			return d.img, nil
+		}
+		if marker == tem || (marker >= rst0 && marker <= rst7) {
+			// Standalone markers
+			continue
+		}
+
+		if pos+2 > len(data) {
+			return nil, fmt.Errorf("Unexpected end of JPEG")
+		}
+		n := int(data[pos])<<8 | int(data[pos+1])
+		if n < 2 || pos+n > len(data) {
+			return nil, fmt.Errorf("Invalid JPEG segment length at offset %d", pos)
+		}
+		seg := data[pos+2 : pos+n]
+		pos += n
+
+		var err error
+		switch {
+		case marker == sof0 || marker == sof1 || marker == sof2:
+			err = d.parseSOF(seg, marker == sof2)
+		case marker == dht:
+			err = d.parseDHT(seg)
+		case marker == dac || (marker > sof2 && marker <= sof15):
+			err = fmt.Errorf("Unsupported JPEG: arithmetic, lossless or hierarchical coding")
+		case marker == dri:
+			err = d.parseDRI(seg)
+		case marker == sos:
+			pos, err = d.decodeScan(seg, pos)
+		case marker == dqt:
+			err = d.parseDQT(seg)
+		default:
+			d.keep(marker, seg)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// decoder holds the state of a Decode call.
+type decoder struct {
+	data []byte
+	img  *Image
+
+	dc, ac          [4]*huffman
+	restartInterval int
+	seenScan        bool
+	eobrun          int
+}
+
+// keep records a segment from before the first scan.
+func (d *decoder) keep(marker byte, seg []byte) {
+	if !d.seenScan {
+		d.img.Segments = append(d.img.Segments, Segment{Marker: marker, Data: seg})
+	}
+}
+
+func (d *decoder) parseSOF(seg []byte, progressive bool) error {
+	if d.img.Components != nil {
+		return fmt.Errorf("JPEG has more than one frame")
+	}
+	if len(seg) < 6 {
+		return fmt.Errorf("Invalid JPEG frame header")
+	}
+	if seg[0] != 8 {
+		return fmt.Errorf("Unsupported JPEG precision: %d bits", seg[0])
+	}
+
+	img := d.img
+	img.Progressive = progressive
+	img.Height = int(seg[1])<<8 | int(seg[2])
+	img.Width = int(seg[3])<<8 | int(seg[4])
+	nc := int(seg[5])
+	if img.Width == 0 || img.Height == 0 {
+		return fmt.Errorf("Unsupported JPEG: zero or deferred dimensions")
+	}
+	if nc < 1 || nc > 4 || len(seg) != 6+3*nc {
+		return fmt.Errorf("Invalid JPEG frame header")
+	}
+
+	img.Components = make([]Component, nc)
+	img.hmax, img.vmax = 1, 1
+	for i := range img.Components {
+		c := &img.Components[i]
+		c.ID = int(seg[6+3*i])
+		c.H = int(seg[7+3*i] >> 4)
+		c.V = int(seg[7+3*i] & 0x0f)
+		c.Tq = int(seg[8+3*i])
+		if c.H < 1 || c.H > 4 || c.V < 1 || c.V > 4 || c.Tq > 3 {
+			return fmt.Errorf("Invalid JPEG frame header")
+		}
+		if nc == 1 {
+			// A single component is never interleaved, so its MCU is a
+			// single block whatever its sampling factors.
+			c.H, c.V = 1, 1
+		}
+		if c.H > img.hmax {
+			img.hmax = c.H
+		}
+		if c.V > img.vmax {
+			img.vmax = c.V
+		}
+	}
+
+	img.mcusWide = (img.Width + 8*img.hmax - 1) / (8 * img.hmax)
+	img.mcusHigh = (img.Height + 8*img.vmax - 1) / (8 * img.vmax)
+	for i := range img.Components {
+		c := &img.Components[i]
+		c.BlocksWide = img.mcusWide * c.H
+		c.BlocksHigh = img.mcusHigh * c.V
+		c.Blocks = make([]Block, c.BlocksWide*c.BlocksHigh)
+	}
+
+	return nil
+}
+
+func (d *decoder) parseDQT(seg []byte) error {
+	for len(seg) > 0 {
+		pq, tq := seg[0]>>4, seg[0]&0x0f
+		if tq > 3 || pq > 1 {
+			return fmt.Errorf("Invalid JPEG quantization table")
+		}
+		seg = seg[1:]
+
+		if pq == 0 {
+			if len(seg) < 64 {
+				return fmt.Errorf("Invalid JPEG quantization table")
+			}
+			for k := 0; k < 64; k++ {
+				d.img.Quant[tq][k] = uint16(seg[k])
+			}
+			seg = seg[64:]
+		} else {
+			if len(seg) < 128 {
+				return fmt.Errorf("Invalid JPEG quantization table")
+			}
+			for k := 0; k < 64; k++ {
+				d.img.Quant[tq][k] = uint16(seg[2*k])<<8 | uint16(seg[2*k+1])
+			}
+			seg = seg[128:]
+		}
+	}
+	return nil
+}
+
+func (d *decoder) parseDHT(seg []byte) error {
+	for len(seg) > 0 {
+		if len(seg) < 17 {
+			return fmt.Errorf("Invalid JPEG Huffman table")
+		}
+		tc, th := seg[0]>>4, seg[0]&0x0f
+		if tc > 1 || th > 3 {
+			return fmt.Errorf("Invalid JPEG Huffman table")
+		}
+
+		var counts [16]int
+		total := 0
+		for i := range counts {
+			counts[i] = int(seg[1+i])
+			total += counts[i]
+		}
+		if len(seg) < 17+total {
+			return fmt.Errorf("Invalid JPEG Huffman table")
+		}
+
+		h, err := newHuffman(counts, seg[17:17+total])
+		if err != nil {
+			return err
+		}
+		if tc == 0 {
+			d.dc[th] = h
+		} else {
+			d.ac[th] = h
+		}
+		seg = seg[17+total:]
+	}
+	return nil
+}
+
+func (d *decoder) parseDRI(seg []byte) error {
+	if len(seg) != 2 {
+		return fmt.Errorf("Invalid JPEG restart interval")
+	}
+	d.restartInterval = int(seg[0])<<8 | int(seg[1])
+	return nil
+}
+
+// Walk calls fn on every block of every component, in the order a baseline
+// encoder writes them: MCU by MCU, with the blocks of each component in turn.
+func (img *Image) Walk(fn func(c *Component, b *Block)) {
+	if len(img.Components) == 1 {
+		c := &img.Components[0]
+		for i := range c.Blocks {
+			fn(c, &c.Blocks[i])
+		}
+		return
+	}
+
+	for my := 0; my < img.mcusHigh; my++ {
+		for mx := 0; mx < img.mcusWide; mx++ {
+			for i := range img.Components {
+				c := &img.Components[i]
+				for v := 0; v < c.V; v++ {
+					for h := 0; h < c.H; h++ {
+						fn(c, c.Block(mx*c.H+h, my*c.V+v))
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package jpegcoef
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os"
+	"testing"
+)
+
+func readTestFile(t testing.TB, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile("../../testfiles/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestDecodeMatchesImageJPEG checks the coefficients by transforming the luma
+// component back to pixels and comparing it with image/jpeg's output.
+func TestDecodeMatchesImageJPEG(t *testing.T) {
+	for _, name := range []string{"sample.jpg", "sampleprogressive.jpg", "samplegray.jpg"} {
+		data := readTestFile(t, name)
+
+		img, err := Decode(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		want, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want.Bounds().Dx() != img.Width || want.Bounds().Dy() != img.Height {
+			t.Fatalf("%s: size %dx%d, want %v", name, img.Width, img.Height, want.Bounds())
+		}
+
+		var luma []uint8
+		var stride int
+		switch m := want.(type) {
+		case *image.YCbCr:
+			luma, stride = m.Y, m.YStride
+		case *image.Gray:
+			luma, stride = m.Pix, m.Stride
+		default:
+			t.Fatalf("%s: unexpected image type %T", name, want)
+		}
+
+		c := &img.Components[0]
+		samples := c.Samples(&img.Quant[c.Tq])
+		worst := 0
+		for y := 0; y < img.Height; y++ {
+			for x := 0; x < img.Width; x++ {
+				d := int(samples[y*8*c.BlocksWide+x]) - int(luma[y*stride+x])
+				if d < 0 {
+					d = -d
+				}
+				if d > worst {
+					worst = d
+				}
+			}
+		}
+
+		// image/jpeg uses an integer IDCT, so it can differ by a level or two.
+		if worst > 2 {
+			t.Errorf("%s: luma differs from image/jpeg by up to %d", name, worst)
+		}
+		if n := len(img.Segments); n == 0 {
+			t.Errorf("%s: no segments kept", name)
+		}
+		if img.Length != len(data) {
+			t.Errorf("%s: Length = %d, want %d", name, img.Length, len(data))
+		}
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	for _, name := range []string{"sample.jpg", "sampleprogressive.jpg", "samplegray.jpg"} {
+		img, err := Decode(readTestFile(t, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := Encode(&buf, img); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		// image/jpeg must be able to read the output...
+		if _, err := jpeg.Decode(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Errorf("%s: image/jpeg: %v", name, err)
+		}
+
+		// ...and the coefficients must survive unchanged.
+		out, err := Decode(buf.Bytes())
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if out.Progressive || out.Width != img.Width || out.Height != img.Height || len(out.Segments) != len(img.Segments) {
+			t.Fatalf("%s: header changed", name)
+		}
+		for i := range img.Components {
+			for j := range img.Components[i].Blocks {
+				if out.Components[i].Blocks[j] != img.Components[i].Blocks[j] {
+					t.Fatalf("%s: component %d block %d changed", name, i, j)
+				}
+			}
+		}
+		if out.Quant != img.Quant {
+			t.Errorf("%s: quantization tables changed", name)
+		}
+	}
+}
+
+func TestDCTRoundTrip(t *testing.T) {
+	var q [64]uint16
+	for k := range q {
+		q[k] = 1
+	}
+
+	var in, out [64]float64
+	for i := range in {
+		in[i] = float64((i * 37) % 256)
+	}
+
+	var b Block
+	ForwardDCT(&in, &q, &b)
+	InverseDCT(&b, &q, &out)
+	for i := range in {
+		if d := out[i] - in[i]; d > 2 || d < -2 {
+			t.Fatalf("sample %d: %f after round trip, want %f", i, out[i], in[i])
+		}
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	data := readTestFile(t, "sampleprogressive.jpg")
+
+	for name, bad := range map[string][]byte{
+		"png":       []byte("\x89PNG\r\n\x1a\n"),
+		"truncated": data[:len(data)/2],
+		"empty":     nil,
+	} {
+		if _, err := Decode(bad); err == nil {
+			t.Errorf("%s: Decode succeeded", name)
+		}
+	}
+}
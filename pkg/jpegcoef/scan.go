@@ -0,0 +1,275 @@
+package jpegcoef
+
+import (
+	"fmt"
+)
+
+// scanComponent is a component taking part in a scan.
+type scanComponent struct {
+	c      *Component
+	dc, ac *huffman
+	pred   int32
+}
+
+// scan is a parsed SOS header.
+type scan struct {
+	comps  []scanComponent
+	ss, se int
+	ah, al uint
+}
+
+// decodeScan parses a scan header and decodes the entropy-coded data following
+// it at pos, returning the offset of the marker after the data.
+func (d *decoder) decodeScan(seg []byte, pos int) (int, error) {
+	img := d.img
+	if img.Components == nil {
+		return 0, fmt.Errorf("JPEG scan before frame header")
+	}
+	d.seenScan = true
+
+	if len(seg) < 1 {
+		return 0, fmt.Errorf("Invalid JPEG scan header")
+	}
+	ns := int(seg[0])
+	if ns < 1 || ns > len(img.Components) || len(seg) != 4+2*ns {
+		return 0, fmt.Errorf("Invalid JPEG scan header")
+	}
+
+	s := scan{
+		comps: make([]scanComponent, ns),
+		ss:    int(seg[1+2*ns]),
+		se:    int(seg[2+2*ns]),
+		ah:    uint(seg[3+2*ns] >> 4),
+		al:    uint(seg[3+2*ns] & 0x0f),
+	}
+	if s.ss > s.se || s.se > 63 || s.ah > 13 || s.al > 13 {
+		return 0, fmt.Errorf("Invalid JPEG scan header")
+	}
+	if !img.Progressive && (s.ss != 0 || s.se != 63 || s.ah != 0 || s.al != 0) {
+		return 0, fmt.Errorf("Invalid JPEG scan header")
+	}
+	if img.Progressive && s.ss > 0 && ns != 1 {
+		return 0, fmt.Errorf("Invalid JPEG scan header")
+	}
+
+	for i := range s.comps {
+		id := int(seg[1+2*i])
+		for j := range img.Components {
+			if img.Components[j].ID == id {
+				s.comps[i].c = &img.Components[j]
+			}
+		}
+		if s.comps[i].c == nil {
+			return 0, fmt.Errorf("Invalid JPEG scan header")
+		}
+
+		td, ta := seg[2+2*i]>>4, seg[2+2*i]&0x0f
+		if td > 3 || ta > 3 {
+			return 0, fmt.Errorf("Invalid JPEG scan header")
+		}
+		s.comps[i].dc, s.comps[i].ac = d.dc[td], d.ac[ta]
+		if (s.ss == 0 && s.ah == 0 && s.comps[i].dc == nil) || (s.se > 0 && s.comps[i].ac == nil) {
+			return 0, fmt.Errorf("JPEG scan uses an undefined Huffman table")
+		}
+	}
+
+	// The entropy-coded data runs up to the first marker other than RSTn.
+	end := pos
+	for end+1 < len(d.data) {
+		if d.data[end] == 0xff {
+			m := d.data[end+1]
+			if m != 0 && (m < rst0 || m > rst7) {
+				break
+			}
+			end += 2
+			continue
+		}
+		end++
+	}
+	if end+1 >= len(d.data) {
+		end = len(d.data)
+	}
+
+	r := &bitReader{data: d.data[pos:end]}
+	d.eobrun = 0
+
+	mcu := 0
+	next := func() {
+		mcu++
+		if d.restartInterval > 0 && mcu%d.restartInterval == 0 {
+			r.restart()
+			d.eobrun = 0
+			for i := range s.comps {
+				s.comps[i].pred = 0
+			}
+		}
+	}
+
+	if ns == 1 {
+		// A non-interleaved scan only covers the blocks inside the image.
+		sc := &s.comps[0]
+		c := sc.c
+		wide := ((img.Width*c.H+img.hmax-1)/img.hmax + 7) / 8
+		high := ((img.Height*c.V+img.vmax-1)/img.vmax + 7) / 8
+		for by := 0; by < high; by++ {
+			for bx := 0; bx < wide; bx++ {
+				if err := d.decodeBlock(r, &s, sc, c.Block(bx, by)); err != nil {
+					return 0, err
+				}
+				next()
+			}
+		}
+		return end, nil
+	}
+
+	for my := 0; my < img.mcusHigh; my++ {
+		for mx := 0; mx < img.mcusWide; mx++ {
+			for i := range s.comps {
+				sc := &s.comps[i]
+				c := sc.c
+				for v := 0; v < c.V; v++ {
+					for h := 0; h < c.H; h++ {
+						if err := d.decodeBlock(r, &s, sc, c.Block(mx*c.H+h, my*c.V+v)); err != nil {
+							return 0, err
+						}
+					}
+				}
+			}
+			next()
+		}
+	}
+	return end, nil
+}
+
+// decodeBlock decodes the part of a block covered by a scan, following
+// sections F.2.2 and G.1.2 of the JPEG specification. A sequential scan is a
+// progressive first scan over the whole block.
+func (d *decoder) decodeBlock(r *bitReader, s *scan, sc *scanComponent, b *Block) error {
+	if s.ss == 0 {
+		if s.ah == 0 {
+			t, err := r.decode(sc.dc)
+			if err != nil {
+				return err
+			}
+			if t > 11 {
+				return fmt.Errorf("Invalid JPEG DC coefficient")
+			}
+			sc.pred += r.receiveExtend(uint(t))
+			b[0] = int16(sc.pred << s.al)
+		} else if r.bits(1) != 0 {
+			b[0] |= 1 << s.al
+		}
+	}
+
+	k := s.ss
+	if k == 0 {
+		k = 1
+	}
+	if k > s.se {
+		return nil
+	}
+
+	if s.ah != 0 {
+		return d.refineAC(r, s, sc, b, k)
+	}
+
+	if d.eobrun > 0 {
+		d.eobrun--
+		return nil
+	}
+	for ; k <= s.se; k++ {
+		rs, err := r.decode(sc.ac)
+		if err != nil {
+			return err
+		}
+		run, size := int(rs>>4), uint(rs&0x0f)
+		if size == 0 {
+			if run < 15 {
+				// End of block, or in a progressive scan the first of a
+				// run of 2^run + extra empty blocks.
+				d.eobrun = 1<<run - 1 + int(r.bits(uint(run)))
+				break
+			}
+			// A run of 16 zeros
+			k += 15
+			continue
+		}
+
+		k += run
+		if k > 63 {
+			return fmt.Errorf("Invalid JPEG AC coefficient")
+		}
+		b[k] = int16(r.receiveExtend(size) << s.al)
+	}
+	return nil
+}
+
+// refineAC decodes a progressive AC refinement scan, which adds one bit to
+// each coefficient already known to be nonzero, and sets newly nonzero ones
+// to +-1 << al.
+func (d *decoder) refineAC(r *bitReader, s *scan, sc *scanComponent, b *Block, k int) error {
+	p1 := int16(1) << s.al
+	m1 := int16(-1) << s.al
+
+	// refine adds the correction bit to a coefficient which is already
+	// nonzero.
+	refine := func(k int) {
+		if r.bits(1) != 0 && b[k]&p1 == 0 {
+			if b[k] >= 0 {
+				b[k] += p1
+			} else {
+				b[k] += m1
+			}
+		}
+	}
+
+	if d.eobrun == 0 {
+		for ; k <= s.se; k++ {
+			rs, err := r.decode(sc.ac)
+			if err != nil {
+				return err
+			}
+			run, size := int(rs>>4), rs&0x0f
+
+			var v int16
+			if size != 0 {
+				if size != 1 {
+					return fmt.Errorf("Invalid JPEG AC refinement")
+				}
+				v = m1
+				if r.bits(1) != 0 {
+					v = p1
+				}
+			} else if run != 15 {
+				d.eobrun = 1<<run + int(r.bits(uint(run)))
+				break
+			}
+
+			// Skip run zero coefficients, refining the nonzero ones on the
+			// way, and place v at the next zero.
+			for ; k <= s.se; k++ {
+				if b[k] != 0 {
+					refine(k)
+				} else {
+					if run == 0 {
+						break
+					}
+					run--
+				}
+			}
+			if v != 0 && k <= s.se {
+				b[k] = v
+			}
+		}
+	}
+
+	if d.eobrun > 0 {
+		for ; k <= s.se; k++ {
+			if b[k] != 0 {
+				refine(k)
+			}
+		}
+		d.eobrun--
+	}
+	return nil
+}
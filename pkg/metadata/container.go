@@ -0,0 +1,428 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/standardrhyme/stegsecure/pkg/pngchunk"
+)
+
+// Signatures of the JPEG segments and PNG chunks holding metadata.
+const (
+	exifHeader        = "Exif\x00\x00"
+	xmpHeader         = "http://ns.adobe.com/xap/1.0/\x00"
+	xmpExtendedHeader = "http://ns.adobe.com/xmp/extension/\x00"
+	photoshopHeader   = "Photoshop 3.0\x00"
+	xmpKeyword        = "XML:com.adobe.xmp"
+	rawProfilePrefix  = "Raw profile type "
+
+	app1  = 0xe1
+	app13 = 0xed
+	com   = 0xfe
+	sos   = 0xda
+	eoi   = 0xd9
+)
+
+// maxText is the most a compressed PNG text chunk is inflated to.
+const maxText = 1 << 20
+
+// segment is a marker segment of a JPEG.
+type segment struct {
+	marker byte
+	// start and end are the offsets of the marker and just past the
+	// segment.
+	start, end int
+	data       []byte
+}
+
+// kind returns what metadata a segment holds, if any.
+func (s segment) kind() string {
+	switch {
+	case s.marker == app1 && bytes.HasPrefix(s.data, []byte(exifHeader)):
+		return "EXIF"
+	case s.marker == app1 && bytes.HasPrefix(s.data, []byte(xmpHeader)):
+		return "XMP"
+	case s.marker == app1 && bytes.HasPrefix(s.data, []byte(xmpExtendedHeader)):
+		return "ExtendedXMP"
+	case s.marker == app13 && bytes.HasPrefix(s.data, []byte(photoshopHeader)):
+		return "IPTC"
+	case s.marker == com:
+		return "Comment"
+	}
+	return ""
+}
+
+// jpegSegments returns the marker segments of a JPEG up to its first scan,
+// and the offset of the marker they end at.
+func jpegSegments(data []byte) ([]segment, int, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, 0, fmt.Errorf("Not a JPEG file")
+	}
+
+	var segments []segment
+	for pos := 2; ; {
+		start := pos
+		for pos < len(data) && data[pos] == 0xff {
+			pos++
+		}
+		if pos >= len(data) || pos == start {
+			return nil, 0, fmt.Errorf("Invalid JPEG marker at offset %d", start)
+		}
+		marker := data[pos]
+		pos++
+		if marker == sos || marker == eoi {
+			return segments, start, nil
+		}
+		if marker >= 0xd0 && marker <= 0xd7 || marker == 0x01 {
+			continue
+		}
+
+		if pos+2 > len(data) {
+			return nil, 0, fmt.Errorf("Unexpected end of JPEG")
+		}
+		n := int(binary.BigEndian.Uint16(data[pos:]))
+		if n < 2 || pos+n > len(data) {
+			return nil, 0, fmt.Errorf("Invalid JPEG segment at offset %d", start)
+		}
+		segments = append(segments, segment{marker: marker, start: start, end: pos + n, data: data[pos+2 : pos+n]})
+		pos += n
+	}
+}
+
+func (m *Metadata) extractJPEG(data []byte) error {
+	segments, _, err := jpegSegments(data)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range segments {
+		switch s.kind() {
+		case "EXIF":
+			if err := m.extractEXIF(s.data[len(exifHeader):]); err != nil {
+				return err
+			}
+		case "XMP":
+			m.extractXMP(s.data[len(xmpHeader):])
+		case "ExtendedXMP":
+			// A part of a packet too large for one segment, after its
+			// GUID, full length and offset.
+			if at := len(xmpExtendedHeader) + 40; len(s.data) > at {
+				m.Fields = append(m.Fields, Field{Source: "XMP", Name: "ExtendedXMP", Value: string(s.data[at:])})
+			}
+		case "IPTC":
+			m.extractPhotoshop(s.data[len(photoshopHeader):])
+		case "Comment":
+			m.Fields = append(m.Fields, Field{Source: "Comment", Name: "COM", Value: string(s.data)})
+		}
+	}
+	return nil
+}
+
+func stripJPEG(data []byte, mode Mode) ([]byte, error) {
+	segments, scan, err := jpegSegments(data)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]byte(nil), data[:2]...)
+	// Extended XMP goes with the packet it extends.
+	xmpDropped := false
+	for _, s := range segments {
+		switch kind := s.kind(); {
+		case kind == "":
+		case mode == StripAll:
+			continue
+		case kind == "EXIF":
+			seg := append([]byte(nil), data[s.start:s.end]...)
+			header := s.end - len(s.data) - s.start + len(exifHeader)
+			if err := scrubEXIF(seg[header:], mode); err != nil {
+				return nil, err
+			}
+			out = append(out, seg...)
+			continue
+		case kind == "XMP" && xmpPrivate(s.data[len(xmpHeader):]):
+			xmpDropped = true
+			continue
+		case kind == "ExtendedXMP" && xmpDropped:
+			continue
+		}
+		out = append(out, data[s.start:s.end]...)
+	}
+	return append(out, data[scan:]...), nil
+}
+
+// text returns the keyword and text of a PNG text chunk.
+func text(c pngchunk.Chunk) (string, string, error) {
+	i := bytes.IndexByte(c.Data, 0)
+	if i < 0 {
+		return "", "", fmt.Errorf("PNG %s chunk has no keyword", c.Type)
+	}
+	keyword, rest := string(c.Data[:i]), c.Data[i+1:]
+
+	compressed := false
+	switch c.Type {
+	case "zTXt":
+		if len(rest) < 1 {
+			return "", "", fmt.Errorf("Invalid PNG zTXt chunk")
+		}
+		compressed, rest = true, rest[1:]
+	case "iTXt":
+		// Compression flag and method, then language and translated
+		// keyword.
+		if len(rest) < 2 {
+			return "", "", fmt.Errorf("Invalid PNG iTXt chunk")
+		}
+		compressed = rest[0] != 0
+		rest = rest[2:]
+		for k := 0; k < 2; k++ {
+			i := bytes.IndexByte(rest, 0)
+			if i < 0 {
+				return "", "", fmt.Errorf("Invalid PNG iTXt chunk")
+			}
+			rest = rest[i+1:]
+		}
+	}
+
+	if compressed {
+		r, err := zlib.NewReader(bytes.NewReader(rest))
+		if err != nil {
+			return "", "", err
+		}
+		rest, err = io.ReadAll(io.LimitReader(r, maxText))
+		if err != nil {
+			return "", "", err
+		}
+	}
+	return keyword, string(rest), nil
+}
+
+func (m *Metadata) extractPNG(data []byte) error {
+	chunks, err := pngchunk.Parse(data)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range chunks {
+		switch c.Type {
+		case "eXIf":
+			if err := m.extractEXIF(c.Data); err != nil {
+				return err
+			}
+		case "tEXt", "zTXt", "iTXt":
+			keyword, value, err := text(c)
+			if err != nil {
+				return err
+			}
+			if keyword == xmpKeyword {
+				m.extractXMP([]byte(value))
+			} else if profile, ok := rawProfile(keyword, value); ok {
+				if err := m.extractProfile(keyword, profile); err != nil {
+					return err
+				}
+			} else {
+				m.Fields = append(m.Fields, Field{Source: "Comment", Name: keyword, Value: value})
+			}
+		}
+	}
+	return nil
+}
+
+// rawProfile decodes the hex-encoded metadata ImageMagick stores in PNG text
+// chunks named "Raw profile type exif", "xmp" or "iptc": a line with the
+// type, one with the length, then the bytes in hex.
+func rawProfile(keyword, value string) ([]byte, bool) {
+	if !strings.HasPrefix(keyword, rawProfilePrefix) {
+		return nil, false
+	}
+	lines := strings.SplitN(strings.TrimLeft(value, "\n"), "\n", 3)
+	if len(lines) < 3 {
+		return nil, false
+	}
+	profile, err := hex.DecodeString(strings.Join(strings.Fields(lines[2]), ""))
+	if err != nil {
+		return nil, false
+	}
+	return profile, true
+}
+
+// extractProfile adds the fields of a raw profile.
+func (m *Metadata) extractProfile(keyword string, profile []byte) error {
+	switch strings.TrimPrefix(keyword, rawProfilePrefix) {
+	case "exif", "APP1":
+		return m.extractEXIF(bytes.TrimPrefix(profile, []byte(exifHeader)))
+	case "xmp":
+		m.extractXMP(profile)
+	case "iptc", "8bim":
+		if bytes.HasPrefix(profile, []byte("8BIM")) {
+			m.extractPhotoshop(profile)
+		} else {
+			m.extractIIM(profile)
+		}
+	}
+	return nil
+}
+
+func stripPNG(data []byte, mode Mode) ([]byte, error) {
+	chunks, err := pngchunk.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := false
+	kept := chunks[:0:0]
+	for _, c := range chunks {
+		switch c.Type {
+		case "eXIf":
+			if mode == StripAll {
+				changed = true
+				continue
+			}
+			c.Data = append([]byte(nil), c.Data...)
+			if err := scrubEXIF(c.Data, mode); err != nil {
+				return nil, err
+			}
+			changed = true
+		case "tEXt", "zTXt", "iTXt", "tIME":
+			if mode == StripAll {
+				changed = true
+				continue
+			}
+			keyword, value, err := text(c)
+			if err != nil {
+				break
+			}
+			if keyword == xmpKeyword && xmpPrivate([]byte(value)) {
+				changed = true
+				continue
+			}
+			// Raw profiles are dropped rather than rewritten if they hold
+			// anything private.
+			if profile, ok := rawProfile(keyword, value); ok {
+				var p Metadata
+				if p.extractProfile(keyword, profile) != nil || p.private() {
+					changed = true
+					continue
+				}
+			}
+		}
+		kept = append(kept, c)
+	}
+	if !changed {
+		return data, nil
+	}
+
+	iend := chunks[len(chunks)-1]
+	return append(pngchunk.Encode(kept), data[iend.Offset+12+len(iend.Data):]...), nil
+}
+
+// VP8X flags for the metadata chunks of a WebP.
+const (
+	vp8xXMP  = 0x04
+	vp8xEXIF = 0x08
+)
+
+// webpChunk is a chunk of a WebP, with its offset in the file.
+type webpChunk struct {
+	fourCC string
+	data   []byte
+}
+
+// webpChunks returns the chunks of a WebP and the offset of the end of the
+// RIFF container.
+func webpChunks(data []byte) ([]webpChunk, int, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, 0, fmt.Errorf("Not a WebP file")
+	}
+	end := 8 + int64(binary.LittleEndian.Uint32(data[4:]))
+	if end > int64(len(data)) {
+		return nil, 0, fmt.Errorf("WebP is truncated")
+	}
+
+	var chunks []webpChunk
+	for pos := int64(12); pos+8 <= end; {
+		size := int64(binary.LittleEndian.Uint32(data[pos+4:]))
+		if pos+8+size > end {
+			return nil, 0, fmt.Errorf("WebP chunk at offset %d runs past the end of the file", pos)
+		}
+		chunks = append(chunks, webpChunk{fourCC: string(data[pos : pos+4]), data: data[pos+8 : pos+8+size]})
+		pos += 8 + size + size&1
+	}
+	return chunks, int(end), nil
+}
+
+// webpEXIF returns the TIFF structure of an EXIF chunk, which some writers
+// prefix with the JPEG EXIF header.
+func webpEXIF(b []byte) []byte {
+	return bytes.TrimPrefix(b, []byte(exifHeader))
+}
+
+func (m *Metadata) extractWebP(data []byte) error {
+	chunks, _, err := webpChunks(data)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range chunks {
+		switch c.fourCC {
+		case "EXIF":
+			if err := m.extractEXIF(webpEXIF(c.data)); err != nil {
+				return err
+			}
+		case "XMP ":
+			m.extractXMP(c.data)
+		}
+	}
+	return nil
+}
+
+func stripWebP(data []byte, mode Mode) ([]byte, error) {
+	chunks, end, err := webpChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var dropped byte
+	kept := chunks[:0:0]
+	for _, c := range chunks {
+		switch c.fourCC {
+		case "EXIF":
+			if mode == StripAll {
+				dropped |= vp8xEXIF
+				continue
+			}
+			c.data = append([]byte(nil), c.data...)
+			if err := scrubEXIF(webpEXIF(c.data), mode); err != nil {
+				return nil, err
+			}
+		case "XMP ":
+			if mode == StripAll || xmpPrivate(c.data) {
+				dropped |= vp8xXMP
+				continue
+			}
+		}
+		kept = append(kept, c)
+	}
+
+	out := append([]byte(nil), data[:12]...)
+	for _, c := range kept {
+		if c.fourCC == "VP8X" && len(c.data) > 0 {
+			c.data = append([]byte(nil), c.data...)
+			c.data[0] &^= dropped
+		}
+		var header [8]byte
+		copy(header[:], c.fourCC)
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(c.data)))
+		out = append(out, header[:]...)
+		out = append(out, c.data...)
+		if len(c.data)%2 != 0 {
+			out = append(out, 0)
+		}
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return append(out, data[end:]...), nil
+}
@@ -0,0 +1,412 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// Tags of the EXIF and TIFF specifications.
+const (
+	tJPEGInterchangeFormat       = 513
+	tJPEGInterchangeFormatLength = 514
+	tXMP                         = 700
+	tIPTC                        = 33723
+	tPhotoshop                   = 34377
+	tExifIFD                     = 34665
+	tICCProfile                  = 34675
+	tGPSIFD                      = 34853
+	tImageSourceData             = 37724
+	tMakerNote                   = 0x927c
+	tUserComment                 = 0x9286
+	tInteropIFD                  = 40965
+	tCameraOwnerName             = 0xa430
+	tBodySerialNumber            = 0xa431
+	tLensSerialNumber            = 0xa435
+)
+
+// Field types of the TIFF specification.
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeUndefined = 7
+)
+
+var typeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4}
+
+// tagNames are the names of the descriptive EXIF tags.
+var tagNames = map[uint16]string{
+	0x010d:            "DocumentName",
+	0x010e:            "ImageDescription",
+	0x010f:            "Make",
+	0x0110:            "Model",
+	0x011d:            "PageName",
+	0x0131:            "Software",
+	0x0132:            "DateTime",
+	0x013b:            "Artist",
+	0x013c:            "HostComputer",
+	0x8298:            "Copyright",
+	0x9003:            "DateTimeOriginal",
+	0x9004:            "DateTimeDigitized",
+	tMakerNote:        "MakerNote",
+	tUserComment:      "UserComment",
+	0x9c9b:            "XPTitle",
+	0x9c9c:            "XPComment",
+	0x9c9d:            "XPAuthor",
+	0x9c9e:            "XPKeywords",
+	0x9c9f:            "XPSubject",
+	0xa420:            "ImageUniqueID",
+	tCameraOwnerName:  "CameraOwnerName",
+	tBodySerialNumber: "BodySerialNumber",
+	0xa433:            "LensMake",
+	0xa434:            "LensModel",
+	tLensSerialNumber: "LensSerialNumber",
+}
+
+// gpsNames are the names of the tags of the GPS IFD.
+var gpsNames = map[uint16]string{
+	0: "GPSVersionID", 1: "GPSLatitudeRef", 2: "GPSLatitude", 3: "GPSLongitudeRef",
+	4: "GPSLongitude", 5: "GPSAltitudeRef", 6: "GPSAltitude", 7: "GPSTimeStamp",
+	18: "GPSMapDatum", 27: "GPSProcessingMethod", 29: "GPSDateStamp",
+}
+
+// tiffReader reads the IFDs of a TIFF structure, which is what EXIF
+// metadata is stored as.
+type tiffReader struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+// entry is an IFD entry.
+type entry struct {
+	tag, typ uint16
+	count    int
+	// pos is the offset of the entry in the TIFF structure.
+	pos int
+	// value is the value of the entry, and at its offset, or -1 if it is
+	// stored in the entry itself.
+	value []byte
+	at    int
+}
+
+// uint returns the k-th value of an integer entry.
+func (r *tiffReader) uint(e entry, k int) uint32 {
+	if k >= e.count {
+		return 0
+	}
+	switch e.typ {
+	case typeByte, typeUndefined:
+		return uint32(e.value[k])
+	case typeShort:
+		return uint32(r.order.Uint16(e.value[2*k:]))
+	case typeLong, 13:
+		return r.order.Uint32(e.value[4*k:])
+	}
+	return 0
+}
+
+// newTIFFReader returns a reader for the TIFF structure in b, and the offset
+// of its first IFD.
+func newTIFFReader(b []byte) (*tiffReader, uint32, error) {
+	if len(b) < 8 {
+		return nil, 0, fmt.Errorf("Invalid EXIF header")
+	}
+	r := &tiffReader{b: b}
+	switch string(b[:4]) {
+	case "II*\x00":
+		r.order = binary.LittleEndian
+	case "MM\x00*":
+		r.order = binary.BigEndian
+	default:
+		return nil, 0, fmt.Errorf("Invalid EXIF header")
+	}
+	return r, r.order.Uint32(b[4:]), nil
+}
+
+// ifd returns the entries of the IFD at offset, and the offset of the next
+// IFD.
+func (r *tiffReader) ifd(offset uint32) ([]entry, uint32, error) {
+	pos := int64(offset)
+	if pos+2 > int64(len(r.b)) {
+		return nil, 0, fmt.Errorf("Invalid EXIF IFD offset %d", offset)
+	}
+	n := int64(r.order.Uint16(r.b[pos:]))
+	if pos+2+12*n+4 > int64(len(r.b)) {
+		return nil, 0, fmt.Errorf("Invalid EXIF IFD at offset %d", offset)
+	}
+
+	var entries []entry
+	for k := 0; k < int(n); k++ {
+		p := int(pos) + 2 + 12*k
+		e := entry{
+			tag:   r.order.Uint16(r.b[p:]),
+			typ:   r.order.Uint16(r.b[p+2:]),
+			count: int(r.order.Uint32(r.b[p+4:])),
+			pos:   p,
+			at:    -1,
+		}
+		size, ok := typeSizes[e.typ]
+		if !ok || e.count < 0 || e.count > len(r.b) {
+			// Skip entries which can't be read, as readers do.
+			continue
+		}
+		if size*e.count <= 4 {
+			e.value = r.b[p+8 : p+8+size*e.count]
+		} else {
+			e.at = int(r.order.Uint32(r.b[p+8:]))
+			if e.at < 0 || e.at+size*e.count > len(r.b) {
+				continue
+			}
+			e.value = r.b[e.at : e.at+size*e.count]
+		}
+		entries = append(entries, e)
+	}
+	return entries, r.order.Uint32(r.b[pos+2+12*n:]), nil
+}
+
+// extractEXIF adds the fields of the EXIF metadata in b, and its thumbnail.
+func (m *Metadata) extractEXIF(b []byte) error {
+	r, first, err := newTIFFReader(b)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[uint32]bool)
+	var walk func(offset uint32, source string) error
+	walk = func(offset uint32, source string) error {
+		if offset == 0 {
+			return nil
+		}
+		if seen[offset] {
+			return fmt.Errorf("EXIF IFDs form a loop")
+		}
+		seen[offset] = true
+
+		entries, _, err := r.ifd(offset)
+		if err != nil {
+			return err
+		}
+
+		var thumb, thumbLen int
+		for _, e := range entries {
+			switch {
+			case e.tag == tExifIFD && source == "EXIF":
+				err = walk(r.uint(e, 0), "EXIF")
+			case e.tag == tGPSIFD && source == "EXIF":
+				err = walk(r.uint(e, 0), "GPS")
+			case e.tag == tXMP:
+				m.extractXMP(e.value)
+			case e.tag == tIPTC:
+				m.extractIIM(e.value)
+			case e.tag == tJPEGInterchangeFormat:
+				thumb = int(r.uint(e, 0))
+			case e.tag == tJPEGInterchangeFormatLength:
+				thumbLen = int(r.uint(e, 0))
+			case source == "GPS":
+				name, ok := gpsNames[e.tag]
+				if !ok {
+					name = fmt.Sprintf("GPS0x%04x", e.tag)
+				}
+				m.Fields = append(m.Fields, Field{Source: source, Name: name, Value: r.format(e)})
+			default:
+				if name, ok := tagNames[e.tag]; ok {
+					m.Fields = append(m.Fields, Field{Source: source, Name: name, Value: r.format(e)})
+				} else if e.typ == typeASCII {
+					m.Fields = append(m.Fields, Field{Source: source, Name: fmt.Sprintf("0x%04x", e.tag), Value: r.format(e)})
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if thumb > 0 && thumbLen > 0 && thumb+thumbLen <= len(b) {
+			m.Thumbnail = b[thumb : thumb+thumbLen]
+		}
+		return nil
+	}
+
+	// IFD0 describes the image, and IFD1, if any, its thumbnail. In TIFF
+	// files, the IFDs after the first are the other pages.
+	for offset := first; offset != 0; {
+		if err := walk(offset, "EXIF"); err != nil {
+			return err
+		}
+		_, next, _ := r.ifd(offset)
+		if seen[next] {
+			break
+		}
+		offset = next
+	}
+	return nil
+}
+
+// format returns the value of an entry as text.
+func (r *tiffReader) format(e entry) string {
+	switch {
+	case e.typ == typeASCII:
+		return strings.TrimRight(string(e.value), "\x00")
+	case e.tag == tUserComment && len(e.value) >= 8:
+		// The first 8 bytes name the character set.
+		if string(e.value[:8]) == "UNICODE\x00" {
+			return decodeUTF16(e.value[8:], r.order)
+		}
+		return strings.TrimRight(string(e.value[8:]), "\x00 ")
+	case e.tag >= 0x9c9b && e.tag <= 0x9c9f:
+		// The XP tags are always UTF-16LE.
+		return decodeUTF16(e.value, binary.LittleEndian)
+	case e.typ == typeUndefined:
+		return string(e.value)
+	case e.typ == typeRational:
+		parts := make([]string, e.count)
+		for k := range parts {
+			parts[k] = fmt.Sprintf("%d/%d", r.order.Uint32(e.value[8*k:]), r.order.Uint32(e.value[8*k+4:]))
+		}
+		return strings.Join(parts, ",")
+	case e.typ == typeByte || e.typ == typeShort || e.typ == typeLong:
+		parts := make([]string, e.count)
+		for k := range parts {
+			parts[k] = fmt.Sprint(r.uint(e, k))
+		}
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprintf("% x", e.value)
+}
+
+func decodeUTF16(b []byte, order binary.ByteOrder) string {
+	units := make([]uint16, len(b)/2)
+	for k := range units {
+		units[k] = order.Uint16(b[2*k:])
+	}
+	return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+}
+
+// scrubEXIF removes the tags mode strips from the EXIF metadata in b, in
+// place. The entries are removed from their IFDs and their values are zeroed,
+// but nothing moves, so offsets elsewhere stay valid.
+func scrubEXIF(b []byte, mode Mode) error {
+	r, first, err := newTIFFReader(b)
+	if err != nil {
+		return err
+	}
+
+	drop := func(e entry) bool {
+		switch e.tag {
+		case tGPSIFD, tMakerNote, tCameraOwnerName, tBodySerialNumber, tLensSerialNumber:
+			return true
+		case tXMP:
+			return mode == StripAll || xmpPrivate(e.value)
+		}
+		if mode != StripAll {
+			return false
+		}
+		switch e.tag {
+		case tExifIFD, tIPTC, tPhotoshop, tImageSourceData, tInteropIFD:
+			return true
+		}
+		_, named := tagNames[e.tag]
+		return named || e.typ == typeASCII
+	}
+
+	seen := make(map[uint32]bool)
+	var scrub func(offset uint32) error
+	scrub = func(offset uint32) error {
+		if offset == 0 || seen[offset] {
+			return nil
+		}
+		seen[offset] = true
+
+		entries, next, err := r.ifd(offset)
+		if err != nil {
+			return err
+		}
+
+		dropped := make(map[int]bool)
+		for _, e := range entries {
+			if !drop(e) {
+				if e.tag == tExifIFD {
+					if err := scrub(r.uint(e, 0)); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			dropped[e.pos] = true
+			switch e.tag {
+			case tExifIFD, tGPSIFD, tInteropIFD:
+				r.zeroIFD(r.uint(e, 0), seen)
+			}
+			if e.at >= 0 {
+				zero(e.value)
+			}
+		}
+		if len(dropped) == 0 {
+			return nil
+		}
+
+		// Rewrite the entries kept over the old ones, then the offset of
+		// the next IFD, and zero what is left.
+		n := int(r.order.Uint16(b[offset:]))
+		var ifd bytes.Buffer
+		var word [4]byte
+		r.order.PutUint16(word[:], uint16(n-len(dropped)))
+		ifd.Write(word[:2])
+		for k := 0; k < n; k++ {
+			if pos := int(offset) + 2 + 12*k; !dropped[pos] {
+				ifd.Write(b[pos : pos+12])
+			}
+		}
+		r.order.PutUint32(word[:], next)
+		ifd.Write(word[:])
+
+		region := b[offset : int(offset)+2+12*n+4]
+		zero(region[copy(region, ifd.Bytes()):])
+		return nil
+	}
+
+	for offset := first; offset != 0 && !seen[offset]; {
+		_, next, err := r.ifd(offset)
+		if err != nil {
+			return err
+		}
+		if err := scrub(offset); err != nil {
+			return err
+		}
+		offset = next
+	}
+	return nil
+}
+
+// zeroIFD zeroes the IFD at offset and the values it points to.
+func (r *tiffReader) zeroIFD(offset uint32, seen map[uint32]bool) {
+	if offset == 0 || seen[offset] {
+		return
+	}
+	seen[offset] = true
+
+	entries, _, err := r.ifd(offset)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.tag == tInteropIFD {
+			r.zeroIFD(r.uint(e, 0), seen)
+		}
+		if e.at >= 0 {
+			zero(e.value)
+		}
+	}
+	n := int(r.order.Uint16(r.b[offset:]))
+	zero(r.b[offset : int(offset)+2+12*n+4])
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
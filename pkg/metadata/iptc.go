@@ -0,0 +1,86 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// resourceIPTC is the ID of the Photoshop image resource holding IPTC data.
+const resourceIPTC = 0x0404
+
+// iimNames are the names of the datasets of the IPTC application record.
+var iimNames = map[byte]string{
+	5:   "ObjectName",
+	25:  "Keywords",
+	40:  "SpecialInstructions",
+	55:  "DateCreated",
+	80:  "By-line",
+	90:  "City",
+	92:  "Sub-location",
+	95:  "Province-State",
+	101: "Country",
+	105: "Headline",
+	110: "Credit",
+	115: "Source",
+	116: "CopyrightNotice",
+	120: "Caption-Abstract",
+	122: "Writer-Editor",
+}
+
+// extractPhotoshop adds the IPTC data in Photoshop image resources, as
+// stored in the APP13 segment of JPEGs.
+func (m *Metadata) extractPhotoshop(b []byte) {
+	for pos := 0; pos+12 <= len(b) && string(b[pos:pos+4]) == "8BIM"; {
+		id := binary.BigEndian.Uint16(b[pos+4:])
+		// The name is a Pascal string padded to an even length.
+		name := 1 + int(b[pos+6])
+		name += name & 1
+		at := pos + 6 + name
+		if at+4 > len(b) {
+			return
+		}
+		size := int(binary.BigEndian.Uint32(b[at:]))
+		at += 4
+		if size < 0 || at+size > len(b) {
+			return
+		}
+
+		if id == resourceIPTC {
+			m.extractIIM(b[at : at+size])
+		}
+		pos = at + size + size&1
+	}
+}
+
+// extractIIM adds the datasets of the application record of IPTC IIM data.
+func (m *Metadata) extractIIM(b []byte) {
+	for pos := 0; pos+5 <= len(b) && b[pos] == 0x1c; {
+		record, dataset := b[pos+1], b[pos+2]
+		size := int(binary.BigEndian.Uint16(b[pos+3:]))
+		pos += 5
+		if size&0x8000 != 0 {
+			// Extended datasets give the length of their size first.
+			n := size & 0x7fff
+			if n > 4 || pos+n > len(b) {
+				return
+			}
+			size = 0
+			for _, c := range b[pos : pos+n] {
+				size = size<<8 | int(c)
+			}
+			pos += n
+		}
+		if size < 0 || pos+size > len(b) {
+			return
+		}
+
+		if record == 2 && dataset != 0 {
+			name, ok := iimNames[dataset]
+			if !ok {
+				name = fmt.Sprintf("2:%d", dataset)
+			}
+			m.Fields = append(m.Fields, Field{Source: "IPTC", Name: name, Value: string(b[pos : pos+size])})
+		}
+		pos += size
+	}
+}
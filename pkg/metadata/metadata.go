@@ -0,0 +1,203 @@
+// Package metadata extracts the EXIF, XMP and IPTC metadata of JPEG, PNG,
+// TIFF and WebP files, flags fields which could carry a payload, and strips
+// metadata. Text fields are a classic hiding place, and metadata also leaks
+// where a picture was taken and with which camera.
+package metadata
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxField is the length above which Inspect flags a field as
+// oversized.
+const DefaultMaxField = 1024
+
+// minBase64 is the shortest value Inspect checks for base64.
+const minBase64 = 64
+
+// Field is one metadata field.
+type Field struct {
+	// Source is where the field comes from: "EXIF", "GPS", "XMP", "IPTC" or
+	// "Comment".
+	Source string
+	// Name is the name of the field, e.g. "Model" or "dc:description", or
+	// the number of its tag if it has no known name.
+	Name  string
+	Value string
+}
+
+func (f Field) String() string {
+	value := f.Value
+	if len(value) > 40 {
+		value = value[:40] + "..."
+	}
+	return fmt.Sprintf("%s %s=%q", f.Source, f.Name, value)
+}
+
+// Private reports whether the field identifies where a picture was taken, or
+// the camera or person which took it.
+func (f Field) Private() bool {
+	if f.Source == "GPS" || f.Name == "MakerNote" {
+		// Maker notes are opaque, and usually hold the serial number.
+		return true
+	}
+	for _, s := range []string{"GPS", "SerialNumber", "OwnerName"} {
+		if strings.Contains(f.Name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// binary reports whether the field is not text, so that its length and
+// contents say nothing.
+func (f Field) binary() bool {
+	return f.Name == "MakerNote"
+}
+
+// Metadata is the metadata of a file.
+type Metadata struct {
+	Fields []Field
+	// Thumbnail is the JPEG thumbnail stored in the EXIF metadata, if any.
+	Thumbnail []byte
+}
+
+// private reports whether any field is private.
+func (m *Metadata) private() bool {
+	for _, f := range m.Fields {
+		if f.Private() {
+			return true
+		}
+	}
+	return false
+}
+
+// Extract returns the metadata of data, which is of the given format as
+// named by image.Decode. Formats without metadata have none.
+func Extract(data []byte, format string) (*Metadata, error) {
+	m := &Metadata{}
+	var err error
+	switch format {
+	case "jpeg":
+		err = m.extractJPEG(data)
+	case "png":
+		err = m.extractPNG(data)
+	case "tiff":
+		err = m.extractEXIF(data)
+	case "webp":
+		err = m.extractWebP(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Inspect returns a description of every suspicious field: text fields
+// longer than maxSize bytes, and fields which look like base64. Thumbnails
+// are checked by ThumbnailDistance.
+func Inspect(m *Metadata, maxSize int) []string {
+	var problems []string
+	for _, f := range m.Fields {
+		if f.binary() {
+			continue
+		}
+		if len(f.Value) > maxSize {
+			problems = append(problems, fmt.Sprintf("oversized metadata field %s %s (%d bytes)", f.Source, f.Name, len(f.Value)))
+		} else if looksBase64(f.Value) {
+			problems = append(problems, fmt.Sprintf("base64 in metadata field %v", f))
+		}
+	}
+	return problems
+}
+
+// looksBase64 reports whether s is a long base64 string: only base64
+// characters, with upper case, lower case and digits mixed, which decodes.
+func looksBase64(s string) bool {
+	s = strings.Join(strings.Fields(s), "")
+	if len(s) < minBase64 {
+		return false
+	}
+
+	var upper, lower, digit bool
+	for _, c := range s {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= '0' && c <= '9':
+			digit = true
+		case c == '+' || c == '/' || c == '=':
+		default:
+			return false
+		}
+	}
+	if !upper || !lower || !digit {
+		return false
+	}
+
+	_, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		_, err = base64.RawStdEncoding.DecodeString(s)
+	}
+	return err == nil
+}
+
+// Mode is what Strip removes.
+type Mode int
+
+const (
+	// StripPrivate removes GPS locations, serial numbers, owner names and
+	// maker notes, and keeps the rest.
+	StripPrivate Mode = iota
+	// StripAll removes all EXIF, XMP and IPTC metadata and comments. Colour
+	// profiles are kept.
+	StripAll
+	// Keep leaves metadata as it is.
+	Keep
+)
+
+var modeNames = []string{"private", "all", "keep"}
+
+func (m Mode) String() string {
+	if int(m) < len(modeNames) {
+		return modeNames[m]
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// ParseMode returns the Mode with the given name.
+func ParseMode(name string) (Mode, error) {
+	for i, n := range modeNames {
+		if n == name {
+			return Mode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown metadata mode %q", name)
+}
+
+// Strip returns data, which is of the given format as named by image.Decode,
+// with metadata removed according to mode. The image itself is untouched.
+func Strip(data []byte, format string, mode Mode) ([]byte, error) {
+	if mode == Keep {
+		return data, nil
+	}
+	switch format {
+	case "jpeg":
+		return stripJPEG(data, mode)
+	case "png":
+		return stripPNG(data, mode)
+	case "tiff":
+		out := append([]byte(nil), data...)
+		if err := scrubEXIF(out, mode); err != nil {
+			return nil, err
+		}
+		return out, nil
+	case "webp":
+		return stripWebP(data, mode)
+	}
+	return data, nil
+}
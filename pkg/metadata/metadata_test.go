@@ -0,0 +1,110 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"strings"
+	"testing"
+)
+
+func field(m *Metadata, name string) (Field, bool) {
+	for _, f := range m.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+func TestExtract(t *testing.T) {
+	data, err := os.ReadFile("../../testfiles/sample.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Extract(data, "jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"Model":            "Canon EOS 6D Mark II",
+		"BodySerialNumber": "052051001110",
+		"aux:SerialNumber": "052051001110",
+		"DateCreated":      "20211030",
+	} {
+		if f, ok := field(m, name); !ok || f.Value != want {
+			t.Errorf("field %s = %q, want %q", name, f.Value, want)
+		}
+	}
+
+	if problems := Inspect(m, DefaultMaxField); len(problems) != 0 {
+		t.Errorf("Inspect = %q, want nothing", problems)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	payload := make([]byte, 300)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	m := &Metadata{Fields: []Field{
+		{Source: "EXIF", Name: "Model", Value: "Canon EOS 6D Mark II"},
+		{Source: "Comment", Name: "COM", Value: base64.StdEncoding.EncodeToString(payload)},
+		{Source: "XMP", Name: "dc:description", Value: strings.Repeat("lorem ipsum ", 100)},
+		{Source: "EXIF", Name: "MakerNote", Value: string(payload) + strings.Repeat("x", 2000)},
+	}}
+
+	problems := Inspect(m, DefaultMaxField)
+	if len(problems) != 2 || !strings.Contains(problems[0], "base64") || !strings.Contains(problems[1], "oversized") {
+		t.Errorf("Inspect = %q", problems)
+	}
+}
+
+func TestStrip(t *testing.T) {
+	for file, format := range map[string]string{
+		"../../testfiles/sample.jpg":        "jpeg",
+		"../../testfiles/samplesmaller.png": "png",
+	} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before, err := Extract(data, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, mode := range []Mode{StripPrivate, StripAll} {
+			out, err := Strip(data, format, mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := image.Decode(bytes.NewReader(out)); err != nil {
+				t.Errorf("%s stripped of %v metadata does not decode: %v", file, mode, err)
+			}
+			after, err := Extract(out, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if after.private() {
+				t.Errorf("%s stripped of %v metadata has private fields", file, mode)
+			}
+			if mode == StripAll && len(after.Fields) != 0 {
+				t.Errorf("%s stripped of all metadata has fields %v", file, after.Fields)
+			}
+			if mode == StripPrivate && format == "jpeg" {
+				if _, ok := field(after, "Model"); !ok {
+					t.Errorf("%s stripped of private metadata lost its Model", file)
+				}
+				if !bytes.Equal(after.Thumbnail, before.Thumbnail) {
+					t.Errorf("%s stripped of private metadata lost its thumbnail", file)
+				}
+			}
+		}
+	}
+}
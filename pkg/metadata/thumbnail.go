@@ -0,0 +1,302 @@
+package metadata
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	"github.com/standardrhyme/stegsecure/pkg/pngchunk"
+)
+
+// MaxThumbnailDistance is the ThumbnailDistance above which a thumbnail is
+// taken to show something else than its image.
+const MaxThumbnailDistance = 0.25
+
+// hashSize is the side of the grid of differences ThumbnailDistance hashes.
+const hashSize = 16
+
+// ThumbnailDistance returns the perceptual distance, from 0 to 1, between a
+// JPEG thumbnail and img: the fraction of the bits of their difference hashes
+// (dHash) which differ. Both are scaled down to a grid of hashSize+1 by
+// hashSize, and each bit is whether a cell is brighter than the one to its
+// right. The hash ignores brightness, contrast and compression, but not
+// content. Thumbnails padded to another aspect ratio than the image are
+// cropped to it first.
+func ThumbnailDistance(thumb []byte, img image.Image) (float64, error) {
+	t, err := jpeg.Decode(bytes.NewReader(thumb))
+	if err != nil {
+		return 0, err
+	}
+	if t.Bounds().Empty() || img.Bounds().Empty() {
+		return 0, fmt.Errorf("Empty thumbnail or image")
+	}
+
+	a := dHash(t, crop(t.Bounds(), img.Bounds()))
+	b := dHash(img, img.Bounds())
+	differ := 0
+	for i := range a {
+		if a[i] != b[i] {
+			differ++
+		}
+	}
+	return float64(differ) / float64(len(a)), nil
+}
+
+// crop returns the largest rectangle centred in r with the aspect ratio of
+// like, which is where a padded thumbnail shows its image.
+func crop(r, like image.Rectangle) image.Rectangle {
+	w, h := r.Dx(), r.Dy()
+	if w*like.Dy() > h*like.Dx() {
+		w = h * like.Dx() / like.Dy()
+	} else {
+		h = w * like.Dy() / like.Dx()
+	}
+	if w < 1 || h < 1 {
+		return r
+	}
+	min := r.Min.Add(image.Pt((r.Dx()-w)/2, (r.Dy()-h)/2))
+	return image.Rectangle{Min: min, Max: min.Add(image.Pt(w, h))}
+}
+
+func dHash(m image.Image, r image.Rectangle) []bool {
+	cells := scale(m, r, hashSize+1, hashSize)
+	hash := make([]bool, 0, hashSize*hashSize)
+	for y := 0; y < hashSize; y++ {
+		for x := 0; x < hashSize; x++ {
+			hash = append(hash, luma(cells[y][x]) > luma(cells[y][x+1]))
+		}
+	}
+	return hash
+}
+
+// scale returns r of m scaled down to w by h cells, each the mean colour of
+// the pixels it covers. Large cells are sampled rather than read whole.
+func scale(m image.Image, r image.Rectangle, w, h int) [][]color.RGBA64 {
+	cells := make([][]color.RGBA64, h)
+	for cy := range cells {
+		cells[cy] = make([]color.RGBA64, w)
+		y0 := r.Min.Y + cy*r.Dy()/h
+		y1 := r.Min.Y + (cy+1)*r.Dy()/h
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for cx := range cells[cy] {
+			x0 := r.Min.X + cx*r.Dx()/w
+			x1 := r.Min.X + (cx+1)*r.Dx()/w
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			// At most 16 by 16 samples per cell.
+			sx, sy := (x1-x0+15)/16, (y1-y0+15)/16
+			var sr, sg, sb, sa, n uint64
+			for y := y0; y < y1; y += sy {
+				for x := x0; x < x1; x += sx {
+					r, g, b, a := m.At(x, y).RGBA()
+					sr, sg, sb, sa = sr+uint64(r), sg+uint64(g), sb+uint64(b), sa+uint64(a)
+					n++
+				}
+			}
+			cells[cy][cx] = color.RGBA64{uint16(sr / n), uint16(sg / n), uint16(sb / n), uint16(sa / n)}
+		}
+	}
+	return cells
+}
+
+// luma returns the luma of c, from 0 to 1.
+func luma(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 0xffff
+}
+
+// ThumbnailMode is what RewriteThumbnail does to EXIF thumbnails.
+type ThumbnailMode int
+
+const (
+	// RegenerateThumbnail replaces the thumbnail with one made from the
+	// image, or drops it if the new one doesn't fit in its place.
+	RegenerateThumbnail ThumbnailMode = iota
+	// DropThumbnail removes the thumbnail.
+	DropThumbnail
+	// KeepThumbnail leaves the thumbnail as it is.
+	KeepThumbnail
+)
+
+var thumbnailModeNames = []string{"regenerate", "drop", "keep"}
+
+func (m ThumbnailMode) String() string {
+	if int(m) < len(thumbnailModeNames) {
+		return thumbnailModeNames[m]
+	}
+	return fmt.Sprintf("ThumbnailMode(%d)", int(m))
+}
+
+// ParseThumbnailMode returns the ThumbnailMode with the given name.
+func ParseThumbnailMode(name string) (ThumbnailMode, error) {
+	for i, n := range thumbnailModeNames {
+		if n == name {
+			return ThumbnailMode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown thumbnail mode %q", name)
+}
+
+// thumbnailQualities are the JPEG qualities a regenerated thumbnail is tried
+// at, until it fits in the place of the old one.
+var thumbnailQualities = []int{90, 75, 50, 30, 15}
+
+// RewriteThumbnail returns data, which is of the given format as named by
+// image.Decode, with its EXIF thumbnail regenerated or dropped according to
+// mode. img is the image to make a new thumbnail from; if nil, data is
+// decoded. The EXIF metadata keeps its size, so a new thumbnail must fit in
+// the place of the old one, and the space left is zeroed. Files without a
+// thumbnail are returned as they are.
+func RewriteThumbnail(data []byte, format string, mode ThumbnailMode, img image.Image) ([]byte, error) {
+	m, err := Extract(data, format)
+	if err != nil {
+		return nil, err
+	}
+	if mode == KeepThumbnail || m.Thumbnail == nil {
+		return data, nil
+	}
+
+	var thumb []byte
+	if mode == RegenerateThumbnail {
+		if img == nil {
+			if img, _, err = image.Decode(bytes.NewReader(data)); err != nil {
+				return nil, err
+			}
+		}
+		thumb = newThumbnail(m.Thumbnail, img, len(m.Thumbnail))
+	}
+
+	return editEXIF(data, format, func(b []byte) error {
+		return setThumbnail(b, thumb)
+	})
+}
+
+// newThumbnail returns a JPEG of img at the size of old, or 160 pixels wide if
+// old can't be decoded, of at most max bytes. It returns nil if none fits.
+func newThumbnail(old []byte, img image.Image, max int) []byte {
+	b := img.Bounds()
+	w, h := 160, 160*b.Dy()/b.Dx()
+	if config, err := jpeg.DecodeConfig(bytes.NewReader(old)); err == nil {
+		w, h = config.Width, config.Height
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	cells := scale(img, b, w, h)
+	small := image.NewRGBA64(image.Rect(0, 0, w, h))
+	for y := range cells {
+		for x, c := range cells[y] {
+			small.SetRGBA64(x, y, c)
+		}
+	}
+
+	for _, quality := range thumbnailQualities {
+		var out bytes.Buffer
+		if err := jpeg.Encode(&out, small, &jpeg.Options{Quality: quality}); err == nil && out.Len() <= max {
+			return out.Bytes()
+		}
+	}
+	return nil
+}
+
+// editEXIF returns a copy of data with fn applied to its EXIF metadata, which
+// fn must not resize.
+func editEXIF(data []byte, format string, fn func(b []byte) error) ([]byte, error) {
+	out := append([]byte(nil), data...)
+	switch format {
+	case "jpeg":
+		segments, _, err := jpegSegments(out)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range segments {
+			if s.kind() == "EXIF" {
+				return out, fn(s.data[len(exifHeader):])
+			}
+		}
+	case "png":
+		chunks, err := pngchunk.Parse(out)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range chunks {
+			if c.Type == "eXIf" {
+				if err := fn(c.Data); err != nil {
+					return nil, err
+				}
+				// The CRC of the chunk has to be recomputed.
+				iend := chunks[len(chunks)-1]
+				return append(pngchunk.Encode(chunks), out[iend.Offset+12+len(iend.Data):]...), nil
+			}
+		}
+	case "webp":
+		chunks, _, err := webpChunks(out)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range chunks {
+			if c.fourCC == "EXIF" {
+				return out, fn(webpEXIF(c.data))
+			}
+		}
+	}
+	return out, nil
+}
+
+// setThumbnail replaces the thumbnail in the EXIF metadata in b with thumb,
+// in place, or drops IFD1, which holds it, if thumb is nil or too large.
+func setThumbnail(b []byte, thumb []byte) error {
+	r, first, err := newTIFFReader(b)
+	if err != nil {
+		return err
+	}
+	_, ifd1, err := r.ifd(first)
+	if err != nil || ifd1 == 0 {
+		return err
+	}
+	entries, _, err := r.ifd(ifd1)
+	if err != nil {
+		return err
+	}
+
+	var offset, length *entry
+	for k := range entries {
+		switch entries[k].tag {
+		case tJPEGInterchangeFormat:
+			offset = &entries[k]
+		case tJPEGInterchangeFormatLength:
+			length = &entries[k]
+		}
+	}
+	if offset == nil || length == nil {
+		return nil
+	}
+	at, n := int(r.uint(*offset, 0)), int(r.uint(*length, 0))
+	if at < 0 || at+n > len(b) {
+		return fmt.Errorf("Invalid EXIF thumbnail offset %d", at)
+	}
+
+	if thumb != nil && len(thumb) <= n && (length.typ == typeLong || length.typ == typeShort) {
+		zero(b[at+copy(b[at:at+n], thumb) : at+n])
+		if length.typ == typeLong {
+			r.order.PutUint32(length.value, uint32(len(thumb)))
+		} else {
+			r.order.PutUint16(length.value, uint16(len(thumb)))
+		}
+		return nil
+	}
+
+	// Unlink IFD1 from IFD0, and zero it and the thumbnail.
+	n0 := int(r.order.Uint16(b[first:]))
+	r.order.PutUint32(b[int(first)+2+12*n0:], 0)
+	zero(b[at : at+n])
+	r.zeroIFD(ifd1, make(map[uint32]bool))
+	return nil
+}
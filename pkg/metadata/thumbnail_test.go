@@ -0,0 +1,83 @@
+package metadata
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os"
+	"testing"
+)
+
+func TestThumbnailDistance(t *testing.T) {
+	data, err := os.ReadFile("../../testfiles/sample.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Extract(data, "jpeg")
+	if err != nil || m.Thumbnail == nil {
+		t.Fatalf("no thumbnail in sample.jpg: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d, err := ThumbnailDistance(m.Thumbnail, img); err != nil || d > MaxThumbnailDistance {
+		t.Errorf("got distance %.2f, %v for the thumbnail of sample.jpg", d, err)
+	}
+
+	// The image mirrored no longer matches.
+	b := img.Bounds()
+	flipped := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			flipped.Set(b.Max.X-1-(x-b.Min.X), y, img.At(x, y))
+		}
+	}
+	if d, err := ThumbnailDistance(m.Thumbnail, flipped); err != nil || d <= MaxThumbnailDistance {
+		t.Errorf("got distance %.2f, %v for a mirrored image", d, err)
+	}
+}
+
+func TestRewriteThumbnail(t *testing.T) {
+	data, err := os.ReadFile("../../testfiles/sample.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []ThumbnailMode{RegenerateThumbnail, DropThumbnail, KeepThumbnail} {
+		out, err := RewriteThumbnail(data, "jpeg", mode, nil)
+		if err != nil {
+			t.Fatalf("%v: %v", mode, err)
+		}
+		if len(out) != len(data) {
+			t.Errorf("%v: got %d bytes, want %d", mode, len(out), len(data))
+		}
+		img, err := jpeg.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("%v: %v", mode, err)
+		}
+		m, err := Extract(out, "jpeg")
+		if err != nil {
+			t.Fatalf("%v: %v", mode, err)
+		}
+
+		switch mode {
+		case DropThumbnail:
+			if m.Thumbnail != nil {
+				t.Errorf("%v: thumbnail kept", mode)
+			}
+		case RegenerateThumbnail:
+			if m.Thumbnail == nil {
+				t.Fatalf("%v: thumbnail dropped", mode)
+			}
+			if d, err := ThumbnailDistance(m.Thumbnail, img); err != nil || d > MaxThumbnailDistance {
+				t.Errorf("%v: got distance %.2f, %v", mode, d, err)
+			}
+		case KeepThumbnail:
+			if !bytes.Equal(out, data) {
+				t.Errorf("%v: file changed", mode)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+)
+
+// extractXMP adds the properties of an XMP packet, both those written as
+// attributes and as elements, named by their prefix as in the packet. A
+// packet which is not valid XML is added whole.
+func (m *Metadata) extractXMP(packet []byte) {
+	var fields []Field
+	d := xml.NewDecoder(bytes.NewReader(packet))
+	d.Strict = false
+
+	// stack are the names of the enclosing elements which are properties,
+	// rather than RDF containers.
+	var stack []string
+	for {
+		tok, err := d.RawToken()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Space + ":" + t.Name.Local
+			if t.Name.Space == "rdf" || t.Name.Space == "x" {
+				name = ""
+			}
+			stack = append(stack, name)
+			for _, a := range t.Attr {
+				if a.Name.Space == "xmlns" || a.Name.Space == "" || a.Name.Space == "rdf" || a.Name.Space == "x" {
+					continue
+				}
+				fields = append(fields, Field{Source: "XMP", Name: a.Name.Space + ":" + a.Name.Local, Value: a.Value})
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			if text == "" {
+				continue
+			}
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i] != "" {
+					fields = append(fields, Field{Source: "XMP", Name: stack[i], Value: text})
+					break
+				}
+			}
+		}
+	}
+
+	if fields == nil && len(bytes.TrimSpace(packet)) > 0 {
+		fields = []Field{{Source: "XMP", Name: "XMP", Value: string(packet)}}
+	}
+	m.Fields = append(m.Fields, fields...)
+}
+
+// xmpPrivate reports whether an XMP packet holds any private property. XMP
+// packets are not rewritten, so packets which do are dropped as a whole.
+func xmpPrivate(packet []byte) bool {
+	var m Metadata
+	m.extractXMP(packet)
+	return m.private()
+}
@@ -0,0 +1,162 @@
+// Package pngchunk parses PNG files into their chunks, checks them, and
+// writes chunks back into a PNG. Payloads can hide in text chunks (tEXt,
+// zTXt, iTXt) or in private chunks, which decoders skip over, so they are
+// invisible to any statistic over the pixels.
+package pngchunk
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// Signature is the signature every PNG starts with.
+const Signature = "\x89PNG\r\n\x1a\n"
+
+// DefaultMaxAncillary is the size above which Inspect flags an ancillary
+// chunk as oversized.
+const DefaultMaxAncillary = 4096
+
+// Critical are the chunks which make up the image itself.
+var Critical = []string{"IHDR", "PLTE", "IDAT", "IEND"}
+
+// DefaultKeep are the ancillary chunks which affect how an image is
+// displayed, and so are kept by default when the others are stripped.
+var DefaultKeep = []string{"tRNS", "gAMA", "cHRM", "sRGB", "iCCP", "sBIT", "pHYs", "bKGD"}
+
+// known are the chunk types defined by the PNG specification and its
+// extensions (APNG, eXIf, cICP and the HDR chunks).
+var known = map[string]bool{
+	"IHDR": true, "PLTE": true, "IDAT": true, "IEND": true,
+	"cHRM": true, "gAMA": true, "iCCP": true, "sBIT": true, "sRGB": true,
+	"bKGD": true, "hIST": true, "tRNS": true, "pHYs": true, "sPLT": true,
+	"tIME": true, "iTXt": true, "tEXt": true, "zTXt": true, "eXIf": true,
+	"acTL": true, "fcTL": true, "fdAT": true,
+	"cICP": true, "mDCv": true, "cLLi": true,
+}
+
+// Chunk is one chunk of a PNG.
+type Chunk struct {
+	Type string
+	Data []byte
+	// CRC is the checksum stored in the file.
+	CRC uint32
+	// Offset is where the chunk starts in the file.
+	Offset int
+}
+
+// Ancillary reports whether the chunk may be ignored by a decoder, which is
+// set by the case of the first letter of its type.
+func (c Chunk) Ancillary() bool {
+	return c.Type[0]&0x20 != 0
+}
+
+// Private reports whether the chunk type is not a public, registered one.
+func (c Chunk) Private() bool {
+	return c.Type[1]&0x20 != 0
+}
+
+// Known reports whether the chunk type is defined by the PNG specification
+// or one of its common extensions.
+func (c Chunk) Known() bool {
+	return known[c.Type]
+}
+
+// Checksum returns the CRC of the chunk's type and data.
+func (c Chunk) Checksum() uint32 {
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(c.Type))
+	crc.Write(c.Data)
+	return crc.Sum32()
+}
+
+// Valid reports whether the stored CRC matches the chunk.
+func (c Chunk) Valid() bool {
+	return c.CRC == c.Checksum()
+}
+
+func (c Chunk) String() string {
+	return fmt.Sprintf("%s (%d bytes at offset %d)", c.Type, len(c.Data), c.Offset)
+}
+
+// Parse returns the chunks of the PNG in data, up to and including IEND.
+// Anything after IEND is ignored. The Data of each chunk is a slice of data.
+func Parse(data []byte) ([]Chunk, error) {
+	if len(data) < len(Signature) || string(data[:len(Signature)]) != Signature {
+		return nil, fmt.Errorf("Not a PNG file")
+	}
+
+	var chunks []Chunk
+	for pos := len(Signature); ; {
+		if pos+8 > len(data) {
+			return nil, fmt.Errorf("PNG has no IEND chunk")
+		}
+		length := int64(binary.BigEndian.Uint32(data[pos:]))
+		end := int64(pos) + 12 + length
+		if length > 1<<31-1 || end > int64(len(data)) {
+			return nil, fmt.Errorf("PNG chunk at offset %d runs past the end of the file", pos)
+		}
+
+		c := Chunk{
+			Type:   string(data[pos+4 : pos+8]),
+			Data:   data[pos+8 : end-4],
+			CRC:    binary.BigEndian.Uint32(data[end-4:]),
+			Offset: pos,
+		}
+		for i := 0; i < 4; i++ {
+			if b := c.Type[i] | 0x20; b < 'a' || b > 'z' {
+				return nil, fmt.Errorf("Invalid PNG chunk type %q at offset %d", c.Type, pos)
+			}
+		}
+		chunks = append(chunks, c)
+
+		if c.Type == "IEND" {
+			return chunks, nil
+		}
+		pos = int(end)
+	}
+}
+
+// Inspect returns a description of every suspicious chunk: chunks with a bad
+// CRC, private and other unknown ancillary chunks, and ancillary chunks larger
+// than maxSize bytes.
+func Inspect(chunks []Chunk, maxSize int) []string {
+	var problems []string
+	for _, c := range chunks {
+		if !c.Valid() {
+			problems = append(problems, fmt.Sprintf("PNG chunk %v has a bad CRC", c))
+		}
+		if !c.Ancillary() {
+			continue
+		}
+		if c.Private() {
+			problems = append(problems, fmt.Sprintf("private PNG chunk %v", c))
+		} else if !c.Known() {
+			problems = append(problems, fmt.Sprintf("unknown PNG chunk %v", c))
+		} else if len(c.Data) > maxSize {
+			problems = append(problems, fmt.Sprintf("oversized PNG chunk %v", c))
+		}
+	}
+	return problems
+}
+
+// Encode returns a PNG made of the given chunks, with their CRCs recomputed.
+func Encode(chunks []Chunk) []byte {
+	size := len(Signature)
+	for _, c := range chunks {
+		size += 12 + len(c.Data)
+	}
+
+	out := make([]byte, 0, size)
+	out = append(out, Signature...)
+	var word [4]byte
+	for _, c := range chunks {
+		binary.BigEndian.PutUint32(word[:], uint32(len(c.Data)))
+		out = append(out, word[:]...)
+		out = append(out, c.Type...)
+		out = append(out, c.Data...)
+		binary.BigEndian.PutUint32(word[:], c.Checksum())
+		out = append(out, word[:]...)
+	}
+	return out
+}
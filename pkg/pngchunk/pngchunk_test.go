@@ -0,0 +1,66 @@
+package pngchunk
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	data, err := os.ReadFile("../../testfiles/samplesmall.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunks, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, c := range chunks {
+		types = append(types, c.Type)
+		if !c.Valid() {
+			t.Errorf("chunk %v has a bad CRC", c)
+		}
+	}
+	if got := strings.Join(types, " "); got != "IHDR pHYs iTXt IDAT IEND" {
+		t.Errorf("got chunks %s", got)
+	}
+	if !bytes.Equal(Encode(chunks), data) {
+		t.Error("Encode(Parse(data)) differs from data")
+	}
+
+	// The XMP blob is the only suspicious chunk.
+	problems := Inspect(chunks, DefaultMaxAncillary)
+	if len(problems) != 1 || !strings.Contains(problems[0], "oversized PNG chunk iTXt") {
+		t.Errorf("Inspect = %q", problems)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	chunks := []Chunk{
+		{Type: "IHDR", Data: make([]byte, 13)},
+		{Type: "stEg", Data: []byte("payload")},
+		{Type: "tEXt", Data: []byte("Comment\x00hello")},
+		{Type: "zZZz", Data: []byte("payload")},
+		{Type: "IEND"},
+	}
+	for i := range chunks {
+		chunks[i].CRC = chunks[i].Checksum()
+	}
+	chunks[2].CRC++
+
+	problems := Inspect(chunks, DefaultMaxAncillary)
+	if len(problems) != 3 || !strings.Contains(problems[0], "private PNG chunk stEg") || !strings.Contains(problems[1], "tEXt") || !strings.Contains(problems[1], "bad CRC") || !strings.Contains(problems[2], "unknown PNG chunk zZZz") {
+		t.Errorf("Inspect = %q", problems)
+	}
+
+	parsed, err := Parse(Encode(chunks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != len(chunks) || !parsed[1].Private() || !parsed[1].Ancillary() || parsed[0].Ancillary() || parsed[3].Private() {
+		t.Errorf("Parse(Encode(chunks)) = %v", parsed)
+	}
+}
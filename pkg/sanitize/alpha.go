@@ -0,0 +1,77 @@
+package sanitize
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// alphaFunc returns the function alpha samples are rewritten with. Alpha
+// within the change of p.Depth bit planes of transparent or opaque is snapped
+// to it, which clears a payload from the alpha of an opaque image without
+// leaving it translucent. Other alpha, such as that of anti-aliased edges,
+// is rewritten by lsb like colour.
+func (p Policy) alphaFunc(lsb sampleFunc) sampleFunc {
+	snap := uint16(1)<<uint(p.depth()) - 1
+	return func(v, max uint16, x, y, c int) uint16 {
+		switch {
+		case v <= snap:
+			return 0
+		case v >= max-snap:
+			return max
+		}
+		return lsb(v, max, x, y, c)
+	}
+}
+
+// flatten returns m composited over the background colour bg, of the same
+// type but opaque. Images which are opaque already, or have no alpha, are
+// returned as they are. YCbCr images with alpha, which can't be drawn on,
+// are flattened to RGBA.
+func flatten(m image.Image, bg color.Color) image.Image {
+	if o, ok := m.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return m
+	}
+
+	b := m.Bounds()
+	var dst draw.Image
+	switch m := m.(type) {
+	case *image.Paletted:
+		// Only the palette has to be flattened.
+		clean := *m
+		clean.Pix = clone(m.Pix)
+		clean.Palette = make(color.Palette, len(m.Palette))
+		for i, c := range m.Palette {
+			clean.Palette[i] = color.RGBAModel.Convert(over(c, bg))
+		}
+		return &clean
+	case *image.NRGBA:
+		dst = image.NewNRGBA(b)
+	case *image.RGBA:
+		dst = image.NewRGBA(b)
+	case *image.NRGBA64:
+		dst = image.NewNRGBA64(b)
+	case *image.RGBA64:
+		dst = image.NewRGBA64(b)
+	case *image.NYCbCrA:
+		dst = image.NewRGBA(b)
+	default:
+		return m
+	}
+
+	draw.Draw(dst, b, image.NewUniform(over(color.Transparent, bg)), image.Point{}, draw.Src)
+	draw.Draw(dst, b, m, b.Min, draw.Over)
+	return dst
+}
+
+// over returns c composited over bg, which is taken to be opaque.
+func over(c, bg color.Color) color.Color {
+	r, g, b, a := c.RGBA()
+	br, bgreen, bb, _ := bg.RGBA()
+	return color.RGBA64{
+		R: uint16(r + br*(0xffff-a)/0xffff),
+		G: uint16(g + bgreen*(0xffff-a)/0xffff),
+		B: uint16(b + bb*(0xffff-a)/0xffff),
+		A: 0xffff,
+	}
+}
@@ -0,0 +1,41 @@
+package sanitize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFlatten(t *testing.T) {
+	white := color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+
+	m := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	m.SetNRGBA(0, 0, color.NRGBA{R: 10, G: 20, B: 30, A: 0})
+	m.SetNRGBA(1, 0, color.NRGBA{R: 100, G: 100, B: 100, A: 0x80})
+	m.SetNRGBA(2, 0, color.NRGBA{R: 11, G: 21, B: 31, A: 0xff})
+
+	clean, err := Policy{Background: white}.SanitizeImage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flat, ok := clean.(*image.NRGBA)
+	if !ok || !flat.Opaque() {
+		t.Fatalf("flattened to a %T, opaque %v", clean, ok && flat.Opaque())
+	}
+	for x, want := range []color.NRGBA{{254, 254, 254, 255}, {176, 176, 176, 255}, {10, 20, 30, 255}} {
+		if got := flat.NRGBAAt(x, 0); got != want {
+			t.Errorf("pixel %d flattened to %v, want %v", x, got, want)
+		}
+	}
+
+	p := image.NewPaletted(m.Rect, color.Palette{color.Transparent, color.NRGBA{R: 9, G: 9, B: 9, A: 0xff}})
+	clean, err = Policy{Background: white}.SanitizeImage(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range clean.(*image.Paletted).Palette {
+		if _, _, _, a := c.RGBA(); a != 0xffff {
+			t.Errorf("palette entry %v not flattened", c)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package sanitize
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/standardrhyme/stegsecure/pkg/jpegcoef"
+)
+
+// JPEGMode selects how the quantized DCT coefficients of a JPEG are sanitized.
+// Every mode works on the coefficients directly and writes them back without
+// decoding the image to pixels, so it costs no more quality than it has to.
+type JPEGMode int
+
+const (
+	// ClearLSB clears the LSB of each AC coefficient other than 0 and 1, in
+	// two's complement, which is where JSteg and OutGuess embed: it rounds
+	// positive coefficients towards zero, and negative ones away from it, so
+	// that -1 becomes -2. This removes JSteg and OutGuess payloads, and as no
+	// coefficient becomes 0 or 1, it adds none to the coefficients they skip.
+	ClearLSB JPEGMode = iota
+	// RandomizeLSB sets the LSB of the same coefficients to a random bit from
+	// a CSPRNG seeded with Policy.Seed, which leaves the histogram looking
+	// like an untouched JPEG's.
+	RandomizeLSB
+	// Requantize divides every AC coefficient by Policy.QuantScale and scales
+	// the quantization table up to match, which destroys payloads in every
+	// coefficient, including JSteg's and F5's in the +-1s, at the cost of the
+	// quality of a coarser quantization. As the tables are shared by the
+	// whole image, so is requantization, and Policy.Regions is ignored.
+	Requantize
+)
+
+var jpegModeNames = map[JPEGMode]string{
+	ClearLSB:     "clear",
+	RandomizeLSB: "randomize",
+	Requantize:   "requantize",
+}
+
+func (m JPEGMode) String() string {
+	return jpegModeNames[m]
+}
+
+// ParseJPEGMode returns the mode with the given name: "clear", "randomize" or
+// "requantize".
+func ParseJPEGMode(name string) (JPEGMode, error) {
+	for mode, n := range jpegModeNames {
+		if n == name {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown JPEG sanitization mode: %s", name)
+}
+
+// sanitizeCoefficients applies the policy's JPEGMode to the coefficients of
+// img, within p.Regions if set and the mode is not Requantize.
+func (p Policy) sanitizeCoefficients(img *jpegcoef.Image) error {
+	var bits *bitStream
+	if p.JPEG == RandomizeLSB {
+		var err error
+		if bits, err = newBitStream(p.Seed); err != nil {
+			return err
+		}
+	}
+
+	// The tables the coefficients are requantized from.
+	old := img.Quant
+	if p.JPEG == Requantize {
+		scale := p.QuantScale
+		if scale <= 1 {
+			scale = 2
+		}
+		for tq := range img.Quant {
+			for k := 1; k < 64; k++ {
+				img.Quant[tq][k] = uint16(math.Min(math.Round(float64(old[tq][k])*scale), 255))
+			}
+		}
+	}
+
+	// Blocks left out would be decoded with the new tables, so all of them
+	// are requantized.
+	localized := len(p.Regions) > 0 && p.JPEG != Requantize
+	regions := p.regions(image.Rect(0, 0, img.Width, img.Height))
+
+	for i := range img.Components {
+		c := &img.Components[i]
+		for by := 0; by < c.BlocksHigh; by++ {
+			for bx := 0; bx < c.BlocksWide; bx++ {
+				if localized && !overlaps(img.BlockBounds(c, bx, by), regions) {
+					continue
+				}
+
+				b := c.Block(bx, by)
+				for k := 1; k < 64; k++ {
+					if b[k] == 0 {
+						continue
+					}
+
+					switch p.JPEG {
+					case ClearLSB:
+						if b[k] != 1 {
+							b[k] &^= 1
+						}
+					case RandomizeLSB:
+						if b[k] != 1 {
+							b[k] = b[k]&^1 | int16(bits.bit())
+						}
+					case Requantize:
+						b[k] = int16(math.Round(float64(b[k]) * float64(old[c.Tq][k]) / float64(img.Quant[c.Tq][k])))
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// overlaps returns whether r overlaps any of regions.
+func overlaps(r image.Rectangle, regions []image.Rectangle) bool {
+	for _, region := range regions {
+		if r.Overlaps(region) {
+			return true
+		}
+	}
+	return false
+}
+
+// SanitizeJPEG sanitizes the DCT coefficients of a JPEG according to p.JPEG,
+// and re-encodes them without a decode/encode cycle through pixels.
+func (p Policy) SanitizeJPEG(data []byte) ([]byte, error) {
+	img, err := jpegcoef.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.sanitizeCoefficients(img); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err := jpegcoef.Encode(&out, img); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
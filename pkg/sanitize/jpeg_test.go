@@ -0,0 +1,211 @@
+package sanitize
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/standardrhyme/stegsecure/pkg/jpegcoef"
+)
+
+func TestSanitizeJPEG(t *testing.T) {
+	data, err := os.ReadFile("../../testfiles/sampleprogressive.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig, err := jpegcoef.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []JPEGMode{ClearLSB, RandomizeLSB, Requantize} {
+		out, err := Policy{JPEG: mode}.SanitizeJPEG(data)
+		if err != nil {
+			t.Fatalf("mode %s: %v", mode, err)
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+			t.Fatalf("mode %s: output does not decode: %v", mode, err)
+		}
+
+		img, err := jpegcoef.Decode(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range img.Components {
+			c, o := &img.Components[i], &orig.Components[i]
+			for j := range c.Blocks {
+				if c.Blocks[j][0] != o.Blocks[j][0] {
+					t.Fatalf("mode %s: DC coefficient changed", mode)
+				}
+				for k := 1; k < 64; k++ {
+					v, ov := c.Blocks[j][k], o.Blocks[j][k]
+					switch mode {
+					case ClearLSB:
+						if v != 0 && v != 1 && v&1 != 0 {
+							t.Fatalf("mode %s: odd coefficient %d left", mode, v)
+						}
+						fallthrough
+					case RandomizeLSB:
+						// 0 and 1 are left alone, and nothing else becomes one.
+						skipped := ov == 0 || ov == 1
+						if v-ov > 1 || ov-v > 1 || (skipped && v != ov) || (!skipped && (v == 0 || v == 1)) {
+							t.Fatalf("mode %s: coefficient %d became %d", mode, ov, v)
+						}
+					}
+				}
+			}
+		}
+
+		if mode == Requantize && img.Quant == orig.Quant {
+			t.Errorf("Requantize left the quantization tables unchanged")
+		}
+	}
+}
+
+func TestSanitizeJPEGRegions(t *testing.T) {
+	data, err := os.ReadFile("../../testfiles/samplegray.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig, err := jpegcoef.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	region := []image.Rectangle{image.Rect(0, 0, 16, 16)}
+
+	// Requantizing changes the tables every block is decoded with, so it
+	// has to rewrite every block, however few are flagged.
+	whole, err := Policy{JPEG: Requantize}.SanitizeJPEG(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localized, err := Policy{JPEG: Requantize, Regions: region}.SanitizeJPEG(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(whole, localized) {
+		t.Error("Requantize within a region differs from requantizing the whole image")
+	}
+
+	// Other modes only touch the blocks in the region.
+	out, err := Policy{JPEG: ClearLSB, Regions: region}.SanitizeJPEG(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpegcoef.Decode(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, o := &img.Components[0], &orig.Components[0]
+	changed := false
+	for by := 0; by < c.BlocksHigh; by++ {
+		for bx := 0; bx < c.BlocksWide; bx++ {
+			if *c.Block(bx, by) == *o.Block(bx, by) {
+				continue
+			}
+			if !img.BlockBounds(c, bx, by).Overlaps(region[0]) {
+				t.Fatalf("block (%d, %d) outside the region changed", bx, by)
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		t.Error("no block in the region changed")
+	}
+}
+
+func TestSanitizeJPEGSeed(t *testing.T) {
+	data, err := os.ReadFile("../../testfiles/samplegray.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sanitize := func(seed string) []byte {
+		out, err := Policy{JPEG: RandomizeLSB, Seed: []byte(seed)}.SanitizeJPEG(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	if !bytes.Equal(sanitize("a"), sanitize("a")) {
+		t.Error("the same seed gave different coefficients")
+	}
+	if bytes.Equal(sanitize("a"), sanitize("b")) {
+		t.Error("different seeds gave the same coefficients")
+	}
+}
+
+// jstegBits returns the LSB, in two's complement, of every AC coefficient of
+// img other than 0 and 1, which is where JSteg embeds, in scan order.
+func jstegBits(img *jpegcoef.Image) []int16 {
+	var bits []int16
+	img.Walk(func(_ *jpegcoef.Component, b *jpegcoef.Block) {
+		for _, v := range b[1:] {
+			if v != 0 && v != 1 {
+				bits = append(bits, v&1)
+			}
+		}
+	})
+	return bits
+}
+
+func TestSanitizeJPEGJSteg(t *testing.T) {
+	data, err := os.ReadFile("../../testfiles/samplegray.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpegcoef.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Embed a random payload at JSteg's full capacity, which swaps -1 and -2
+	// as often as any other pair.
+	rng := rand.New(rand.NewSource(1))
+	img.Walk(func(_ *jpegcoef.Component, b *jpegcoef.Block) {
+		for k := 1; k < 64; k++ {
+			if b[k] != 0 && b[k] != 1 {
+				b[k] = b[k]&^1 | int16(rng.Intn(2))
+			}
+		}
+	})
+	payload := jstegBits(img)
+	var buf bytes.Buffer
+	if err := jpegcoef.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []JPEGMode{ClearLSB, RandomizeLSB} {
+		out, err := Policy{JPEG: mode}.SanitizeJPEG(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		clean, err := jpegcoef.Decode(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// No coefficient moves in or out of JSteg's domain, so the bits
+		// line up with the payload's. Clearing leaves none of them set, and
+		// randomizing leaves about half of them matching it.
+		bits := jstegBits(clean)
+		if len(bits) != len(payload) {
+			t.Fatalf("mode %s: %d coefficients usable by JSteg, want %d", mode, len(bits), len(payload))
+		}
+		same := 0
+		for i, b := range bits {
+			if mode == ClearLSB && b != 0 {
+				t.Fatalf("mode %s: bit %d of the payload left set", mode, i)
+			}
+			if b == payload[i] {
+				same++
+			}
+		}
+		if f := float64(same) / float64(len(payload)); f > 0.55 {
+			t.Errorf("mode %s: %.3f of the payload recovered, want about half", mode, f)
+		}
+	}
+}
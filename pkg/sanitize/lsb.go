@@ -0,0 +1,209 @@
+package sanitize
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+)
+
+// MaxDepth is the largest number of bit planes SanitizeImage rewrites.
+const MaxDepth = 4
+
+// LSBMode selects how SanitizeImage rewrites the low bit planes of the pixels
+// of images other than JPEGs and palette images. Every mode leaves each sample
+// within 1<<k of its old value for each plane k it rewrites, and leaves no
+// trace of the old bits. The planes of 16-bit samples are those of their
+// native 16 bits, which is where an embedder would have written to.
+type LSBMode int
+
+const (
+	// ClearLSBs sets every bit of the plane to 0. It is the cheapest, but an
+	// image whose samples are all even is unnatural and easy to spot, and
+	// dark regions get visibly darker.
+	ClearLSBs LSBMode = iota
+	// RandomLSBs sets every bit of the plane to a bit from a CSPRNG seeded
+	// with Policy.Seed. The result looks like an image fully embedded by LSB
+	// replacement, which detectors such as RS will flag.
+	RandomLSBs
+	// NoiseLSBs adds or subtracts 1<<k, at random, to half the samples,
+	// which randomizes bit plane k like RandomLSBs but without the pairs of
+	// values LSB replacement leaves behind, so the image stays statistically
+	// plausible.
+	NoiseLSBs
+	// PatternLSBs sets the bits of the plane to a fixed dither, the same for
+	// every image, so that whatever they carry is known to be innocuous.
+	PatternLSBs
+)
+
+var lsbModeNames = map[LSBMode]string{
+	ClearLSBs:   "clear",
+	RandomLSBs:  "random",
+	NoiseLSBs:   "noise",
+	PatternLSBs: "pattern",
+}
+
+func (m LSBMode) String() string {
+	return lsbModeNames[m]
+}
+
+// ParseLSBMode returns the mode with the given name: "clear", "random",
+// "noise" or "pattern".
+func ParseLSBMode(name string) (LSBMode, error) {
+	for mode, n := range lsbModeNames {
+		if n == name {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown LSB sanitization mode: %s", name)
+}
+
+// bitStream is a stream of random bits from AES-256 in counter mode, keyed
+// with the SHA-256 of a seed.
+type bitStream struct {
+	stream cipher.Stream
+	buf    [64]byte
+	// n is the number of bits of buf used.
+	n int
+}
+
+// newBitStream returns a stream of bits seeded with seed, or with a random
+// seed if it is empty.
+func newBitStream(seed []byte) (*bitStream, error) {
+	if len(seed) == 0 {
+		seed = make([]byte, 32)
+		if _, err := crand.Read(seed); err != nil {
+			return nil, err
+		}
+	}
+	key := sha256.Sum256(seed)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	s := &bitStream{stream: cipher.NewCTR(block, make([]byte, aes.BlockSize))}
+	s.n = len(s.buf) * 8
+	return s, nil
+}
+
+// bit returns the next bit of the stream.
+func (s *bitStream) bit() uint8 {
+	if s.n == len(s.buf)*8 {
+		s.buf = [64]byte{}
+		s.stream.XORKeyStream(s.buf[:], s.buf[:])
+		s.n = 0
+	}
+	b := s.buf[s.n/8] >> (s.n % 8) & 1
+	s.n++
+	return b
+}
+
+// ParseLSBModes returns the modes with the given comma-separated names, as
+// for Policy.Planes.
+func ParseLSBModes(names string) ([]LSBMode, error) {
+	var modes []LSBMode
+	for _, name := range strings.Split(names, ",") {
+		mode, err := ParseLSBMode(name)
+		if err != nil {
+			return nil, err
+		}
+		modes = append(modes, mode)
+	}
+	return modes, nil
+}
+
+// depth returns the number of bit planes p rewrites.
+func (p Policy) depth() int {
+	if p.Depth == 0 {
+		return 1
+	}
+	return p.Depth
+}
+
+// sampleFunc rewrites the low bit planes of the sample v, of at most max, of
+// channel c of the pixel at x, y.
+type sampleFunc func(v, max uint16, x, y, c int) uint16
+
+// lsbFunc returns the function samples are rewritten with: each of the
+// p.Depth low bit planes in turn, from the LSB up, by its mode. As no mode
+// changes the planes below its own, each plane ends up as its mode left it.
+func (p Policy) lsbFunc() (sampleFunc, error) {
+	depth := p.depth()
+	if depth < 1 || depth > MaxDepth {
+		return nil, fmt.Errorf("Sanitization depth %d is not between 1 and %d", depth, MaxDepth)
+	}
+
+	var bits *bitStream
+	fns := make([]sampleFunc, depth)
+	for k := range fns {
+		mode := p.LSB
+		if k < len(p.Planes) {
+			mode = p.Planes[k]
+		}
+		if (mode == RandomLSBs || mode == NoiseLSBs) && bits == nil {
+			var err error
+			if bits, err = newBitStream(p.Seed); err != nil {
+				return nil, err
+			}
+		}
+
+		var err error
+		if fns[k], err = planeFunc(mode, uint(k), bits); err != nil {
+			return nil, err
+		}
+	}
+
+	if depth == 1 {
+		return fns[0], nil
+	}
+	return func(v, max uint16, x, y, c int) uint16 {
+		for _, fn := range fns {
+			v = fn(v, max, x, y, c)
+		}
+		return v
+	}, nil
+}
+
+// planeFunc returns the function mode rewrites bit plane k of a sample with.
+// Samples are kept at most max, which for premultiplied samples is their
+// alpha.
+func planeFunc(mode LSBMode, k uint, bits *bitStream) (sampleFunc, error) {
+	bit := uint16(1) << k
+	// set sets bit plane k of v to b, or clears it if that would exceed max.
+	set := func(v, max, b uint16) uint16 {
+		if v = v&^bit | b<<k; v > max {
+			v &^= bit
+		}
+		return v
+	}
+
+	switch mode {
+	case ClearLSBs:
+		return func(v, max uint16, x, y, c int) uint16 { return v &^ bit }, nil
+	case RandomLSBs:
+		return func(v, max uint16, x, y, c int) uint16 { return set(v, max, uint16(bits.bit())) }, nil
+	case NoiseLSBs:
+		return func(v, max uint16, x, y, c int) uint16 {
+			if bits.bit() == 0 {
+				return v
+			}
+			// Samples near either end can only move one way.
+			up, down := int(v)+int(bit) <= int(max), v >= bit
+			if up && down {
+				up = bits.bit() == 1
+			}
+			switch {
+			case up:
+				return v + bit
+			case down:
+				return v - bit
+			}
+			return v
+		}, nil
+	case PatternLSBs:
+		return func(v, max uint16, x, y, c int) uint16 { return set(v, max, uint16(x+y+c+int(k))&1) }, nil
+	}
+	return nil, fmt.Errorf("Unknown LSB sanitization mode: %d", int(mode))
+}
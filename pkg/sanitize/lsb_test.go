@@ -0,0 +1,112 @@
+package sanitize
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// noisyImage returns an image of random samples.
+func noisyImage(w, h int) *image.NRGBA {
+	rng := rand.New(rand.NewSource(1))
+	m := image.NewNRGBA(image.Rect(0, 0, w, h))
+	rng.Read(m.Pix)
+	for i := 3; i < len(m.Pix); i += 4 {
+		m.Pix[i] = 0xff
+	}
+	return m
+}
+
+func TestLSBModes(t *testing.T) {
+	old := noisyImage(64, 64)
+	for _, mode := range []LSBMode{ClearLSBs, RandomLSBs, NoiseLSBs, PatternLSBs} {
+		clean, err := Policy{LSB: mode}.SanitizeImage(old)
+		if err != nil {
+			t.Fatalf("%v: %v", mode, err)
+		}
+
+		// kept is the number of LSBs left as they were.
+		kept, samples := 0, 0
+		for y := 0; y < 64; y++ {
+			for x := 0; x < 64; x++ {
+				o := old.NRGBAAt(x, y)
+				c := color.NRGBAModel.Convert(clean.At(x, y)).(color.NRGBA)
+				for i, pair := range [][2]uint8{{o.R, c.R}, {o.G, c.G}, {o.B, c.B}} {
+					if d := int(pair[0]) - int(pair[1]); d < -1 || d > 1 {
+						t.Fatalf("%v: sample %d of (%d, %d) moved from %d to %d", mode, i, x, y, pair[0], pair[1])
+					}
+					if mode == ClearLSBs && pair[1]&1 != 0 || mode == PatternLSBs && pair[1]&1 != uint8(x+y+i)&1 {
+						t.Fatalf("%v: wrong LSB at (%d, %d)", mode, x, y)
+					}
+					if pair[0]&1 == pair[1]&1 {
+						kept++
+					}
+					samples++
+				}
+			}
+		}
+		// Half the LSBs of random samples are kept by chance.
+		if f := float64(kept) / float64(samples); f < 0.45 || f > 0.55 {
+			t.Errorf("%v: %.2f of LSBs kept, want about half", mode, f)
+		}
+	}
+}
+
+func TestLSBSeed(t *testing.T) {
+	old := noisyImage(16, 16)
+	sanitize := func(seed string) image.Image {
+		clean, err := Policy{LSB: NoiseLSBs, Seed: []byte(seed)}.SanitizeImage(old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return clean
+	}
+
+	a, b, c := sanitize("seed"), sanitize("seed"), sanitize("other")
+	same, differ := true, false
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			same = same && a.At(x, y) == b.At(x, y)
+			differ = differ || a.At(x, y) != c.At(x, y)
+		}
+	}
+	if !same || !differ {
+		t.Errorf("same seed, same image: %v; other seed, different image: %v", same, differ)
+	}
+}
+
+func TestDepth(t *testing.T) {
+	old := noisyImage(64, 64)
+	clean, err := Policy{LSB: RandomLSBs, Depth: 3, Planes: []LSBMode{ClearLSBs}}.SanitizeImage(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// kept is the number of bits of planes 1 and 2 left as they were.
+	kept, bits := 0, 0
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			o := old.NRGBAAt(x, y)
+			c := color.NRGBAModel.Convert(clean.At(x, y)).(color.NRGBA)
+			for _, pair := range [][2]uint8{{o.R, c.R}, {o.G, c.G}, {o.B, c.B}} {
+				if pair[0]>>3 != pair[1]>>3 || pair[1]&1 != 0 {
+					t.Fatalf("(%d, %d): %d sanitized to %d", x, y, pair[0], pair[1])
+				}
+				for k := uint(1); k < 3; k++ {
+					if pair[0]>>k&1 == pair[1]>>k&1 {
+						kept++
+					}
+					bits++
+				}
+			}
+		}
+	}
+	if f := float64(kept) / float64(bits); f < 0.45 || f > 0.55 {
+		t.Errorf("%.2f of bits kept, want about half", f)
+	}
+
+	if _, err := (Policy{Depth: MaxDepth + 1}).SanitizeImage(old); err == nil {
+		t.Errorf("depth %d accepted", MaxDepth+1)
+	}
+}
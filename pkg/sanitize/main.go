@@ -2,149 +2,315 @@ package sanitize
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"image"
-	"image/color"
-	"image/jpeg"
+	"image/draw"
 	"image/png"
 	"os"
+
+	"github.com/standardrhyme/stegsecure/pkg/metadata"
+	"github.com/standardrhyme/stegsecure/pkg/vp8l"
+	"golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
 )
 
-type CleanImg struct {
-	image.Image
-	custom map[image.Point]color.Color
+func SanitizeImage(old image.Image) (image.Image, error) {
+	return DefaultPolicy.SanitizeImage(old)
 }
 
-func NewCleanImg(img image.Image) *CleanImg {
-	return &CleanImg{img, map[image.Point]color.Color{}}
+// SanitizeImage rewrites the low bit planes of old according to p.LSB,
+// p.Depth and p.Planes, within p.Regions if set. Palette images have their
+// palette rebuilt instead, see sanitizePaletted.
+//
+// The result is a copy of old of the same type, so that it keeps its colour
+// model, alpha and bit depth, with its samples rewritten where they are
+// stored: premultiplied samples stay premultiplied, and YCbCr images have
+// their Y, Cb and Cr samples rewritten. Alpha is rewritten too, see alphaFunc,
+// and the colour of fully transparent pixels cleared. If p.Background is set,
+// images are flattened against it first.
+func (p Policy) SanitizeImage(old image.Image) (image.Image, error) {
+	if p.Background != nil {
+		old = flatten(old, p.Background)
+	}
+	if m, ok := old.(*image.Paletted); ok {
+		return p.sanitizePaletted(m), nil
+	}
+
+	lsb, err := p.lsbFunc()
+	if err != nil {
+		return nil, err
+	}
+	alpha := p.alphaFunc(lsb)
+	regions := p.regions(old.Bounds())
+
+	switch m := old.(type) {
+	case *image.NRGBA:
+		clean := &image.NRGBA{Pix: clone(m.Pix), Stride: m.Stride, Rect: m.Rect}
+		for _, r := range regions {
+			rewrite8(clean.Pix, r, clean.PixOffset, 3, 3, false, lsb, alpha)
+		}
+		return clean, nil
+	case *image.RGBA:
+		clean := &image.RGBA{Pix: clone(m.Pix), Stride: m.Stride, Rect: m.Rect}
+		for _, r := range regions {
+			rewrite8(clean.Pix, r, clean.PixOffset, 3, 3, true, lsb, alpha)
+		}
+		return clean, nil
+	case *image.Gray:
+		clean := &image.Gray{Pix: clone(m.Pix), Stride: m.Stride, Rect: m.Rect}
+		for _, r := range regions {
+			rewrite8(clean.Pix, r, clean.PixOffset, 1, -1, false, lsb, nil)
+		}
+		return clean, nil
+	case *image.CMYK:
+		clean := &image.CMYK{Pix: clone(m.Pix), Stride: m.Stride, Rect: m.Rect}
+		for _, r := range regions {
+			rewrite8(clean.Pix, r, clean.PixOffset, 4, -1, false, lsb, nil)
+		}
+		return clean, nil
+	case *image.NRGBA64:
+		clean := &image.NRGBA64{Pix: clone(m.Pix), Stride: m.Stride, Rect: m.Rect}
+		for _, r := range regions {
+			rewrite16(clean.Pix, r, clean.PixOffset, 3, 3, false, lsb, alpha)
+		}
+		return clean, nil
+	case *image.RGBA64:
+		clean := &image.RGBA64{Pix: clone(m.Pix), Stride: m.Stride, Rect: m.Rect}
+		for _, r := range regions {
+			rewrite16(clean.Pix, r, clean.PixOffset, 3, 3, true, lsb, alpha)
+		}
+		return clean, nil
+	case *image.Gray16:
+		clean := &image.Gray16{Pix: clone(m.Pix), Stride: m.Stride, Rect: m.Rect}
+		for _, r := range regions {
+			rewrite16(clean.Pix, r, clean.PixOffset, 1, -1, false, lsb, nil)
+		}
+		return clean, nil
+	case *image.YCbCr:
+		clean := cloneYCbCr(m)
+		for _, r := range regions {
+			rewriteYCbCr(clean, r, lsb)
+		}
+		return clean, nil
+	case *image.NYCbCrA:
+		clean := &image.NYCbCrA{YCbCr: *cloneYCbCr(&m.YCbCr), A: clone(m.A), AStride: m.AStride}
+		for _, r := range regions {
+			rewriteYCbCr(&clean.YCbCr, r, lsb)
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					ai := clean.AOffset(x, y)
+					if clean.A[ai] = uint8(alpha(uint16(clean.A[ai]), 0xff, x, y, 3)); clean.A[ai] == 0 {
+						// Chroma is shared with other pixels, but luma
+						// can be cleared.
+						clean.Y[clean.YOffset(x, y)] = 0
+					}
+				}
+			}
+		}
+		return clean, nil
+	}
+	return nil, fmt.Errorf("Unsupported Format")
 }
 
-func (m *CleanImg) Set(x, y int, c color.Color) {
-	m.custom[image.Point{X: x, Y: y}] = c
+func clone(pix []uint8) []uint8 {
+	return append([]uint8(nil), pix...)
 }
 
-func (m *CleanImg) At(x, y int) color.Color {
-	// Explicitly changed part: custom colors of the changed pixels:
-	if c, ok := m.custom[image.Point{X: x, Y: y}]; ok {
-		return c
+func cloneYCbCr(m *image.YCbCr) *image.YCbCr {
+	return &image.YCbCr{
+		Y:              clone(m.Y),
+		Cb:             clone(m.Cb),
+		Cr:             clone(m.Cr),
+		YStride:        m.YStride,
+		CStride:        m.CStride,
+		SubsampleRatio: m.SubsampleRatio,
+		Rect:           m.Rect,
 	}
-	// Unchanged part: colors of the original image:
-	return m.Image.At(x, y)
 }
 
-func SanitizeImage(old image.Image) (image.Image, error) {
-	clean := NewCleanImg(old)
-	bound := old.Bounds()
-
-	var pixelFormat int
-	switch old.(type) {
-	case *image.RGBA:
-		pixelFormat = 1
-	case *image.YCbCr:
-		pixelFormat = 2
-	default:
-		return nil, fmt.Errorf("Unsupported Format")
+// rewrite8 rewrites the first channels 8-bit samples of each pixel of r with
+// lsb, where offset returns the offset of a pixel in pix. If alpha is not -1,
+// it is the index of the alpha sample, which is rewritten with alphaFn. The
+// colour of fully transparent pixels is then cleared, as nothing shows it,
+// and premultiplied samples are kept at most their alpha.
+func rewrite8(pix []uint8, r image.Rectangle, offset func(x, y int) int, channels, alpha int, premultiplied bool, lsb, alphaFn sampleFunc) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			i := offset(x, y)
+			max := uint16(0xff)
+			if alpha >= 0 {
+				a := alphaFn(uint16(pix[i+alpha]), 0xff, x, y, alpha)
+				pix[i+alpha] = uint8(a)
+				if a == 0 {
+					for c := 0; c < channels; c++ {
+						pix[i+c] = 0
+					}
+					continue
+				}
+				if premultiplied {
+					max = a
+				}
+			}
+			for c := 0; c < channels; c++ {
+				v := lsb(uint16(pix[i+c]), max, x, y, c)
+				if v > max {
+					v = max
+				}
+				pix[i+c] = uint8(v)
+			}
+		}
 	}
+}
 
-	for y := bound.Min.Y; y < bound.Max.Y; y++ {
-		SanitizeRow(bound.Min.X, bound.Max.X, y, old, *clean, pixelFormat)
+// rewrite16 is rewrite8 for big-endian 16-bit samples.
+func rewrite16(pix []uint8, r image.Rectangle, offset func(x, y int) int, channels, alpha int, premultiplied bool, lsb, alphaFn sampleFunc) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			i := offset(x, y)
+			max := uint16(0xffff)
+			if alpha >= 0 {
+				a := alphaFn(binary.BigEndian.Uint16(pix[i+2*alpha:]), 0xffff, x, y, alpha)
+				binary.BigEndian.PutUint16(pix[i+2*alpha:], a)
+				if a == 0 {
+					for c := 0; c < 2*channels; c++ {
+						pix[i+c] = 0
+					}
+					continue
+				}
+				if premultiplied {
+					max = a
+				}
+			}
+			for c := 0; c < channels; c++ {
+				v := lsb(binary.BigEndian.Uint16(pix[i+2*c:]), max, x, y, c)
+				if v > max {
+					v = max
+				}
+				binary.BigEndian.PutUint16(pix[i+2*c:], v)
+			}
+		}
 	}
+}
+
+// rewriteYCbCr rewrites the Y, Cb and Cr samples of the pixels of r with lsb.
+// Chroma samples shared by several pixels are rewritten once, for the first
+// of them in r.
+func rewriteYCbCr(m *image.YCbCr, r image.Rectangle, lsb sampleFunc) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			yi := m.YOffset(x, y)
+			m.Y[yi] = uint8(lsb(uint16(m.Y[yi]), 0xff, x, y, 0))
 
-	return clean, nil
+			ci := m.COffset(x, y)
+			if (x > r.Min.X && m.COffset(x-1, y) == ci) || (y > r.Min.Y && m.COffset(x, y-1) == ci) {
+				continue
+			}
+			m.Cb[ci] = uint8(lsb(uint16(m.Cb[ci]), 0xff, x, y, 1))
+			m.Cr[ci] = uint8(lsb(uint16(m.Cr[ci]), 0xff, x, y, 2))
+		}
+	}
 }
 
 func SanitizeBytes(data []byte, format string) []byte {
-	var out bytes.Buffer
-	imgReader := bytes.NewReader(data)
-
-	old, format, err := image.Decode(imgReader)
+	out, err := DefaultPolicy.SanitizeBytes(data)
 	if err != nil {
+		println(err.Error())
 		return data
 	}
+	return out
+}
 
-	clean, err := SanitizeImage(old)
+// SanitizeBytes decodes data, sanitizes it according to p and re-encodes it in
+// its original format. JPEGs are sanitized in the DCT domain, see SanitizeJPEG,
+// GIFs frame by frame, see SanitizeGIF, and TIFFs page by page, see
+// SanitizeTIFF. WebPs are written back as lossless WebPs, even if they were
+// lossy: there is no lossy encoder, and re-encoding discards the VP8
+// coefficients a lossy payload would be hidden in anyway. Metadata is then
+// stripped according to p.Metadata, and the EXIF thumbnail, if one is left,
+// rewritten according to p.Thumbnail.
+func (p Policy) SanitizeBytes(data []byte) ([]byte, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
 	if err != nil {
-		return data
+		return nil, err
 	}
 
-	if format == "png" {
-		err = png.Encode(&out, clean)
-	} else if format == "jpeg" {
-		err = jpeg.Encode(&out, clean, nil)
-	} else {
-		println("Unsupported format")
-		return data
+	out, err := p.sanitizeFormat(data, format)
+	if err != nil {
+		return nil, err
 	}
-
+	out, err = metadata.Strip(out, format, p.Metadata)
 	if err != nil {
-		return data
+		return nil, err
 	}
-
-	return out.Bytes()
+	// The thumbnail could carry a payload of its own.
+	return metadata.RewriteThumbnail(out, format, p.Thumbnail, nil)
 }
 
-func SanitizePath(path string) error {
-	pic, err := os.Open(path)
-	if err != nil {
-		return err
+// sanitizeFormat sanitizes data, which is of the given format. WebPs are
+// re-encoded losslessly: a lossy WebP comes out several times bigger, and
+// random or noise LSBs, which do not compress, make any WebP bigger still.
+func (p Policy) sanitizeFormat(data []byte, format string) ([]byte, error) {
+	switch format {
+	case "jpeg":
+		return p.SanitizeJPEG(data)
+	case "gif":
+		return p.SanitizeGIF(data)
+	case "tiff":
+		return p.SanitizeTIFF(data)
 	}
 
-	old, format, err := image.Decode(pic)
+	var out bytes.Buffer
+	old, format, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	clean, err := SanitizeImage(old)
-	if err != nil {
-		return err
+	if format == "webp" {
+		switch old.(type) {
+		case *image.YCbCr, *image.NYCbCrA:
+			// Lossy WebPs are written back as lossless ones, which store
+			// RGB samples, so those are the ones to sanitize.
+			nrgba := image.NewNRGBA(old.Bounds())
+			draw.Draw(nrgba, nrgba.Bounds(), old, old.Bounds().Min, draw.Src)
+			old = nrgba
+		}
 	}
 
-	out, err := os.Create(path)
+	clean, err := p.SanitizeImage(old)
 	if err != nil {
-		return err
+		return nil, err
 	}
+
 	if format == "png" {
-		err = png.Encode(out, clean)
-	} else if format == "jpeg" {
-		err = jpeg.Encode(out, clean, nil)
+		err = png.Encode(&out, clean)
+	} else if format == "bmp" {
+		err = bmp.Encode(&out, clean)
+	} else if format == "webp" {
+		err = vp8l.Encode(&out, clean)
 	} else {
-		return fmt.Errorf("Unsupported format")
+		return nil, fmt.Errorf("Unsupported format")
 	}
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return pic.Close()
+	return out.Bytes(), nil
 }
 
-func SanitizeRow(min int, max int, y int, old image.Image, clean CleanImg, pixelFormat int) {
-	if pixelFormat == 1 {
-		for x := min; x < max; x++ {
-			SanitizePixelRGBA(x, y, old, clean)
-		}
-	}
-	if pixelFormat == 2 {
-		for x := min; x < max; x++ {
-			SanitizePixelYCbCr(x, y, old, clean)
-		}
+func SanitizePath(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
 	}
-}
 
-func SanitizePixelRGBA(x int, y int, old image.Image, clean CleanImg) {
-	pixel := old.At(x, y)
-	r, g, b, a := pixel.RGBA()
-	clean.Set(x, y, color.RGBA{R: uint8(2 * (r / 2)), G: uint8(2 * (g / 2)), B: uint8(2 * (b / 2)), A: uint8(a)})
-}
+	clean, err := DefaultPolicy.SanitizeBytes(data)
+	if err != nil {
+		return err
+	}
 
-func SanitizePixelYCbCr(x int, y int, old image.Image, clean CleanImg) {
-	pixel := old.At(x, y)
-	r, g, b, _ := pixel.RGBA()
-	r /= 256
-	g /= 256
-	b /= 256
-	Y, Cb, Cr := color.RGBToYCbCr(uint8(2*(r/2)), uint8(2*(g/2)), uint8(2*(b/2)))
-	clean.Set(x, y, color.YCbCr{Y: Y, Cb: Cb, Cr: Cr})
+	return os.WriteFile(path, clean, 0644)
 }
 
 //func main() {
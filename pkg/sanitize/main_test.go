@@ -0,0 +1,199 @@
+package sanitize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/standardrhyme/stegsecure/pkg/vp8l"
+	"golang.org/x/image/bmp"
+)
+
+func TestSanitizeBytesKeepsBMP(t *testing.T) {
+	var buf bytes.Buffer
+	if err := bmp.Encode(&buf, oddImage(5, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := DefaultPolicy.SanitizeBytes(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, format, err := image.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "bmp" {
+		t.Errorf("sanitized BMP came back as %s", format)
+	}
+	if r, _, _, _ := m.At(2, 1).RGBA(); (r>>8)%2 != 0 {
+		t.Errorf("pixel not sanitized")
+	}
+}
+
+func TestSanitizeBytesKeepsWebP(t *testing.T) {
+	for _, name := range []string{"samplelossless.webp", "samplelossy.webp"} {
+		data, err := os.ReadFile("../../testfiles/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		old, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := DefaultPolicy.SanitizeBytes(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !vp8l.IsLossless(out) {
+			t.Errorf("%s: not sanitized to a lossless WebP", name)
+		}
+		m, format, err := image.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if format != "webp" || m.Bounds() != old.Bounds() {
+			t.Fatalf("%s: sanitized to a %s of %v, want a webp of %v", name, format, m.Bounds(), old.Bounds())
+		}
+
+		b := m.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if c := m.(*image.NRGBA).NRGBAAt(x, y); c.A == 0xff && (c.R%2 != 0 || c.G%2 != 0 || c.B%2 != 0) {
+					t.Fatalf("%s: pixel (%d, %d) = %v not sanitized", name, x, y, c)
+				}
+			}
+		}
+	}
+}
+
+func TestSanitizeImageKeepsType(t *testing.T) {
+	r := image.Rect(0, 0, 9, 7)
+	rng := rand.New(rand.NewSource(1))
+	// premultiply keeps the colour samples of 8-bit premultiplied pixels at
+	// most their alpha.
+	premultiply := func(pix []uint8) {
+		for i := 0; i < len(pix); i += 4 {
+			for c := 0; c < 3; c++ {
+				pix[i+c] %= pix[i+3]/2 + 1
+			}
+		}
+	}
+
+	// Each image is listed with its sample size in bytes, the number of
+	// samples of each pixel and how many of them are colour samples.
+	for _, test := range []struct {
+		im                   image.Image
+		size, stride, colour int
+	}{
+		{image.NewNRGBA(r), 1, 4, 3},
+		{image.NewRGBA(r), 1, 4, 3},
+		{image.NewGray(r), 1, 1, 1},
+		{image.NewCMYK(r), 1, 4, 4},
+		{image.NewNRGBA64(r), 2, 4, 3},
+		{image.NewRGBA64(r), 2, 4, 3},
+		{image.NewGray16(r), 2, 1, 1},
+		{image.NewYCbCr(r, image.YCbCrSubsampleRatio420), 1, 1, 1},
+		{image.NewNYCbCrA(r, image.YCbCrSubsampleRatio420), 1, 1, 1},
+	} {
+		name := reflect.TypeOf(test.im).String()
+
+		// pix are the sample slices of the image, and the last of them is
+		// alpha if it is not interleaved.
+		pix := func(im image.Image) [][]uint8 {
+			switch m := im.(type) {
+			case *image.YCbCr:
+				return [][]uint8{m.Y, m.Cb, m.Cr}
+			case *image.NYCbCrA:
+				return [][]uint8{m.Y, m.Cb, m.Cr, m.A}
+			}
+			return [][]uint8{reflect.ValueOf(im).Elem().FieldByName("Pix").Bytes()}
+		}
+		for _, p := range pix(test.im) {
+			rng.Read(p)
+		}
+		// The first pixel is transparent.
+		switch m := test.im.(type) {
+		case *image.NRGBA:
+			m.Pix[3] = 0
+		case *image.RGBA:
+			m.Pix[3] = 0
+		case *image.NRGBA64:
+			m.Pix[6], m.Pix[7] = 0, 0
+		case *image.NYCbCrA:
+			m.A[0] = 0
+		}
+		switch m := test.im.(type) {
+		case *image.RGBA:
+			premultiply(m.Pix)
+		case *image.RGBA64:
+			// Opaque, as 16-bit premultiplied samples are not checked.
+			for i := 6; i < len(m.Pix); i += 8 {
+				m.Pix[i], m.Pix[i+1] = 0xff, 0xff
+			}
+		}
+		old := pix(test.im)
+		for i := range old {
+			old[i] = append([]uint8(nil), old[i]...)
+		}
+
+		clean, err := DefaultPolicy.SanitizeImage(test.im)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if reflect.TypeOf(clean) != reflect.TypeOf(test.im) || clean.Bounds() != r {
+			t.Fatalf("%s: sanitized to a %T of %v", name, clean, clean.Bounds())
+		}
+		for k, p := range pix(test.im) {
+			if !bytes.Equal(p, old[k]) {
+				t.Fatalf("%s: image sanitized in place", name)
+			}
+		}
+
+		max := uint16(1)<<(8*uint(test.size)) - 1
+		read := func(b []uint8) uint16 {
+			if test.size == 2 {
+				return binary.BigEndian.Uint16(b)
+			}
+			return uint16(b[0])
+		}
+		sanitized := pix(clean)
+		for k, p := range sanitized {
+			for j := 0; j < len(p); j += test.size {
+				v, o := read(p[j:]), read(old[k][j:])
+				channel := j / test.size % test.stride
+
+				// a is the sanitized alpha of the pixel, if it has one.
+				a := max
+				if test.stride == 4 && test.colour == 3 {
+					a = read(p[j+(3-channel)*test.size:])
+				} else if len(sanitized) == 4 && k == 0 {
+					a = read(sanitized[3][j:])
+				}
+
+				want := o &^ 1
+				switch {
+				case k == 3 || test.colour == 3 && channel == 3:
+					// Alpha is snapped to transparent or opaque.
+					if o <= 1 {
+						want = 0
+					} else if o >= max-1 {
+						want = max
+					}
+				case channel >= test.colour:
+					want = o
+				case a == 0:
+					want = 0
+				}
+				if v != want {
+					t.Fatalf("%s: sample %d of plane %d sanitized from %d to %d, want %d", name, j/test.size, k, o, v, want)
+				}
+			}
+		}
+	}
+}
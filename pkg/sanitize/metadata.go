@@ -0,0 +1,29 @@
+package sanitize
+
+import (
+	"bytes"
+	"image"
+
+	"github.com/standardrhyme/stegsecure/pkg/metadata"
+)
+
+// StripMetadata returns data with metadata stripped according to p.Metadata,
+// leaving the image itself untouched. SanitizeBytes strips metadata too, but
+// StripMetadata can be used on files whose pixels are clean.
+func (p Policy) StripMetadata(data []byte) ([]byte, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return metadata.Strip(data, format, p.Metadata)
+}
+
+// SanitizeThumbnail returns data with its EXIF thumbnail regenerated or
+// dropped according to p.Thumbnail, leaving the image itself untouched.
+func (p Policy) SanitizeThumbnail(data []byte) ([]byte, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return metadata.RewriteThumbnail(data, format, p.Thumbnail, nil)
+}
@@ -0,0 +1,197 @@
+package sanitize
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"sort"
+)
+
+// duplicateDistance is the largest difference in any 8-bit channel between
+// two colours which are merged into one.
+const duplicateDistance = 2
+
+// Opaque reports whether a palette entry is fully opaque. Other entries, such
+// as the transparent index of a GIF, are left out of palette analysis and
+// sanitization.
+func Opaque(c color.Color) bool {
+	_, _, _, a := c.RGBA()
+	return a == 0xffff
+}
+
+// Luminance returns the luma of c, scaled to 0..255000, which is what
+// EzStego sorts palettes by.
+func Luminance(c color.Color) int {
+	r, g, b, _ := c.RGBA()
+	return int(299*(r>>8) + 587*(g>>8) + 114*(b>>8))
+}
+
+// near reports whether c and d differ by at most duplicateDistance in every
+// channel.
+func near(c, d color.Color) bool {
+	c8 := color.NRGBAModel.Convert(c).(color.NRGBA)
+	d8 := color.NRGBAModel.Convert(d).(color.NRGBA)
+	for _, diff := range []int{int(c8.R) - int(d8.R), int(c8.G) - int(d8.G), int(c8.B) - int(d8.B)} {
+		if diff < -duplicateDistance || diff > duplicateDistance {
+			return false
+		}
+	}
+	return true
+}
+
+// sanitizePaletted rebuilds the palette of m and re-maps its indices, within
+// p.Regions if set. It is the palette counterpart of clearing LSBs:
+//
+//   - Near-duplicate colours, which S-Tools chooses between, are merged into
+//     the most common of them.
+//   - Each pair of colours adjacent in the palette sorted by luminance, which
+//     EzStego chooses between, is then merged into the more common of the
+//     two, so that the parity of a pixel's rank no longer carries a bit.
+//     Where the pair is further apart than a near-duplicate, this changes
+//     pixels as visibly as embedding did, but it is the only way to remove
+//     the payload without leaving random parities for the palette detector
+//     to flag.
+//   - The palette is rebuilt from the colours left, most common first, which
+//     also discards any payload in the order of the palette (Gifshuffle) and
+//     in unused entries.
+func (p Policy) sanitizePaletted(m *image.Paletted) *image.Paletted {
+	b := m.Bounds()
+	counts := make([]int, len(m.Palette))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for _, i := range m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)] {
+			if int(i) < len(counts) {
+				counts[i]++
+			}
+		}
+	}
+
+	// target is the entry each entry is merged into. Each colour first
+	// absorbs the near-duplicates of it which are less common, so that none
+	// of them moves further than duplicateDistance.
+	target := make([]int, len(m.Palette))
+	for i := range target {
+		target[i] = i
+	}
+	var kept []int
+	for i, c := range m.Palette {
+		if Opaque(c) {
+			kept = append(kept, i)
+		}
+	}
+	sort.SliceStable(kept, func(a, b int) bool { return counts[kept[a]] > counts[kept[b]] })
+	for a, i := range kept {
+		if target[i] != i {
+			continue
+		}
+		for _, j := range kept[a+1:] {
+			if target[j] == j && near(m.Palette[i], m.Palette[j]) {
+				target[j] = i
+			}
+		}
+	}
+
+	// Pair the entries as EzStego ranks them, and merge the colours each pair
+	// was merged into so far, along with all the entries merged into them.
+	merged := make([]int, len(m.Palette))
+	for i, n := range counts {
+		merged[target[i]] += n
+	}
+	sorted := append([]int(nil), kept...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return Luminance(m.Palette[sorted[a]]) < Luminance(m.Palette[sorted[b]])
+	})
+	for k := 0; k+1 < len(sorted); k += 2 {
+		i, j := target[sorted[k]], target[sorted[k+1]]
+		if i == j {
+			continue
+		}
+		if merged[j] > merged[i] || (merged[j] == merged[i] && j < i) {
+			i, j = j, i
+		}
+		for e := range target {
+			if target[e] == j {
+				target[e] = i
+			}
+		}
+		merged[i] += merged[j]
+		merged[j] = 0
+	}
+
+	regions := p.regions(b)
+	inside := func(x, y int) bool {
+		if len(p.Regions) == 0 {
+			return true
+		}
+		for _, r := range regions {
+			if (image.Point{X: x, Y: y}).In(r) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Count the entries used after merging, to order the new palette by.
+	out := image.NewPaletted(b, nil)
+	used := make([]int, len(m.Palette))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			i := int(m.Pix[m.PixOffset(x, y)])
+			if i < len(target) && inside(x, y) {
+				i = target[i]
+			}
+			out.Pix[out.PixOffset(x, y)] = uint8(i)
+			if i < len(used) {
+				used[i]++
+			}
+		}
+	}
+
+	var order []int
+	for i, n := range used {
+		if n > 0 {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool { return used[order[a]] > used[order[b]] })
+	if len(order) == 0 && len(m.Palette) > 0 {
+		order = []int{0}
+	}
+
+	index := make([]uint8, len(m.Palette))
+	for n, i := range order {
+		index[i] = uint8(n)
+		out.Palette = append(out.Palette, m.Palette[i])
+	}
+	for j, i := range out.Pix {
+		if int(i) < len(index) {
+			out.Pix[j] = index[i]
+		}
+	}
+
+	return out
+}
+
+// SanitizeGIF sanitizes every frame of a GIF with its own rebuilt palette,
+// keeping its timing, disposal and looping.
+func (p Policy) SanitizeGIF(data []byte) ([]byte, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, m := range g.Image {
+		g.Image[i] = p.sanitizePaletted(m)
+	}
+
+	// Every frame now has a palette of its own, so the global one, and the
+	// background index into it, are dropped.
+	g.Config.ColorModel = nil
+	g.BackgroundIndex = 0
+
+	var out bytes.Buffer
+	if err := gif.EncodeAll(&out, g); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
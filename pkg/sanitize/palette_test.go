@@ -0,0 +1,152 @@
+package sanitize
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+
+	_ "image/png"
+)
+
+func TestSanitizeGIF(t *testing.T) {
+	// Two frames over a palette with a near-duplicate of each colour, one of
+	// them with a transparent index. The colours are paired in luminance
+	// order as EzStego pairs them, with white left over, and there are eight
+	// entries so that the GIF encoder adds none.
+	palette := color.Palette{
+		color.RGBA{R: 200, G: 10, B: 10, A: 0xff},
+		color.RGBA{R: 201, G: 10, B: 10, A: 0xff},
+		color.RGBA{R: 10, G: 10, B: 200, A: 0xff},
+		color.RGBA{R: 10, G: 11, B: 200, A: 0xff},
+		color.RGBA{R: 10, G: 200, B: 10, A: 0xff},
+		color.RGBA{R: 11, G: 200, B: 10, A: 0xff},
+		color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+		color.RGBA{},
+	}
+	g := &gif.GIF{Delay: []int{10, 20}, Disposal: []byte{gif.DisposalNone, gif.DisposalBackground}, LoopCount: 2}
+	for f := 0; f < 2; f++ {
+		m := image.NewPaletted(image.Rect(0, 0, 16, 16), palette)
+		for j := range m.Pix {
+			m.Pix[j] = uint8((j + f) % len(palette))
+		}
+		g.Image = append(g.Image, m)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	out, err := DefaultPolicy.SanitizeBytes(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clean, err := gif.DecodeAll(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(clean.Image) != 2 || clean.Delay[1] != 20 || clean.Disposal[1] != gif.DisposalBackground || clean.LoopCount != 2 {
+		t.Fatalf("frames, timing or looping not kept: %d frames, delays %v, disposal %v, loop %d",
+			len(clean.Image), clean.Delay, clean.Disposal, clean.LoopCount)
+	}
+	for f, m := range clean.Image {
+		// Each near-duplicate pair is merged, and the transparent index kept.
+		used := map[uint8]bool{}
+		for j, i := range m.Pix {
+			used[i] = true
+			_, _, _, a := m.Palette[i].RGBA()
+			if want := (j+f)%len(palette) == 7; (a == 0) != want {
+				t.Fatalf("frame %d: transparency of pixel %d changed", f, j)
+			}
+		}
+		if len(used) != 5 {
+			t.Errorf("frame %d uses %d colours, want 5", f, len(used))
+		}
+	}
+}
+
+// embedEzStego simulates EzStego at full capacity, setting the parity of the
+// rank of each pixel's colour in luminance order to the next payload bit.
+func embedEzStego(m *image.Paletted, payload []uint8) {
+	sorted, rank := luminanceRanks(m.Palette)
+	for j, i := range m.Pix {
+		if r := rank[i]&^1 | int(payload[j]); r < len(sorted) {
+			m.Pix[j] = uint8(sorted[r])
+		}
+	}
+}
+
+// extractEzStego returns the parity of the rank of each pixel's colour in
+// luminance order, which is where EzStego embeds.
+func extractEzStego(m *image.Paletted) []uint8 {
+	_, rank := luminanceRanks(m.Palette)
+	bits := make([]uint8, len(m.Pix))
+	for j, i := range m.Pix {
+		bits[j] = uint8(rank[i] & 1)
+	}
+	return bits
+}
+
+// luminanceRanks returns the entries of p sorted by luminance, and the rank
+// of each entry in that order.
+func luminanceRanks(p color.Palette) ([]int, []int) {
+	sorted := make([]int, len(p))
+	for i := range sorted {
+		sorted[i] = i
+	}
+	sort.SliceStable(sorted, func(a, b int) bool { return Luminance(p[sorted[a]]) < Luminance(p[sorted[b]]) })
+	rank := make([]int, len(p))
+	for r, i := range sorted {
+		rank[i] = r
+	}
+	return sorted, rank
+}
+
+func TestSanitizePalettedEzStego(t *testing.T) {
+	f, err := os.Open("../../testfiles/samplesmaller.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Plan9 has colours of about the same luminance but different hue, so
+	// that EzStego's pairs are mostly not near-duplicates.
+	m := image.NewPaletted(src.Bounds(), palette.Plan9)
+	draw.FloydSteinberg.Draw(m, m.Rect, src, src.Bounds().Min)
+	payload := make([]uint8, len(m.Pix))
+	rng := rand.New(rand.NewSource(1))
+	for j := range payload {
+		payload[j] = uint8(rng.Intn(2))
+	}
+	embedEzStego(m, payload)
+
+	// agreement is the fraction of payload bits extracted from p.
+	agreement := func(p *image.Paletted) float64 {
+		same := 0
+		for j, b := range extractEzStego(p) {
+			if b == payload[j] {
+				same++
+			}
+		}
+		return float64(same) / float64(len(payload))
+	}
+	if a := agreement(m); a != 1 {
+		t.Fatalf("%.3f of the payload extracted before sanitizing", a)
+	}
+
+	clean := DefaultPolicy.sanitizePaletted(m)
+	if a := agreement(clean); a > 0.6 {
+		t.Errorf("%.3f of the payload extracted after sanitizing, want about half", a)
+	}
+}
@@ -0,0 +1,35 @@
+package sanitize
+
+import (
+	"github.com/standardrhyme/stegsecure/pkg/pngchunk"
+)
+
+// StripPNGChunks returns the PNG in data with only its critical chunks and
+// the ancillary chunks in p.PNGChunks. The pixel data is copied as it is, and
+// anything after IEND is dropped.
+func (p Policy) StripPNGChunks(data []byte) ([]byte, error) {
+	chunks, err := pngchunk.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := make(map[string]bool)
+	for _, t := range pngchunk.Critical {
+		keep[t] = true
+	}
+	whitelist := p.PNGChunks
+	if whitelist == nil {
+		whitelist = pngchunk.DefaultKeep
+	}
+	for _, t := range whitelist {
+		keep[t] = true
+	}
+
+	kept := chunks[:0:0]
+	for _, c := range chunks {
+		if keep[c.Type] {
+			kept = append(kept, c)
+		}
+	}
+	return pngchunk.Encode(kept), nil
+}
@@ -0,0 +1,57 @@
+package sanitize
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/standardrhyme/stegsecure/pkg/pngchunk"
+)
+
+func TestStripPNGChunks(t *testing.T) {
+	data, err := os.ReadFile("../../testfiles/samplesmaller.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		keep []string
+		want string
+	}{
+		{nil, "IHDR iCCP pHYs IDAT IEND"},
+		{[]string{"tIME"}, "IHDR tIME IDAT IEND"},
+	} {
+		out, err := Policy{PNGChunks: test.keep}.StripPNGChunks(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before, _ := pngchunk.Parse(data)
+		after, err := pngchunk.Parse(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var types []string
+		var idatBefore, idatAfter []byte
+		for _, c := range after {
+			if c.Type == "IDAT" {
+				idatAfter = append(idatAfter, c.Data...)
+			}
+			if len(types) == 0 || types[len(types)-1] != c.Type {
+				types = append(types, c.Type)
+			}
+		}
+		for _, c := range before {
+			if c.Type == "IDAT" {
+				idatBefore = append(idatBefore, c.Data...)
+			}
+		}
+		if got := strings.Join(types, " "); got != test.want {
+			t.Errorf("keeping %v: got chunks %s, want %s", test.keep, got, test.want)
+		}
+		if !bytes.Equal(idatBefore, idatAfter) {
+			t.Errorf("keeping %v: pixel data changed", test.keep)
+		}
+	}
+}
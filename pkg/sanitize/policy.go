@@ -0,0 +1,73 @@
+package sanitize
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/standardrhyme/stegsecure/pkg/metadata"
+)
+
+// Policy controls how an image is sanitized.
+type Policy struct {
+	// Regions limits sanitization to the given rectangles, e.g. the tiles a
+	// steganalysis heatmap flagged. If empty, the whole image is sanitized.
+	// Requantize, which changes the tables of the whole JPEG, ignores them.
+	Regions []image.Rectangle
+
+	// LSB is how the low bit planes of pixels are rewritten.
+	LSB LSBMode
+	// Depth is the number of bit planes rewritten, from 1 to MaxDepth, as
+	// LSB-2 and LSB-3 embedders write to the low two or three. Zero means 1.
+	Depth int
+	// Planes, if set, are the modes of bit planes 0, 1 and so on, overriding
+	// LSB for the planes they cover.
+	Planes []LSBMode
+	// Background, if set, is the colour images with alpha are flattened
+	// against, leaving them opaque, rather than having their alpha
+	// sanitized. It should be opaque.
+	Background color.Color
+	// Seed seeds the random bits of RandomLSBs, NoiseLSBs and RandomizeLSB.
+	// If empty, a random seed is used.
+	Seed []byte
+
+	// JPEG is how JPEGs are sanitized, in the DCT domain.
+	JPEG JPEGMode
+	// QuantScale is how much coarser Requantize makes the quantization
+	// tables. Zero means 2.
+	QuantScale float64
+
+	// PNGChunks are the ancillary chunks StripPNGChunks keeps. If nil,
+	// pngchunk.DefaultKeep are kept.
+	PNGChunks []string
+
+	// Metadata is what metadata is stripped. The zero value strips private
+	// metadata, such as GPS locations and serial numbers.
+	Metadata metadata.Mode
+	// Thumbnail is what is done to EXIF thumbnails which are kept. The zero
+	// value regenerates them from the sanitized image.
+	Thumbnail metadata.ThumbnailMode
+
+	// MinPSNR and MinSSIM are the quality floor of Sanitize, below which
+	// it falls back to gentler policies. Zero means no floor.
+	MinPSNR float64
+	MinSSIM float64
+}
+
+// DefaultPolicy sanitizes the whole image.
+var DefaultPolicy = Policy{}
+
+// regions returns the rectangles of an image with the given bounds to
+// sanitize, clipped to the bounds.
+func (p Policy) regions(bounds image.Rectangle) []image.Rectangle {
+	if len(p.Regions) == 0 {
+		return []image.Rectangle{bounds}
+	}
+
+	regions := make([]image.Rectangle, 0, len(p.Regions))
+	for _, r := range p.Regions {
+		if r = r.Intersect(bounds); !r.Empty() {
+			regions = append(regions, r)
+		}
+	}
+	return regions
+}
@@ -0,0 +1,217 @@
+package sanitize
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// ssimWindow is the side of the windows SSIM is computed over, and
+// ssimStride the distance between them.
+const (
+	ssimWindow = 8
+	ssimStride = 4
+)
+
+// Quality measures how much sanitizing changed an image.
+type Quality struct {
+	// PSNR is the peak signal-to-noise ratio of the colour samples, in dB.
+	// It is +Inf if they are the same.
+	PSNR float64
+	// SSIM is the mean structural similarity of the luma of windows of
+	// ssimWindow pixels square, from -1 up to 1 for the same image.
+	SSIM float64
+	// MaxDelta is the largest difference in any 8-bit channel, alpha
+	// included.
+	MaxDelta int
+}
+
+func (q Quality) String() string {
+	return fmt.Sprintf("psnr=%.2fdB ssim=%.4f max-delta=%d", q.PSNR, q.SSIM, q.MaxDelta)
+}
+
+// Measure compares a sanitized image with the original. Both are compared as
+// 8-bit premultiplied RGBA, so that the colour of transparent pixels, which
+// nothing shows, does not count.
+func Measure(old, clean image.Image) (Quality, error) {
+	if old.Bounds().Size() != clean.Bounds().Size() {
+		return Quality{}, fmt.Errorf("Sanitized image is %v, not %v", clean.Bounds().Size(), old.Bounds().Size())
+	}
+	a, b := toRGBA(old), toRGBA(clean)
+	w, h := a.Rect.Dx(), a.Rect.Dy()
+
+	var q Quality
+	var sse float64
+	for y := 0; y < h; y++ {
+		ra := a.Pix[y*a.Stride : y*a.Stride+4*w]
+		rb := b.Pix[y*b.Stride : y*b.Stride+4*w]
+		for i := range ra {
+			d := int(ra[i]) - int(rb[i])
+			if d < 0 {
+				d = -d
+			}
+			if d > q.MaxDelta {
+				q.MaxDelta = d
+			}
+			if i%4 != 3 {
+				sse += float64(d * d)
+			}
+		}
+	}
+	q.PSNR = math.Inf(1)
+	if sse > 0 {
+		q.PSNR = 10 * math.Log10(255*255/(sse/float64(3*w*h)))
+	}
+
+	q.SSIM = ssim(a, b)
+	return q, nil
+}
+
+// toRGBA returns m as an *image.RGBA with its origin at 0, 0.
+func toRGBA(m image.Image) *image.RGBA {
+	if rgba, ok := m.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, m.Bounds().Dx(), m.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Rect, m, m.Bounds().Min, draw.Src)
+	return rgba
+}
+
+// ssim returns the mean SSIM (Wang et al. 2004) of the luma of the windows of
+// a and b, which are the same size. Images smaller than a window are one
+// window.
+func ssim(a, b *image.RGBA) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+	w, h := a.Rect.Dx(), a.Rect.Dy()
+	ww, wh := ssimWindow, ssimWindow
+	if w < ww {
+		ww = w
+	}
+	if h < wh {
+		wh = h
+	}
+	luma := func(m *image.RGBA, x, y int) float64 {
+		i := y*m.Stride + 4*x
+		return 0.299*float64(m.Pix[i]) + 0.587*float64(m.Pix[i+1]) + 0.114*float64(m.Pix[i+2])
+	}
+
+	var sum float64
+	windows := 0
+	for y0 := 0; y0+wh <= h; y0 += ssimStride {
+		for x0 := 0; x0+ww <= w; x0 += ssimStride {
+			var sa, sb, saa, sbb, sab float64
+			for y := y0; y < y0+wh; y++ {
+				for x := x0; x < x0+ww; x++ {
+					la, lb := luma(a, x, y), luma(b, x, y)
+					sa, sb = sa+la, sb+lb
+					saa, sbb, sab = saa+la*la, sbb+lb*lb, sab+la*lb
+				}
+			}
+			n := float64(ww * wh)
+			ma, mb := sa/n, sb/n
+			va, vb, cov := saa/n-ma*ma, sbb/n-mb*mb, sab/n-ma*mb
+			sum += (2*ma*mb + c1) * (2*cov + c2) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			windows++
+		}
+	}
+	if windows == 0 {
+		return 1
+	}
+	return sum / float64(windows)
+}
+
+// Fallback records a policy whose quality fell below the floor, and the
+// gentler one Sanitize tried instead.
+type Fallback struct {
+	// From is the quality of the policy fallen back from.
+	From Quality
+	// To describes the gentler policy, e.g. "depth 2".
+	To string
+}
+
+func (f Fallback) String() string {
+	return fmt.Sprintf("%s (%v is below the floor)", f.To, f.From)
+}
+
+// Result is the outcome of Sanitize.
+type Result struct {
+	Data []byte
+	// Quality compares the first frame or page of the sanitized image with
+	// the original.
+	Quality Quality
+	// Policy is the policy Data was sanitized with, which is gentler than
+	// the one asked for if there are Fallbacks.
+	Policy Policy
+	// Fallbacks are the gentler policies tried, in turn, because the
+	// quality of the one before fell below the floor.
+	Fallbacks []Fallback
+	// BelowFloor is set if even the gentlest policy fell below the floor.
+	// Data is sanitized with it all the same.
+	BelowFloor bool
+}
+
+// Sanitize is SanitizeBytes, and also measures the quality of the result. If
+// it falls below p.MinPSNR or p.MinSSIM, gentler policies are tried in turn,
+// see gentler, and recorded in the result, as they leave more of a payload
+// behind. If even the gentlest falls below, its result is returned with
+// BelowFloor set, as an image of poor quality is better than one which still
+// carries its payload.
+func (p Policy) Sanitize(data []byte) (*Result, error) {
+	old, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	for {
+		out, err := p.SanitizeBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		clean, _, err := image.Decode(bytes.NewReader(out))
+		if err != nil {
+			return nil, err
+		}
+		q, err := Measure(old, clean)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Data, result.Quality, result.Policy = out, q, p
+		if (p.MinPSNR == 0 || q.PSNR >= p.MinPSNR) && (p.MinSSIM == 0 || q.SSIM >= p.MinSSIM) {
+			return result, nil
+		}
+
+		next, description, ok := p.gentler(format)
+		if !ok {
+			result.BelowFloor = true
+			return result, nil
+		}
+		result.Fallbacks = append(result.Fallbacks, Fallback{From: q, To: description})
+		p = next
+	}
+}
+
+// gentler returns a policy which costs images of the given format less
+// quality than p, and a description of it: one bit plane fewer, or clearing
+// rather than requantizing the coefficients of JPEGs. ok is false if p is as
+// gentle as it gets.
+func (p Policy) gentler(format string) (gentler Policy, description string, ok bool) {
+	if format == "jpeg" {
+		if p.JPEG == Requantize {
+			p.JPEG = ClearLSB
+			return p, "jpeg " + p.JPEG.String(), true
+		}
+		return p, "", false
+	}
+	if d := p.depth(); d > 1 {
+		p.Depth = d - 1
+		return p, fmt.Sprintf("depth %d", p.Depth), true
+	}
+	return p, "", false
+}
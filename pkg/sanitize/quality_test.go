@@ -0,0 +1,132 @@
+package sanitize
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestMeasure(t *testing.T) {
+	old := noisyImage(64, 64)
+	q, err := Measure(old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsInf(q.PSNR, 1) || math.Abs(q.SSIM-1) > 1e-9 || q.MaxDelta != 0 {
+		t.Errorf("image against itself: %v, want psnr=+Inf ssim=1 max-delta=0", q)
+	}
+
+	for depth := 1; depth <= MaxDepth; depth++ {
+		clean, err := Policy{Depth: depth}.SanitizeImage(old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, err := Measure(old, clean)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := 1<<uint(depth) - 1; q.MaxDelta != want {
+			t.Errorf("depth %d: max delta %d, want %d", depth, q.MaxDelta, want)
+		}
+		if q.PSNR < 20 || q.SSIM > 1 {
+			t.Errorf("depth %d: %v", depth, q)
+		}
+	}
+}
+
+func TestSanitizeFallback(t *testing.T) {
+	data, err := os.ReadFile("../../testfiles/samplemedium.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// With no floor, the depth asked for is kept.
+	result, err := Policy{Depth: 4}.Sanitize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Fallbacks) != 0 || result.Policy.Depth != 4 || result.Quality.MaxDelta != 15 {
+		t.Errorf("no floor: depth %d, fell back to %v, %v", result.Policy.Depth, result.Fallbacks, result.Quality)
+	}
+
+	// Rewriting four or three planes costs more than 40dB, two does not.
+	result, err = Policy{Depth: 4, MinPSNR: 40}.Sanitize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Policy.Depth != 2 || result.Quality.PSNR < 40 {
+		t.Errorf("40dB floor: sanitized to depth %d, %v", result.Policy.Depth, result.Quality)
+	}
+	if len(result.Fallbacks) != 2 || result.Fallbacks[0].To != "depth 3" || result.Fallbacks[1].To != "depth 2" {
+		t.Fatalf("40dB floor: fell back to %v", result.Fallbacks)
+	}
+	for _, f := range result.Fallbacks {
+		if f.From.PSNR >= 40 {
+			t.Errorf("fell back to %s from %v, which is above the floor", f.To, f.From)
+		}
+	}
+}
+
+func TestSanitizeFallbackJPEG(t *testing.T) {
+	data, err := os.ReadFile("../../testfiles/samplegray.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requantized, err := Policy{JPEG: Requantize}.Sanitize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleared, err := Policy{JPEG: ClearLSB}.Sanitize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	floor := (requantized.Quality.PSNR + cleared.Quality.PSNR) / 2
+	result, err := Policy{JPEG: Requantize, MinPSNR: floor}.Sanitize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Policy.JPEG != ClearLSB || len(result.Fallbacks) != 1 || result.Fallbacks[0].To != "jpeg clear" {
+		t.Errorf("%.2fdB floor: sanitized with %s, fell back to %v", floor, result.Policy.JPEG, result.Fallbacks)
+	}
+}
+
+func TestSanitizeBelowFloor(t *testing.T) {
+	data, err := os.ReadFile("../../testfiles/samplemedium.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Not even the LSB alone can be rewritten without some loss, so the
+	// gentlest policy is used, and the breach recorded.
+	result, err := Policy{Depth: 4, MinSSIM: 1}.Sanitize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.BelowFloor || result.Policy.Depth != 1 || len(result.Fallbacks) != 3 || result.Quality.MaxDelta != 1 {
+		t.Errorf("impossible floor: depth %d, fell back to %v, %v, below floor %v",
+			result.Policy.Depth, result.Fallbacks, result.Quality, result.BelowFloor)
+	}
+
+	jpg, err := os.ReadFile("../../testfiles/samplegray.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = Policy{JPEG: Requantize, MinSSIM: 1}.Sanitize(jpg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.BelowFloor || result.Policy.JPEG != ClearLSB || bytes.Equal(result.Data, jpg) {
+		t.Errorf("impossible JPEG floor: sanitized with %s, below floor %v", result.Policy.JPEG, result.BelowFloor)
+	}
+
+	// An attainable floor is not breached.
+	result, err = Policy{Depth: 4, MinPSNR: 40}.Sanitize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.BelowFloor {
+		t.Errorf("40dB floor: breached with %v", result.Quality)
+	}
+}
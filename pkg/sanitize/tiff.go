@@ -0,0 +1,40 @@
+package sanitize
+
+import (
+	"bytes"
+
+	"github.com/standardrhyme/stegsecure/pkg/tiffpage"
+	"golang.org/x/image/tiff"
+)
+
+// SanitizeTIFF sanitizes every page of a TIFF, and writes them back as an
+// uncompressed TIFF with the same number of pages.
+func (p Policy) SanitizeTIFF(data []byte) ([]byte, error) {
+	pages, err := tiffpage.Split(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, page := range pages {
+		old, err := tiff.Decode(bytes.NewReader(page))
+		if err != nil {
+			return nil, err
+		}
+
+		clean, err := p.SanitizeImage(old)
+		if err != nil {
+			return nil, err
+		}
+
+		var out bytes.Buffer
+		if err := tiff.Encode(&out, clean, nil); err != nil {
+			return nil, err
+		}
+		pages[i] = out.Bytes()
+	}
+
+	if len(pages) == 1 {
+		return pages[0], nil
+	}
+	return tiffpage.Join(pages)
+}
@@ -0,0 +1,69 @@
+package sanitize
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/standardrhyme/stegsecure/pkg/tiffpage"
+	"golang.org/x/image/tiff"
+)
+
+// oddImage returns an image whose samples are all odd.
+func oddImage(w, h int) *image.NRGBA {
+	m := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for j := range m.Pix {
+		m.Pix[j] = uint8(2*j + 1)
+	}
+	for j := 3; j < len(m.Pix); j += 4 {
+		m.Pix[j] = 0xff
+	}
+	return m
+}
+
+func TestSanitizeTIFF(t *testing.T) {
+	var pages [][]byte
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		if err := tiff.Encode(&buf, oddImage(4+i, 3), nil); err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, buf.Bytes())
+	}
+	data, err := tiffpage.Join(pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := DefaultPolicy.SanitizeBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err = tiffpage.Split(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 {
+		t.Fatalf("got %d pages, want 2", len(pages))
+	}
+
+	for i, page := range pages {
+		m, err := tiff.Decode(bytes.NewReader(page))
+		if err != nil {
+			t.Fatalf("page %d: %v", i, err)
+		}
+		b := m.Bounds()
+		if b.Dx() != 4+i {
+			t.Errorf("page %d is %d pixels wide, want %d", i, b.Dx(), 4+i)
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+				if c.R%2 != 0 || c.G%2 != 0 || c.B%2 != 0 {
+					t.Fatalf("page %d: pixel (%d, %d) = %v not sanitized", i, x, y, c)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package sanitize
+
+import (
+	"bytes"
+	"image"
+
+	"github.com/standardrhyme/stegsecure/pkg/trailing"
+)
+
+// Truncate returns data without anything appended after the end of the
+// image, leaving the image itself untouched. SanitizeBytes drops such data
+// too, as it re-encodes the image, but Truncate can be used on files whose
+// pixels are clean.
+func Truncate(data []byte) ([]byte, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := trailing.End(data, format)
+	if err != nil {
+		return nil, err
+	}
+	return data[:end], nil
+}
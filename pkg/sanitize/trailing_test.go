@@ -0,0 +1,40 @@
+package sanitize
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/standardrhyme/stegsecure/pkg/trailing"
+)
+
+func TestTruncate(t *testing.T) {
+	payload := []byte("PK\x03\x04 appended archive")
+	for file, format := range map[string]string{
+		"../../testfiles/samplestego.png":       "png",
+		"../../testfiles/sampleprogressive.jpg": "jpeg",
+	} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stego := append(append([]byte(nil), data...), payload...)
+
+		truncated, err := Truncate(stego)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(truncated, data) {
+			t.Errorf("%s: Truncate left %d bytes, want the %d of the image", file, len(truncated), len(data))
+		}
+
+		// Sanitizing re-encodes the image, which drops the payload too.
+		cleaned, err := DefaultPolicy.SanitizeBytes(stego)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p, err := trailing.Scan(cleaned, format); err != nil || p != nil {
+			t.Errorf("%s: sanitized file has trailing data %v, %v", file, p, err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package steganalysis
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"sort"
+	"sync"
+	"time"
+
+	// Classic LSB tools write uncompressed BMPs and TIFFs, and browsers
+	// save WebPs.
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Analyzer is a steganalysis detector which can be run over a decoded image.
+type Analyzer interface {
+	// Name returns the name the detector is registered under.
+	Name() string
+	// Analyze runs the detector over a decoded image.
+	Analyze(im image.Image) (Verdict, error)
+}
+
+// ByteAnalyzer is implemented by detectors which want the encoded file rather
+// than (or as well as) the decoded pixels. format is the name reported by
+// image.Decode, e.g. "png" or "jpeg".
+type ByteAnalyzer interface {
+	Analyzer
+	AnalyzeBytes(data []byte, format string) (Verdict, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Analyzer)
+)
+
+// Register makes a detector available by name. It panics if the name is empty
+// or has already been registered.
+func Register(a Analyzer) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	name := a.Name()
+	if name == "" {
+		panic("steganalysis: Register called with an unnamed detector")
+	}
+	if _, ok := registry[name]; ok {
+		panic("steganalysis: Register called twice for detector " + name)
+	}
+
+	registry[name] = a
+}
+
+// Lookup returns the detector registered under name.
+func Lookup(name string) (Analyzer, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	a, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown detector: %s", name)
+	}
+	return a, nil
+}
+
+// LookupAll resolves a list of detector names, failing on the first unknown one.
+func LookupAll(names []string) ([]Analyzer, error) {
+	analyzers := make([]Analyzer, 0, len(names))
+	for _, name := range names {
+		a, err := Lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		analyzers = append(analyzers, a)
+	}
+	return analyzers, nil
+}
+
+// Names returns the sorted names of all registered detectors.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RunBytes runs a detector over an encoded image. Detectors implementing
+// ByteAnalyzer are handed the raw bytes, all others the decoded image. Either
+// way, data which is no image is an error.
+func RunBytes(a Analyzer, data []byte) (Verdict, error) {
+	if ba, ok := a.(ByteAnalyzer); ok {
+		config, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return Verdict{Detector: a.Name()}, err
+		}
+		verdict, err := runDetector(ba, nil, data, format)
+		if verdict.Width == 0 && verdict.Height == 0 {
+			verdict.Width, verdict.Height = config.Width, config.Height
+		}
+		return verdict, err
+	}
+
+	im, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Verdict{Detector: a.Name()}, err
+	}
+	return runDetector(a, im, data, format)
+}
+
+// runDetector runs a single detector, preferring the raw bytes (if there are
+// any) for a ByteAnalyzer, and fills in the Verdict fields common to every detector.
+func runDetector(a Analyzer, im image.Image, data []byte, format string) (Verdict, error) {
+	start := time.Now()
+
+	var verdict Verdict
+	var err error
+	if ba, ok := a.(ByteAnalyzer); ok && data != nil {
+		verdict, err = ba.AnalyzeBytes(data, format)
+	} else {
+		verdict, err = a.Analyze(im)
+	}
+	if err != nil {
+		return verdict, err
+	}
+
+	if verdict.Detector == "" {
+		verdict.Detector = a.Name()
+	}
+	if verdict.Format == "" {
+		verdict.Format = format
+	}
+	if verdict.Width == 0 && verdict.Height == 0 && im != nil {
+		verdict.Width = im.Bounds().Dx()
+		verdict.Height = im.Bounds().Dy()
+	}
+	verdict.Elapsed = time.Since(start)
+
+	return verdict, nil
+}
@@ -0,0 +1,84 @@
+package steganalysis
+
+import (
+	"image"
+	"sort"
+	"testing"
+)
+
+// namedAnalyzer is a stub detector registered under an arbitrary name.
+type namedAnalyzer string
+
+func (n namedAnalyzer) Name() string { return string(n) }
+
+func (n namedAnalyzer) Analyze(im image.Image) (Verdict, error) {
+	return Verdict{}, nil
+}
+
+func TestLookup(t *testing.T) {
+	a, err := Lookup("samplepairs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Name() != "samplepairs" {
+		t.Errorf("Lookup(samplepairs) = %s", a.Name())
+	}
+
+	if a, err := Lookup("nosuchdetector"); err == nil {
+		t.Errorf("Lookup(nosuchdetector) = %v, want an error", a)
+	}
+	if a, err := Lookup(""); err == nil {
+		t.Errorf("Lookup(\"\") = %v, want an error", a)
+	}
+}
+
+func TestLookupAll(t *testing.T) {
+	analyzers, err := LookupAll([]string{"samplepairs", "samplepairs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(analyzers) != 2 || analyzers[0].Name() != "samplepairs" || analyzers[1].Name() != "samplepairs" {
+		t.Errorf("LookupAll(samplepairs, samplepairs) = %v", analyzers)
+	}
+
+	if analyzers, err := LookupAll([]string{"samplepairs", "nosuchdetector"}); err == nil {
+		t.Errorf("LookupAll with an unknown name = %v, want an error", analyzers)
+	}
+}
+
+func TestNames(t *testing.T) {
+	names := Names()
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("Names() = %v, not sorted", names)
+	}
+	if _, err := LookupAll(names); err != nil {
+		t.Errorf("Names() = %v: %v", names, err)
+	}
+	if i := sort.SearchStrings(names, "samplepairs"); i == len(names) || names[i] != "samplepairs" {
+		t.Errorf("Names() = %v, want samplepairs among them", names)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register(namedAnalyzer("test"))
+	defer func() {
+		registryMu.Lock()
+		delete(registry, "test")
+		registryMu.Unlock()
+	}()
+
+	if _, err := Lookup("test"); err != nil {
+		t.Error(err)
+	}
+
+	for _, name := range []string{"test", "samplepairs", ""} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register(%q) did not panic", name)
+				}
+			}()
+			Register(namedAnalyzer(name))
+		}()
+	}
+}
@@ -0,0 +1,102 @@
+package steganalysis
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// benchmarkImages runs fn as a sub-benchmark over each testfiles/sample*.png.
+func benchmarkImages(b *testing.B, fn func(b *testing.B, name string)) {
+	benchmarkFiles(b, "sample*.png", fn)
+}
+
+// benchmarkFiles runs fn as a sub-benchmark over each file in testfiles
+// matching pattern.
+func benchmarkFiles(b *testing.B, pattern string, fn func(b *testing.B, name string)) {
+	files, err := filepath.Glob("../../testfiles/" + pattern)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, file := range files {
+		name := filepath.Base(file)
+		b.Run(name, func(b *testing.B) {
+			fn(b, name)
+		})
+	}
+}
+
+func BenchmarkExtractPlanes(b *testing.B) {
+	benchmarkImages(b, func(b *testing.B, name string) {
+		im := decodeTestFile(b, name)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			extractPlanes(im)
+		}
+	})
+}
+
+func BenchmarkDetectors(b *testing.B) {
+	for _, detector := range Names() {
+		a, err := Lookup(detector)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, ok := a.(ByteAnalyzer); ok {
+			// Benchmarked by BenchmarkJPEGDetectors
+			continue
+		}
+
+		b.Run(detector, func(b *testing.B) {
+			benchmarkImages(b, func(b *testing.B, name string) {
+				im := decodeTestFile(b, name)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := a.Analyze(im); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkJPEGDetectors(b *testing.B) {
+	for _, detector := range DefaultJPEGDetectors {
+		a, err := Lookup(detector)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(detector, func(b *testing.B) {
+			benchmarkFiles(b, "sample*.jpg", func(b *testing.B, name string) {
+				data, err := os.ReadFile("../../testfiles/" + name)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := RunBytes(a, data); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkSamplePairsWorkers(b *testing.B) {
+	for _, workers := range []int{1, 0} {
+		s := SamplePairs{Threshold: 0.5, Workers: workers}
+
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			im := decodeTestFile(b, "samplemedium.png")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.Analyze(im)
+			}
+		})
+	}
+}
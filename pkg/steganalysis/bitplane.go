@@ -0,0 +1,130 @@
+package steganalysis
+
+import (
+	"image"
+	"math"
+
+	"github.com/standardrhyme/stegsecure/pkg/sanitize"
+)
+
+// randomZ is the z-score below which the agreement of a bit plane counts as
+// that of random bits.
+const randomZ = 3
+
+// minBitPlanePairs is the number of pairs of samples below which a bit plane
+// is too small to tell from random.
+const minBitPlanePairs = 10000
+
+// diffMask keeps the bit planes BitPlaneDepth examines.
+const diffMask = 1<<(sanitize.MaxDepth+1) - 1
+
+// BitPlaneDepth returns how many of the low bit planes of im, up to
+// sanitize.MaxDepth, look random, and so the depth im should be sanitized to,
+// and the agreement of each plane examined: the fraction of pairs of
+// neighbouring samples whose bits in the plane are equal. The counting is
+// split across at most workers goroutines, or one per CPU if workers is 0.
+//
+// In natural images, each bit plane is more coherent than the one below it:
+// the agreement rises from about 0.5 in the noisy LSB towards 1 in the high
+// planes. An LSB-k embedder replaces the low k planes with a payload which,
+// being compressed or encrypted, agrees exactly half the time, leaving a
+// cliff between plane k-1 and a coherent plane k. A plane counts as random if
+// its agreement is within randomZ standard deviations of 0.5, which for any
+// image of some size rules out the slight coherence of sensor noise. Images
+// which look random up to plane sanitize.MaxDepth as well, such as noise, have
+// no cliff to find, and a depth of 0, as do images too small to tell.
+func BitPlaneDepth(im image.Image, workers int) (int, []float64) {
+	// Alpha is left out: mostly opaque, it would look coherent whatever
+	// the colour planes hold.
+	planes := extractColourPlanes(im)
+	width, height := planes[0].width, planes[0].height
+
+	pairs := len(planes) * ((width-1)*height + width*(height-1))
+	if pairs < minBitPlanePairs {
+		return 0, nil
+	}
+
+	// Each band counts the pairs within its rows and those between its last
+	// row and the next, by the low planes of the XOR of the pair, from which
+	// the agreement of each plane follows.
+	bands := workBands(height, 1, workers)
+	bandDiffs := make([][1 << (sanitize.MaxDepth + 1)]int, len(bands))
+	runBands(bands, workers, func(i int, b band) {
+		diffs := &bandDiffs[i]
+		for _, p := range planes {
+			for y := b.y0; y < b.y1; y++ {
+				row := p.row(y)
+				// For 16-bit images, the planes an embedder would
+				// write to are those of the native samples.
+				for x := 0; x+1 < len(row); x++ {
+					diffs[(row[x]^row[x+1])&diffMask]++
+				}
+				if y+1 < height {
+					next := p.row(y + 1)
+					for x := range row {
+						diffs[(row[x]^next[x])&diffMask]++
+					}
+				}
+			}
+		}
+	})
+
+	agreement := make([]float64, sanitize.MaxDepth+1)
+	depth := 0
+	for k := range agreement {
+		same := 0
+		for _, diffs := range bandDiffs {
+			for diff, n := range diffs {
+				if diff&(1<<uint(k)) == 0 {
+					same += n
+				}
+			}
+		}
+		agreement[k] = float64(same) / float64(pairs)
+		z := math.Abs(agreement[k]-0.5) / (0.5 / math.Sqrt(float64(pairs)))
+		if depth == k && z < randomZ {
+			depth++
+		}
+	}
+	if depth > sanitize.MaxDepth {
+		depth = 0
+	}
+	return depth, agreement
+}
+
+// BitPlanes flags images whose low bit planes look random, as those of
+// images embedded by LSB-2, LSB-3 and LSB-4 embedders do; see BitPlaneDepth.
+// The probability is the number of random planes over sanitize.MaxDepth, so
+// the default threshold of 0.25 flags two planes or more, leaving images with
+// just a random LSB, which many natural images have, to the LSB detectors.
+// Channels holds the agreement of each plane.
+type BitPlanes struct {
+	Threshold float64
+	// Workers is the number of goroutines the counting is split across.
+	// Zero means one per CPU.
+	Workers int
+}
+
+func (BitPlanes) Name() string { return "bitplanes" }
+
+func (b BitPlanes) WithWorkers(workers int) Analyzer {
+	b.Workers = workers
+	return b
+}
+
+// Analyze runs bit-plane analysis over im.
+func (b BitPlanes) Analyze(im image.Image) (Verdict, error) {
+	depth, agreement := BitPlaneDepth(im, b.Workers)
+	probability := float64(depth) / sanitize.MaxDepth
+	return Verdict{
+		Detector:    b.Name(),
+		Stego:       probability > b.Threshold,
+		Probability: probability,
+		Threshold:   b.Threshold,
+		Channels:    agreement,
+	}, nil
+}
+
+func init() {
+	Register(BitPlanes{Threshold: 0.25})
+}
@@ -0,0 +1,58 @@
+package steganalysis
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/standardrhyme/stegsecure/pkg/sanitize"
+)
+
+func TestBitPlaneDepth(t *testing.T) {
+	for _, name := range []string{"samplesmaller.png", "samplemedium.png"} {
+		data, err := os.ReadFile("../../testfiles/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		im, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for depth := 0; depth <= sanitize.MaxDepth; depth++ {
+			m := embedLSB(im, 1, image.Rectangle{}, depth)
+			verdict, err := BitPlanes{Threshold: 0.25}.Analyze(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := BitPlaneDepth(m, 0); got != depth {
+				t.Errorf("%s at depth %d: got depth %d, agreement %v", name, depth, got, verdict.Channels)
+			}
+			if verdict.Stego != (depth > 1) {
+				t.Errorf("%s at depth %d: got %v", name, depth, verdict)
+			}
+
+			// Clearing the planes leaves none random.
+			clean, err := sanitize.Policy{Depth: sanitize.MaxDepth}.SanitizeImage(m.SubImage(image.Rect(0, 0, 200, 150)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := BitPlaneDepth(clean, 0); got != 0 {
+				t.Errorf("%s at depth %d: got depth %d after sanitizing", name, depth, got)
+			}
+		}
+	}
+}
+
+func TestBitPlaneDepthWorkers(t *testing.T) {
+	m := embedLSB(decodeTestFile(t, "samplesmaller.png"), 1, image.Rectangle{}, 2)
+	depth, agreement := BitPlaneDepth(m, 1)
+	for _, workers := range []int{2, 7, 0} {
+		d, a := BitPlaneDepth(m, workers)
+		if d != depth || !reflect.DeepEqual(a, agreement) {
+			t.Errorf("%d workers: depth %d, agreement %v, want %d, %v", workers, d, a, depth, agreement)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package steganalysis
+
+import (
+	"image"
+	"math"
+)
+
+// ScanOrder is the order in which a sequential embedder visits samples.
+type ScanOrder int
+
+const (
+	// ColumnMajor visits each column top to bottom, from the left, with the
+	// colour channels of a pixel in turn. This is the order used by
+	// python-scripts/stego.py.
+	ColumnMajor ScanOrder = iota
+	// RowMajor visits each row left to right, from the top.
+	RowMajor
+)
+
+// ChiSquare is the Westfeld-Pfitzmann chi-square attack on pairs of values
+// (Westfeld and Pfitzmann 1999). It targets sequential LSB embedding, testing
+// ever larger prefixes of the image in scan order.
+type ChiSquare struct {
+	Order ScanOrder
+	// Steps is the number of positions along the image the probability is
+	// sampled at.
+	Steps     int
+	Threshold float64
+}
+
+// minChiSquareCategories is the number of pairs of values, each with enough
+// samples to count, below which a prefix is too small to test.
+const minChiSquareCategories = 16
+
+// chiSquareControl is the probability below which the control test, over
+// the pairs (2k+1, 2k+2), shows the histogram of a prefix to be uneven.
+// Until it does, the histogram could be as even as embedding leaves it by
+// nature, as it is in noisy or smooth regions and in small images, and the
+// attack cannot tell.
+const chiSquareControl = 1e-6
+
+// ChiSquareScan is the embedding probability as a function of position along
+// the image.
+type ChiSquareScan struct {
+	// Probabilities[i] is the probability that the samples up to
+	// Positions[i] (exclusive, in scan order) carry embedded data.
+	Probabilities []float64
+	Positions     []int
+	// Decided is the index of the first position whose prefix holds enough
+	// data to tell, see chiSquareControl, or -1 if none does.
+	Decided int
+	// Samples is the total number of colour samples in the image.
+	Samples int
+	// PayloadEnd is the estimated byte offset at which the payload ends, one
+	// bit per sample, or 0 if none was found.
+	PayloadEnd int
+}
+
+// verdict returns the verdict of the scan: the probability of the prefix at
+// Decided, which is high when a payload starts at the beginning of the scan
+// order, or an inconclusive verdict if there is no such prefix.
+func (s ChiSquareScan) verdict(name string, threshold float64) Verdict {
+	if s.Decided < 0 {
+		return Verdict{Detector: name, Threshold: threshold, Inconclusive: true}
+	}
+
+	probability := s.Probabilities[s.Decided]
+	return Verdict{
+		Detector:     name,
+		Stego:        probability > threshold,
+		Probability:  probability,
+		Threshold:    threshold,
+		PayloadBytes: s.PayloadEnd,
+	}
+}
+
+func (ChiSquare) Name() string { return "chisquare" }
+
+// Analyze runs the chi-square attack over im. The reported probability is that
+// of the shortest prefix which holds enough data to tell, so it is high when a
+// payload starts at the beginning of the scan order. Images without such a
+// prefix get an inconclusive verdict.
+func (c ChiSquare) Analyze(im image.Image) (Verdict, error) {
+	return c.Scan(im).verdict(c.Name(), c.Threshold), nil
+}
+
+// Scan computes the cumulative chi-square probability at evenly spaced
+// positions along im.
+func (c ChiSquare) Scan(im image.Image) ChiSquareScan {
+	samples, levels := scanSamples(im, c.Order)
+	return c.scan(samples, levels)
+}
+
+// scan computes the cumulative chi-square probability at evenly spaced
+// positions along samples, whose values are below levels.
+func (c ChiSquare) scan(samples []uint16, levels int) ChiSquareScan {
+	steps := c.Steps
+	if steps <= 0 {
+		steps = 100
+	}
+	step := len(samples) / steps
+	if step < 1 {
+		step = 1
+	}
+
+	scan := ChiSquareScan{Samples: len(samples), Decided: -1}
+
+	// The cumulative probabilities lag behind the end of the payload, as each
+	// prefix still contains it, so the end is located with the probability of
+	// each step on its own.
+	hist := make([]float64, levels)
+	window := make([]float64, levels)
+	inPayload := true
+	end := 0
+	for i, v := range samples {
+		hist[v]++
+		window[v]++
+
+		if (i+1)%step != 0 && i+1 != len(samples) {
+			continue
+		}
+
+		p, categories := chiSquare(hist, 0)
+		scan.Probabilities = append(scan.Probabilities, p)
+		scan.Positions = append(scan.Positions, i+1)
+		if scan.Decided < 0 && categories >= minChiSquareCategories {
+			if control, _ := chiSquare(hist, 1); control < chiSquareControl {
+				scan.Decided = len(scan.Probabilities) - 1
+			}
+		}
+
+		if p, _ := chiSquare(window, 0); inPayload && p > c.Threshold {
+			end = i + 1
+		} else {
+			inPayload = false
+		}
+		for v := range window {
+			window[v] = 0
+		}
+	}
+
+	// Only report a payload if the image starts out embedded.
+	if scan.Decided >= 0 && scan.Probabilities[scan.Decided] > c.Threshold {
+		scan.PayloadEnd = end / 8
+	}
+
+	return scan
+}
+
+// scanSamples returns the native colour samples of im in the given scan order,
+// along with the number of distinct sample values.
+func scanSamples(im image.Image, order ScanOrder) ([]uint16, int) {
+	planes := extractColourPlanes(im)
+
+	width := planes[0].width
+	height := planes[0].height
+	samples := make([]uint16, 0, width*height*len(planes))
+
+	if order == RowMajor {
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				for _, p := range planes {
+					samples = append(samples, p.pix[y*width+x])
+				}
+			}
+		}
+		return samples, planes[0].levels()
+	}
+
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			for _, p := range planes {
+				samples = append(samples, p.pix[y*width+x])
+			}
+		}
+	}
+	return samples, planes[0].levels()
+}
+
+// chiSquare returns the probability that the histogram's pairs of values
+// (2k+offset, 2k+offset+1) have been equalized by LSB embedding, and the
+// number of pairs it was computed over. With an offset of 1, the pairs
+// straddle those LSB embedding equalizes, which serves as a control.
+func chiSquare(hist []float64, offset int) (float64, int) {
+	chi := float64(0)
+	categories := 0
+
+	for k := offset; k+1 < len(hist); k += 2 {
+		expected := (hist[k] + hist[k+1]) / 2
+		// Skip sparse categories, which make the statistic unreliable.
+		if expected < 5 {
+			continue
+		}
+
+		d := hist[k] - expected
+		chi += (d * d) / expected
+		categories++
+	}
+
+	if categories < 2 {
+		return 0, categories
+	}
+
+	return 1 - regularizedGammaP(float64(categories-1)/2, chi/2), categories
+}
+
+// regularizedGammaP returns the regularized lower incomplete gamma function
+// P(a, x), the CDF of a chi-square distribution with 2a degrees of freedom at
+// 2x. Based off of Numerical Recipes' gammp.
+func regularizedGammaP(a, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+
+	lgamma, _ := math.Lgamma(a)
+
+	if x < a+1 {
+		// Series representation.
+		sum := 1 / a
+		term := sum
+		for n := 1; n < 1000; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*1e-15 {
+				break
+			}
+		}
+		return sum * math.Exp(-x+a*math.Log(x)-lgamma)
+	}
+
+	// Continued fraction representation, by the modified Lentz method.
+	const tiny = 1e-300
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for n := 1; n < 1000; n++ {
+		an := -float64(n) * (float64(n) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < 1e-15 {
+			break
+		}
+	}
+	return 1 - h*math.Exp(-x+a*math.Log(x)-lgamma)
+}
+
+func init() {
+	Register(ChiSquare{Order: ColumnMajor, Steps: 100, Threshold: 0.5})
+}
@@ -0,0 +1,85 @@
+package steganalysis
+
+import (
+	"image"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+// embedSequential returns a copy of im with the LSBs of the first bits colour
+// samples, in column-major order, replaced with random bits, as
+// python-scripts/stego.py embeds a payload.
+func embedSequential(im image.Image, bits int) *image.NRGBA {
+	rng := rand.New(rand.NewSource(1))
+	m := image.NewNRGBA(im.Bounds())
+	draw.Draw(m, m.Bounds(), im, im.Bounds().Min, draw.Src)
+	h := m.Rect.Dy()
+	for s := 0; s < bits; s++ {
+		x, y, c := s/3/h, s/3%h, s%3
+		i := y*m.Stride + 4*x + c
+		m.Pix[i] = m.Pix[i]&^1 | uint8(rng.Intn(2))
+	}
+	return m
+}
+
+func TestChiSquareTestFiles(t *testing.T) {
+	c := ChiSquare{Order: ColumnMajor, Steps: 100, Threshold: 0.5}
+
+	// The cover is large enough to decide on, and clean.
+	v, err := c.Analyze(decodeTestFile(t, "samplesmaller.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Inconclusive || v.Stego || v.PayloadBytes != 0 {
+		t.Errorf("samplesmaller.png: %v, want a clean verdict", v)
+	}
+
+	// stego.py's 23 bytes in a 30x19 image are far too few samples for the
+	// attack to tell, which it has to say rather than guess.
+	v, err = c.Analyze(decodeTestFile(t, "samplestego.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Inconclusive || v.Stego || v.Probability != 0 {
+		t.Errorf("samplestego.png: %v, want an inconclusive verdict", v)
+	}
+}
+
+func TestChiSquareEmbedded(t *testing.T) {
+	c := ChiSquare{Order: ColumnMajor, Steps: 100, Threshold: 0.5}
+	cover := decodeTestFile(t, "samplesmaller.png")
+	samples := cover.Bounds().Dx() * cover.Bounds().Dy() * 3
+	step := samples / c.Steps
+
+	for _, rate := range []float64{0, 0.5, 1} {
+		bits := int(rate * float64(samples))
+		v, err := c.Analyze(embedSequential(cover, bits))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Inconclusive || v.Stego != (rate > 0) {
+			t.Errorf("rate %.1f: %v", rate, v)
+			continue
+		}
+		// The end of the payload is located to within a step.
+		if d := v.PayloadBytes - bits/8; d < -step/8 || d > step/8 {
+			t.Errorf("rate %.1f: payload of %d bytes, want about %d", rate, v.PayloadBytes, bits/8)
+		}
+	}
+}
+
+func TestChiSquareNoise(t *testing.T) {
+	// Noise has a histogram as even as embedding leaves it, so that the
+	// attack cannot tell whether it is embedded.
+	noise := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	rand.New(rand.NewSource(1)).Read(noise.Pix)
+
+	v, err := ChiSquare{Order: ColumnMajor, Steps: 100, Threshold: 0.5}.Analyze(noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Inconclusive || v.Stego {
+		t.Errorf("noise: %v, want an inconclusive verdict", v)
+	}
+}
@@ -0,0 +1,256 @@
+package steganalysis
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"github.com/standardrhyme/stegsecure/pkg/tiffpage"
+)
+
+// FusionRule decides how an Ensemble combines the verdicts of its detectors.
+type FusionRule int
+
+const (
+	// MajorityVote flags an image when more than half the detectors do.
+	MajorityVote FusionRule = iota
+	// MaxProbability flags an image when the highest probability exceeds the
+	// ensemble threshold.
+	MaxProbability
+	// WeightedAverage flags an image when the weighted mean probability
+	// exceeds the ensemble threshold.
+	WeightedAverage
+	// AllAgree flags an image only when every detector does.
+	AllAgree
+)
+
+var fusionRuleNames = map[FusionRule]string{
+	MajorityVote:    "majority",
+	MaxProbability:  "max",
+	WeightedAverage: "weighted",
+	AllAgree:        "all",
+}
+
+func (r FusionRule) String() string {
+	if name, ok := fusionRuleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("FusionRule(%d)", int(r))
+}
+
+// ParseFusionRule returns the rule with the given name: "majority", "max",
+// "weighted" or "all".
+func ParseFusionRule(name string) (FusionRule, error) {
+	for rule, n := range fusionRuleNames {
+		if n == name {
+			return rule, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown fusion rule: %s", name)
+}
+
+// Ensemble runs several detectors over an image and fuses their verdicts.
+type Ensemble struct {
+	Detectors []Analyzer
+	Rule      FusionRule
+	// Weights are per-detector weights for WeightedAverage. Detectors without
+	// a weight count as 1.
+	Weights map[string]float64
+	// Threshold is the probability MaxProbability and WeightedAverage compare
+	// against.
+	Threshold float64
+}
+
+// Fused is the combined result of an Ensemble.
+type Fused struct {
+	Verdict
+
+	Rule FusionRule
+	// Fired are the names of the detectors which flagged the image.
+	Fired []string
+	// Verdicts are the individual detectors' verdicts.
+	Verdicts []Verdict
+
+	// Page is the page of a multi-page TIFF the result is for, counting
+	// from 0, out of Pages. Pages is 0 for other images.
+	Page  int
+	Pages int
+}
+
+func (f Fused) String() string {
+	s := fmt.Sprintf("%s rule=%s fired=[%s]", f.Verdict, f.Rule, strings.Join(f.Fired, " "))
+	if f.Pages > 1 {
+		s += fmt.Sprintf(" page=%d/%d", f.Page+1, f.Pages)
+	}
+	return s
+}
+
+func (Ensemble) Name() string { return "ensemble" }
+
+// Analyze runs every detector over im and returns the fused verdict.
+func (e Ensemble) Analyze(im image.Image) (Verdict, error) {
+	verdicts := make([]Verdict, 0, len(e.Detectors))
+	for _, a := range e.Detectors {
+		verdict, err := runDetector(a, im, nil, "")
+		if err != nil {
+			return Verdict{}, err
+		}
+		verdicts = append(verdicts, verdict)
+	}
+
+	return e.Fuse(verdicts).Verdict, nil
+}
+
+// AnalyzeBytes decodes data and runs every detector over it, handing the raw
+// bytes to those which want them.
+func (e Ensemble) AnalyzeBytes(data []byte, format string) (Verdict, error) {
+	fused, err := e.Run(data)
+	return fused.Verdict, err
+}
+
+// Run decodes data, runs every detector over it and fuses their verdicts.
+// Multi-page TIFFs are run page by page, and the result is that of the first
+// page flagged, or if none is, of the page with the highest probability. Pages
+// which fail are skipped, and the first error is returned with the result.
+func (e Ensemble) Run(data []byte) (Fused, error) {
+	return e.run(data, nil, "")
+}
+
+// run is Run, with im, if not nil, the first page of data already decoded
+// from the given format, so that it is not decoded again. Only TIFFs are split
+// into pages, and if format is empty, it is read from data.
+func (e Ensemble) run(data []byte, im image.Image, format string) (Fused, error) {
+	if format == "" {
+		_, format, _ = image.DecodeConfig(bytes.NewReader(data))
+	}
+
+	pages := [][]byte{data}
+	if format == "tiff" {
+		if split, err := tiffpage.Split(data); err == nil && len(split) > 1 {
+			pages = split
+		}
+	}
+
+	// Pages which cannot be analyzed are skipped, so that one corrupt page
+	// cannot hide the others, and the first of their errors is returned
+	// along with the result of the rest.
+	result := e.Fuse(nil)
+	analyzed := false
+	var failed error
+	for i, page := range pages {
+		var verdicts []Verdict
+		var err error
+		if i == 0 && im != nil {
+			verdicts = analyzeImage(im, page, format, e.Detectors)
+		} else {
+			verdicts, err = analyzeBytes(page, e.Detectors)
+		}
+		if err != nil {
+			if len(pages) > 1 {
+				err = fmt.Errorf("TIFF page %d: %v", i, err)
+			}
+			if failed == nil {
+				failed = err
+			}
+			continue
+		}
+
+		fused := e.Fuse(verdicts)
+		if len(pages) > 1 {
+			fused.Page, fused.Pages = i, len(pages)
+		}
+		if !analyzed || fused.Stego || fused.Probability > result.Probability {
+			result = fused
+		}
+		analyzed = true
+		if result.Stego {
+			break
+		}
+	}
+	return result, failed
+}
+
+// Fuse combines verdicts according to the ensemble's rule. Inconclusive
+// verdicts are left out, and if every verdict is inconclusive, so is the
+// result.
+func (e Ensemble) Fuse(verdicts []Verdict) Fused {
+	fused := Fused{
+		Verdict: Verdict{
+			Detector:  e.Name(),
+			Threshold: e.Threshold,
+		},
+		Rule:     e.Rule,
+		Fired:    make([]string, 0),
+		Verdicts: verdicts,
+	}
+
+	if len(verdicts) == 0 {
+		return fused
+	}
+
+	fused.Format = verdicts[0].Format
+	fused.Width = verdicts[0].Width
+	fused.Height = verdicts[0].Height
+
+	decided := make([]Verdict, 0, len(verdicts))
+	for _, v := range verdicts {
+		if v.Stego {
+			fused.Fired = append(fused.Fired, v.Detector)
+		}
+		fused.Elapsed += v.Elapsed
+		fused.PayloadBytes = maxInt(fused.PayloadBytes, v.PayloadBytes)
+		if !v.Inconclusive {
+			decided = append(decided, v)
+		}
+	}
+	if len(decided) == 0 {
+		fused.Inconclusive = true
+		return fused
+	}
+	verdicts = decided
+
+	switch e.Rule {
+	case MajorityVote:
+		fused.Probability = float64(len(fused.Fired)) / float64(len(verdicts))
+		fused.Stego = 2*len(fused.Fired) > len(verdicts)
+
+	case MaxProbability:
+		for _, v := range verdicts {
+			fused.Probability = math.Max(fused.Probability, v.Probability)
+		}
+		fused.Stego = fused.Probability > e.Threshold
+
+	case WeightedAverage:
+		sum, total := float64(0), float64(0)
+		for _, v := range verdicts {
+			w, ok := e.Weights[v.Detector]
+			if !ok {
+				w = 1
+			}
+			sum += w * v.Probability
+			total += w
+		}
+		if total > 0 {
+			fused.Probability = sum / total
+		}
+		fused.Stego = fused.Probability > e.Threshold
+
+	case AllAgree:
+		fused.Probability = 1
+		for _, v := range verdicts {
+			fused.Probability = math.Min(fused.Probability, v.Probability)
+		}
+		fused.Stego = len(fused.Fired) == len(verdicts)
+	}
+
+	return fused
+}
+
+func maxInt(x, y int) int {
+	if x > y {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,141 @@
+package steganalysis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/standardrhyme/stegsecure/pkg/tiffpage"
+	"golang.org/x/image/tiff"
+)
+
+func TestRunTIFFPages(t *testing.T) {
+	cover := decodeTestFile(t, "samplesmaller.png")
+	stego := image.NewNRGBA(cover.Bounds())
+	draw.Draw(stego, stego.Bounds(), cover, cover.Bounds().Min, draw.Src)
+	rng := rand.New(rand.NewSource(1))
+	for j := range stego.Pix {
+		// Embed in half the samples, which Sample Pairs estimates best.
+		if j%4 != 3 && rng.Intn(2) == 0 {
+			stego.Pix[j] = stego.Pix[j]&^1 | uint8(rng.Intn(2))
+		}
+	}
+
+	var pages [][]byte
+	for _, m := range []image.Image{cover, stego, cover} {
+		var buf bytes.Buffer
+		if err := tiff.Encode(&buf, m, nil); err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, buf.Bytes())
+	}
+	data, err := tiffpage.Join(pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := Ensemble{Detectors: []Analyzer{SamplePairs{Threshold: 0.5}}, Rule: MajorityVote}
+	fused, err := e.Run(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fused.Stego || fused.Page != 1 || fused.Pages != 3 || fused.Format != "tiff" {
+		t.Errorf("got %v, want the second of three pages flagged", fused)
+	}
+
+	// A page which fails to decode does not hide the pages after it.
+	data, err = tiffpage.Join([][]byte{withCompression(pages[0], 99), pages[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fused, err = e.Run(data)
+	if err == nil {
+		t.Error("corrupt page: no error")
+	}
+	if !fused.Stego || fused.Page != 1 || fused.Pages != 2 {
+		t.Errorf("corrupt page: got %v, want the second of two pages flagged", fused)
+	}
+}
+
+// withCompression returns a copy of a little-endian single-page TIFF with its
+// Compression tag set to c.
+func withCompression(page []byte, c uint16) []byte {
+	page = append([]byte(nil), page...)
+	ifd := int(binary.LittleEndian.Uint32(page[4:]))
+	for e := 0; e < int(binary.LittleEndian.Uint16(page[ifd:])); e++ {
+		entry := page[ifd+2+12*e:]
+		if binary.LittleEndian.Uint16(entry) == 259 {
+			binary.LittleEndian.PutUint16(entry[8:], c)
+		}
+	}
+	return page
+}
+
+func TestFuse(t *testing.T) {
+	verdicts := []Verdict{
+		{Detector: "a", Stego: true, Probability: 0.9, PayloadBytes: 10},
+		{Detector: "b", Stego: false, Probability: 0.3},
+		{Detector: "c", Stego: true, Probability: 0.6, PayloadBytes: 40},
+	}
+	tie := verdicts[:2]
+	// An inconclusive detector neither votes nor counts towards the total.
+	inconclusive := append([]Verdict{{Detector: "d", Inconclusive: true}}, tie...)
+
+	for _, test := range []struct {
+		name        string
+		e           Ensemble
+		verdicts    []Verdict
+		stego       bool
+		probability float64
+	}{
+		{"majority", Ensemble{Rule: MajorityVote}, verdicts, true, 2.0 / 3},
+		{"majority tie", Ensemble{Rule: MajorityVote}, tie, false, 0.5},
+		{"majority inconclusive", Ensemble{Rule: MajorityVote}, inconclusive, false, 0.5},
+		{"max", Ensemble{Rule: MaxProbability, Threshold: 0.5}, verdicts, true, 0.9},
+		{"max at threshold", Ensemble{Rule: MaxProbability, Threshold: 0.9}, verdicts, false, 0.9},
+		{"weighted", Ensemble{Rule: WeightedAverage, Threshold: 0.5}, verdicts, true, 0.6},
+		{"weighted missing weights", Ensemble{Rule: WeightedAverage, Threshold: 0.5,
+			Weights: map[string]float64{"b": 4}}, verdicts, false, (0.9 + 4*0.3 + 0.6) / 6},
+		{"weighted zero weights", Ensemble{Rule: WeightedAverage, Threshold: 0.5,
+			Weights: map[string]float64{"a": 0, "b": 0}}, tie, false, 0},
+		{"all", Ensemble{Rule: AllAgree}, verdicts, false, 0.3},
+		{"all agree", Ensemble{Rule: AllAgree}, []Verdict{verdicts[0], verdicts[2]}, true, 0.6},
+	} {
+		fused := test.e.Fuse(test.verdicts)
+		if fused.Stego != test.stego || math.Abs(fused.Probability-test.probability) > 1e-9 {
+			t.Errorf("%s: got %v, want stego=%v p=%.3f", test.name, fused, test.stego, test.probability)
+		}
+		if fused.Inconclusive {
+			t.Errorf("%s: inconclusive", test.name)
+		}
+	}
+
+	fused := Ensemble{Rule: MajorityVote}.Fuse(verdicts)
+	if !reflect.DeepEqual(fused.Fired, []string{"a", "c"}) || fused.PayloadBytes != 40 {
+		t.Errorf("fired %v with %d bytes, want [a c] with 40", fused.Fired, fused.PayloadBytes)
+	}
+
+	fused = Ensemble{Rule: AllAgree}.Fuse(inconclusive[:1])
+	if !fused.Inconclusive || fused.Stego {
+		t.Errorf("only inconclusive verdicts fused to %v", fused)
+	}
+	if fused = (Ensemble{Rule: MajorityVote}).Fuse(nil); fused.Stego {
+		t.Errorf("no verdicts fused to %v", fused)
+	}
+}
+
+func TestParseFusionRule(t *testing.T) {
+	for _, rule := range []FusionRule{MajorityVote, MaxProbability, WeightedAverage, AllAgree} {
+		if got, err := ParseFusionRule(rule.String()); err != nil || got != rule {
+			t.Errorf("ParseFusionRule(%q) = %v, %v", rule, got, err)
+		}
+	}
+	if _, err := ParseFusionRule("average"); err == nil {
+		t.Error("ParseFusionRule(average) succeeded")
+	}
+}
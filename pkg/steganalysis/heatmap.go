@@ -0,0 +1,135 @@
+package steganalysis
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+)
+
+// DefaultTileSize is the side of the square tiles a Heatmap scores.
+const DefaultTileSize = 64
+
+// Heatmap holds a detector's score for each tile of an image, showing where in
+// the image a payload is concentrated.
+type Heatmap struct {
+	Detector string
+	// Bounds are the bounds of the analysed image.
+	Bounds   image.Rectangle
+	TileSize int
+	Cols     int
+	Rows     int
+	// Scores are the detector's probability for each tile, row-major.
+	Scores []float64
+}
+
+// subImager is implemented by all the concrete image types in package image.
+type subImager interface {
+	SubImage(r image.Rectangle) image.Image
+}
+
+// ComputeHeatmap runs a over each tileSize x tileSize tile of im, on up to
+// workers goroutines. Tiles on the right and bottom edges may be smaller.
+func ComputeHeatmap(a Analyzer, im image.Image, tileSize int, workers int) (*Heatmap, error) {
+	if tileSize <= 0 {
+		tileSize = DefaultTileSize
+	}
+
+	sub, ok := im.(subImager)
+	if !ok {
+		rgba := image.NewNRGBA(im.Bounds())
+		draw.Draw(rgba, rgba.Rect, im, im.Bounds().Min, draw.Src)
+		sub = rgba
+	}
+
+	// Tiles are already split across workers, so each detector runs on one.
+	if p, ok := a.(Parallel); ok {
+		a = p.WithWorkers(1)
+	}
+
+	bounds := im.Bounds()
+	h := &Heatmap{
+		Detector: a.Name(),
+		Bounds:   bounds,
+		TileSize: tileSize,
+		Cols:     (bounds.Dx() + tileSize - 1) / tileSize,
+		Rows:     (bounds.Dy() + tileSize - 1) / tileSize,
+	}
+	h.Scores = make([]float64, h.Cols*h.Rows)
+
+	errs := make([]error, h.Rows)
+	runBands(workBands(h.Rows, 1, workers), workers, func(_ int, b band) {
+		for row := b.y0; row < b.y1; row++ {
+			for col := 0; col < h.Cols; col++ {
+				verdict, err := a.Analyze(sub.SubImage(h.Tile(col, row)))
+				if err != nil {
+					errs[row] = err
+					return
+				}
+				h.Scores[row*h.Cols+col] = verdict.Probability
+			}
+		}
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return h, nil
+}
+
+// Tile returns the bounds of the tile in the given column and row.
+func (h *Heatmap) Tile(col, row int) image.Rectangle {
+	min := h.Bounds.Min.Add(image.Pt(col*h.TileSize, row*h.TileSize))
+	return image.Rectangle{min, min.Add(image.Pt(h.TileSize, h.TileSize))}.Intersect(h.Bounds)
+}
+
+// Score returns the score of the tile in the given column and row.
+func (h *Heatmap) Score(col, row int) float64 {
+	return h.Scores[row*h.Cols+col]
+}
+
+// Regions returns the bounds of every tile scoring above threshold.
+func (h *Heatmap) Regions(threshold float64) []image.Rectangle {
+	regions := make([]image.Rectangle, 0)
+	for row := 0; row < h.Rows; row++ {
+		for col := 0; col < h.Cols; col++ {
+			if h.Score(col, row) > threshold {
+				regions = append(regions, h.Tile(col, row))
+			}
+		}
+	}
+	return regions
+}
+
+// Overlay renders the heatmap over im, tinting each tile red in proportion to
+// its score.
+func (h *Heatmap) Overlay(im image.Image) *image.NRGBA {
+	out := image.NewNRGBA(h.Bounds)
+	draw.Draw(out, out.Rect, im, im.Bounds().Min, draw.Src)
+
+	for row := 0; row < h.Rows; row++ {
+		for col := 0; col < h.Cols; col++ {
+			score := h.Score(col, row)
+			if score < 0 {
+				score = 0
+			} else if score > 1 {
+				score = 1
+			}
+
+			// At most 60% opacity, so the image stays visible.
+			tint := &image.Uniform{C: color.NRGBA{R: 0xff, A: uint8(score * 0.6 * 0xff)}}
+			draw.Draw(out, h.Tile(col, row), tint, image.Point{}, draw.Over)
+		}
+	}
+
+	return out
+}
+
+// EncodeOverlay writes the overlay of the heatmap on im to w as a PNG.
+func (h *Heatmap) EncodeOverlay(w io.Writer, im image.Image) error {
+	return png.Encode(w, h.Overlay(im))
+}
@@ -0,0 +1,72 @@
+package steganalysis
+
+import (
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/standardrhyme/stegsecure/pkg/sanitize"
+)
+
+func TestHeatmap(t *testing.T) {
+	payload := image.Rect(256, 128, 384, 256)
+	m := embedLSB(decodeTestFile(t, "samplesmaller.png"), 1, payload, 1)
+
+	h, err := ComputeHeatmap(WS{Threshold: 0.5}, m, 64, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 624x400 in tiles of 64, the last column and row cut short.
+	if h.Cols != 10 || h.Rows != 7 || len(h.Scores) != 70 {
+		t.Fatalf("got %dx%d tiles, %d scores, want 10x7", h.Cols, h.Rows, len(h.Scores))
+	}
+	if got, want := h.Tile(9, 6), image.Rect(576, 384, 624, 400); got != want {
+		t.Errorf("corner tile %v, want %v", got, want)
+	}
+
+	want := []image.Rectangle{
+		image.Rect(256, 128, 320, 192), image.Rect(320, 128, 384, 192),
+		image.Rect(256, 192, 320, 256), image.Rect(320, 192, 384, 256),
+	}
+	if got := h.Regions(0.5); !reflect.DeepEqual(got, want) {
+		t.Errorf("regions %v, want the tiles of the payload %v", got, want)
+	}
+	if got := h.Regions(1); len(got) != 0 {
+		t.Errorf("regions above 1: %v", got)
+	}
+
+	// Only the flagged tiles are visibly tinted; clean ones score about 0.
+	overlay := h.Overlay(m)
+	if overlay.Bounds() != m.Bounds() {
+		t.Fatalf("overlay is %v, not %v", overlay.Bounds(), m.Bounds())
+	}
+	for _, p := range []image.Point{{300, 150}, {10, 10}} {
+		got, orig := overlay.NRGBAAt(p.X, p.Y), m.NRGBAAt(p.X, p.Y)
+		if tinted := int(got.R) > int(orig.R)+8 && int(got.G) < int(orig.G)-8; tinted != p.In(payload) {
+			t.Errorf("pixel %v went from %v to %v", p, orig, got)
+		}
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	h := &Heatmap{
+		Bounds:   image.Rect(0, 0, 128, 64),
+		TileSize: 64,
+		Cols:     2,
+		Rows:     1,
+		Scores:   []float64{0.2, 0.9},
+	}
+
+	policy, notice := localize(sanitize.DefaultPolicy, h, 0.5)
+	if want := []image.Rectangle{image.Rect(64, 0, 128, 64)}; !reflect.DeepEqual(policy.Regions, want) {
+		t.Errorf("localized to %v, want %v: %s", policy.Regions, want, notice)
+	}
+
+	// With no tile flagged, an empty Regions would sanitize the whole image
+	// anyway, which has to be what happens and what is logged.
+	policy, notice = localize(sanitize.DefaultPolicy, h, 0.95)
+	if len(policy.Regions) != 0 || notice != "NO TILE OF 2 FLAGGED: SANITIZING THE WHOLE IMAGE" {
+		t.Errorf("no tile flagged: regions %v, %q", policy.Regions, notice)
+	}
+}
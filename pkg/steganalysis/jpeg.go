@@ -0,0 +1,417 @@
+package steganalysis
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+
+	"github.com/standardrhyme/stegsecure/pkg/jpegcoef"
+)
+
+// DefaultJPEGDetectors are the detectors run over JPEG files, whose decoded
+// pixels carry no trace of a payload embedded in their DCT coefficients.
+var DefaultJPEGDetectors = []string{"jsteg", "f5", "outguess"}
+
+// jpegOnly returns the error for running a JPEG detector over decoded pixels.
+func jpegOnly(name string) error {
+	return fmt.Errorf("Detector %s only works on JPEG files", name)
+}
+
+// jstegOffset maps coefficient values to histogram bins, keeping the pairs
+// (2k, 2k+1) that LSB replacement swaps within a pair of bins.
+const jstegOffset = 2048
+
+// JSteg is the chi-square attack (Westfeld and Pfitzmann 1999) run over the
+// quantized AC coefficients of a JPEG, in the order they are stored. JSteg
+// replaces the LSBs of every coefficient other than 0 and 1 sequentially,
+// which equalizes the pairs of values the attack tests.
+type JSteg struct {
+	// Steps is the number of positions along the coefficients the
+	// probability is sampled at.
+	Steps     int
+	Threshold float64
+}
+
+func (JSteg) Name() string { return "jsteg" }
+
+func (j JSteg) Analyze(im image.Image) (Verdict, error) {
+	return Verdict{}, jpegOnly(j.Name())
+}
+
+func (j JSteg) AnalyzeBytes(data []byte, format string) (Verdict, error) {
+	img, err := jpegcoef.Decode(data)
+	if err != nil {
+		return Verdict{}, err
+	}
+	return j.analyzeJPEG(img), nil
+}
+
+func (j JSteg) analyzeJPEG(img *jpegcoef.Image) Verdict {
+	verdict := j.Scan(img).verdict(j.Name(), j.Threshold)
+	verdict.Format = "jpeg"
+	verdict.Width, verdict.Height = img.Width, img.Height
+	return verdict
+}
+
+// Scan computes the cumulative chi-square probability along the usable
+// coefficients of img. Samples and PayloadEnd count coefficients rather than
+// colour samples.
+func (j JSteg) Scan(img *jpegcoef.Image) ChiSquareScan {
+	samples := make([]uint16, 0)
+	img.Walk(func(_ *jpegcoef.Component, b *jpegcoef.Block) {
+		for _, v := range b[1:] {
+			if v == 0 || v == 1 {
+				continue
+			}
+			if v < -jstegOffset {
+				v = -jstegOffset
+			} else if v >= jstegOffset {
+				v = jstegOffset - 1
+			}
+			samples = append(samples, uint16(v+jstegOffset))
+		}
+	})
+
+	return ChiSquare{Steps: j.Steps, Threshold: j.Threshold}.scan(samples, 2*jstegOffset)
+}
+
+// f5Modes are the zigzag indices of the low-frequency AC modes F5 is detected
+// in: (0,1), (1,0) and (1,1).
+var f5Modes = []int{1, 2, 4}
+
+// F5 detects F5 and other embedders which shrink the magnitude of AC
+// coefficients, by calibration (Fridrich, Goljan and Hogea 2002). Cropping the
+// decompressed image by 4 pixels and recompressing it gives an estimate of the
+// cover's coefficient histogram, and the excess of zeros and shortage of ones
+// relative to it gives the fraction of coefficients modified. Heavily
+// compressed images (quality 50 and below) bias the estimate upwards.
+type F5 struct {
+	Threshold float64
+	Workers   int
+}
+
+func (F5) Name() string { return "f5" }
+
+func (f F5) Analyze(im image.Image) (Verdict, error) {
+	return Verdict{}, jpegOnly(f.Name())
+}
+
+func (f F5) WithWorkers(workers int) Analyzer {
+	f.Workers = workers
+	return f
+}
+
+// AnalyzeBytes estimates the fraction of nonzero AC coefficients modified in
+// each of the low-frequency modes of the luma component. The probability is
+// their mean.
+func (f F5) AnalyzeBytes(data []byte, format string) (Verdict, error) {
+	img, err := jpegcoef.Decode(data)
+	if err != nil {
+		return Verdict{}, err
+	}
+	return f.analyzeJPEG(img), nil
+}
+
+func (f F5) analyzeJPEG(img *jpegcoef.Image) Verdict {
+	luma := &img.Components[0]
+	q := &img.Quant[luma.Tq]
+	cal := calibrate(luma, q, f.Workers)
+
+	channels := make([]float64, len(f5Modes))
+	mean := float64(0)
+	for i, k := range f5Modes {
+		stego := modeHistogram(luma, k)
+		cover := modeHistogram(cal, k)
+		// Scale to the stego image's number of blocks.
+		scale := float64(len(luma.Blocks)) / float64(len(cal.Blocks))
+		for d := range cover {
+			cover[d] *= scale
+		}
+
+		channels[i] = f5Beta(stego, cover)
+		mean += channels[i] / float64(len(f5Modes))
+	}
+
+	probability := math.Max(0, math.Min(1, mean))
+	return Verdict{
+		Detector:    f.Name(),
+		Stego:       probability > f.Threshold,
+		Probability: probability,
+		Threshold:   f.Threshold,
+		Channels:    channels,
+		Format:      "jpeg",
+		Width:       img.Width,
+		Height:      img.Height,
+	}
+}
+
+// modeHistogram counts the coefficients of the mode with zigzag index k with
+// absolute values 0, 1 and 2.
+func modeHistogram(c *jpegcoef.Component, k int) []float64 {
+	hist := make([]float64, 3)
+	for i := range c.Blocks {
+		v := c.Blocks[i][k]
+		if v < 0 {
+			v = -v
+		}
+		if v < 3 {
+			hist[v]++
+		}
+	}
+	return hist
+}
+
+// f5Beta is the least-squares estimate of the fraction of nonzero
+// coefficients F5 shrank, given the stego histogram H and the calibrated
+// cover histogram h of a mode.
+func f5Beta(H, h []float64) float64 {
+	den := h[1]*h[1] + (h[2]-h[1])*(h[2]-h[1])
+	if den == 0 {
+		return 0
+	}
+	return (h[1]*(H[0]-h[0]) + (H[1]-h[1])*(h[2]-h[1])) / den
+}
+
+// OutGuess detects OutGuess 0.2 and other embedders which flip coefficient
+// LSBs and then correct the histogram, which the JSteg test cannot see. It uses
+// blockiness instead (Fridrich, Goljan and Hogea 2002): flipping LSBs raises
+// the discontinuities across the 8x8 block boundaries of the decompressed
+// image, by less the more LSBs have already been randomized. Randomizing every
+// usable LSB of the image is compared with doing so to its calibrated cover
+// estimate.
+type OutGuess struct {
+	Threshold float64
+	Workers   int
+}
+
+func (OutGuess) Name() string { return "outguess" }
+
+func (o OutGuess) Analyze(im image.Image) (Verdict, error) {
+	return Verdict{}, jpegOnly(o.Name())
+}
+
+func (o OutGuess) WithWorkers(workers int) Analyzer {
+	o.Workers = workers
+	return o
+}
+
+// AnalyzeBytes estimates the fraction of usable luma coefficients with
+// randomized LSBs, as one less the ratio of the blockiness gained by fully
+// randomizing the image to that gained by fully randomizing its cover
+// estimate.
+func (o OutGuess) AnalyzeBytes(data []byte, format string) (Verdict, error) {
+	img, err := jpegcoef.Decode(data)
+	if err != nil {
+		return Verdict{}, err
+	}
+	return o.analyzeJPEG(img), nil
+}
+
+func (o OutGuess) analyzeJPEG(img *jpegcoef.Image) Verdict {
+	luma := &img.Components[0]
+	q := &img.Quant[luma.Tq]
+	cal := calibrate(luma, q, o.Workers)
+
+	rng := rand.New(rand.NewSource(1))
+	stego := blockiness(randomizeLSBs(luma, rng), q, o.Workers) - blockiness(luma, q, o.Workers)
+	cover := blockiness(randomizeLSBs(cal, rng), q, o.Workers) - blockiness(cal, q, o.Workers)
+
+	rate := float64(0)
+	if cover > 0 {
+		rate = 1 - stego/cover
+	}
+
+	probability := math.Max(0, math.Min(1, rate))
+	return Verdict{
+		Detector:    o.Name(),
+		Stego:       probability > o.Threshold,
+		Probability: probability,
+		Threshold:   o.Threshold,
+		Channels:    []float64{rate},
+		Format:      "jpeg",
+		Width:       img.Width,
+		Height:      img.Height,
+	}
+}
+
+// decompress returns the samples of c as jpegcoef.Component.Samples does,
+// splitting the block rows across workers goroutines.
+func decompress(c *jpegcoef.Component, q *[64]uint16, workers int) []uint8 {
+	samples := make([]uint8, 64*c.BlocksWide*c.BlocksHigh)
+	runBands(workBands(c.BlocksHigh, 1, workers), workers, func(_ int, b band) {
+		c.SampleRows(q, samples, b.y0, b.y1)
+	})
+	return samples
+}
+
+// calibrate estimates the cover of c: its decompressed pixels are cropped by 4
+// rows and columns, which moves the 8x8 grid off any embedding, and
+// recompressed with the same quantization table.
+func calibrate(c *jpegcoef.Component, q *[64]uint16, workers int) *jpegcoef.Component {
+	samples := decompress(c, q, workers)
+	stride := 8 * c.BlocksWide
+
+	cal := &jpegcoef.Component{
+		ID:         c.ID,
+		H:          c.H,
+		V:          c.V,
+		Tq:         c.Tq,
+		BlocksWide: maxInt(c.BlocksWide-1, 1),
+		BlocksHigh: maxInt(c.BlocksHigh-1, 1),
+	}
+	cal.Blocks = make([]jpegcoef.Block, cal.BlocksWide*cal.BlocksHigh)
+
+	// With a single block row or column there is nothing to crop into.
+	x0, y0 := 4, 4
+	if c.BlocksWide == 1 {
+		x0 = 0
+	}
+	if c.BlocksHigh == 1 {
+		y0 = 0
+	}
+
+	runBands(workBands(cal.BlocksHigh, 1, workers), workers, func(_ int, b band) {
+		var in [64]float64
+		for by := b.y0; by < b.y1; by++ {
+			for bx := 0; bx < cal.BlocksWide; bx++ {
+				for y := 0; y < 8; y++ {
+					row := samples[(y0+8*by+y)*stride+x0+8*bx:]
+					for x := 0; x < 8; x++ {
+						in[y*8+x] = float64(row[x])
+					}
+				}
+				jpegcoef.ForwardDCT(&in, q, cal.Block(bx, by))
+			}
+		}
+	})
+
+	return cal
+}
+
+// edgeBasis[k][i] is the contribution of a unit coefficient at zigzag index
+// k to the i-th pixel along the edges of a block, as listed by edgePixels.
+var edgeBasis [64][32]float64
+
+// edgePixels are the row-major indices within a block of its left and right
+// columns and top and bottom rows, eight pixels each.
+var edgePixels [32]int
+
+func init() {
+	for i := 0; i < 8; i++ {
+		edgePixels[i] = i * 8
+		edgePixels[8+i] = i*8 + 7
+		edgePixels[16+i] = i
+		edgePixels[24+i] = 56 + i
+	}
+
+	var q [64]uint16
+	for k := range q {
+		q[k] = 1
+	}
+	var b jpegcoef.Block
+	var out [64]float64
+	for k := 0; k < 64; k++ {
+		b[k] = 1
+		jpegcoef.InverseDCT(&b, &q, &out)
+		b[k] = 0
+		for i, p := range edgePixels {
+			edgeBasis[k][i] = out[p] - 128
+		}
+	}
+}
+
+// blockiness returns the mean absolute difference between the decompressed
+// pixels either side of the 8x8 block boundaries of c. Only the pixels along
+// the edges of each block are decompressed, from its nonzero coefficients.
+func blockiness(c *jpegcoef.Component, q *[64]uint16, workers int) float64 {
+	edges := make([][32]int16, len(c.Blocks))
+	runBands(workBands(c.BlocksHigh, 1, workers), workers, func(_ int, bd band) {
+		var px [32]float64
+		for i := bd.y0 * c.BlocksWide; i < bd.y1*c.BlocksWide; i++ {
+			b := &c.Blocks[i]
+			for j := range px {
+				px[j] = 128
+			}
+			for k, v := range b {
+				if v == 0 {
+					continue
+				}
+				f := float64(v) * float64(q[k])
+				for j := range px {
+					px[j] += f * edgeBasis[k][j]
+				}
+			}
+			for j, v := range px {
+				switch {
+				case v < 0:
+					edges[i][j] = 0
+				case v > 255:
+					edges[i][j] = 255
+				default:
+					edges[i][j] = int16(v + 0.5)
+				}
+			}
+		}
+	})
+
+	sum, n := 0, 0
+	for by := 0; by < c.BlocksHigh; by++ {
+		for bx := 0; bx < c.BlocksWide; bx++ {
+			e := &edges[by*c.BlocksWide+bx]
+			if bx > 0 {
+				// Right column of the block to the left against this left column
+				left := &edges[by*c.BlocksWide+bx-1]
+				for j := 0; j < 8; j++ {
+					sum += absInt(int(left[8+j]) - int(e[j]))
+				}
+				n += 8
+			}
+			if by > 0 {
+				// Bottom row of the block above against this top row
+				above := &edges[(by-1)*c.BlocksWide+bx]
+				for j := 0; j < 8; j++ {
+					sum += absInt(int(above[24+j]) - int(e[16+j]))
+				}
+				n += 8
+			}
+		}
+	}
+
+	if n == 0 {
+		return 0
+	}
+	return float64(sum) / float64(n)
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// randomizeLSBs returns a copy of c with the LSB of every AC coefficient
+// other than 0 and 1 replaced with a random bit, as embedding a message of
+// the maximum length would.
+func randomizeLSBs(c *jpegcoef.Component, rng *rand.Rand) *jpegcoef.Component {
+	out := *c
+	out.Blocks = make([]jpegcoef.Block, len(c.Blocks))
+	copy(out.Blocks, c.Blocks)
+
+	for i := range out.Blocks {
+		b := &out.Blocks[i]
+		for k := 1; k < 64; k++ {
+			if b[k] != 0 && b[k] != 1 {
+				b[k] = b[k]&^1 | int16(rng.Intn(2))
+			}
+		}
+	}
+	return &out
+}
+
+func init() {
+	Register(JSteg{Steps: 20, Threshold: 0.5})
+	Register(F5{Threshold: 0.25})
+	Register(OutGuess{Threshold: 0.25})
+}
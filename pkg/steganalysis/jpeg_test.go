@@ -0,0 +1,101 @@
+package steganalysis
+
+import (
+	"bytes"
+	"image/jpeg"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/standardrhyme/stegsecure/pkg/jpegcoef"
+)
+
+// encodeTestJPEG compresses a test image to a JPEG of the given quality.
+func encodeTestJPEG(t *testing.T, name string, quality int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, decodeTestFile(t, name), &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// embedJPEG simulates the changes each embedder makes to the AC coefficients,
+// at its full capacity.
+func embedJPEG(img *jpegcoef.Image, embedder string) {
+	rng := rand.New(rand.NewSource(1))
+	img.Walk(func(_ *jpegcoef.Component, b *jpegcoef.Block) {
+		for k := 1; k < 64; k++ {
+			switch embedder {
+			case "jsteg", "outguess":
+				if b[k] != 0 && b[k] != 1 {
+					b[k] = b[k]&^1 | int16(rng.Intn(2))
+				}
+			case "f5":
+				// Shrink half the nonzero coefficients towards zero.
+				if b[k] != 0 && rng.Intn(2) == 0 {
+					if b[k] > 0 {
+						b[k]--
+					} else {
+						b[k]++
+					}
+				}
+			}
+		}
+	})
+}
+
+func TestJPEGDetectors(t *testing.T) {
+	data := encodeTestJPEG(t, "samplesmaller.png", 90)
+
+	detectors := map[string]interface {
+		analyzeJPEG(img *jpegcoef.Image) Verdict
+	}{
+		"jsteg":    JSteg{Steps: 20, Threshold: 0.5},
+		"f5":       F5{Threshold: 0.25},
+		"outguess": OutGuess{Threshold: 0.25},
+	}
+
+	for _, embedder := range []string{"", "jsteg", "f5", "outguess"} {
+		img, err := jpegcoef.Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		embedJPEG(img, embedder)
+
+		for name, d := range detectors {
+			// Detectors may also pick up the other embedders, so only
+			// check each against its own, and against the cover.
+			if embedder != "" && embedder != name {
+				continue
+			}
+
+			verdict := d.analyzeJPEG(img)
+			if want := embedder != ""; verdict.Stego != want {
+				t.Errorf("%s over %q embedding: stego=%t probability=%.3f, want stego=%t", name, embedder, verdict.Stego, verdict.Probability, want)
+			}
+		}
+	}
+}
+
+func TestJPEGDetectorsNeedJPEG(t *testing.T) {
+	png, err := os.ReadFile("../../testfiles/samplesmall.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range DefaultJPEGDetectors {
+		a, err := Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := RunBytes(a, png); err == nil {
+			t.Errorf("%s accepted a PNG", name)
+		}
+		if _, err := a.Analyze(decodeTestFile(t, "samplesmall.png")); err == nil {
+			t.Errorf("%s accepted decoded pixels", name)
+		}
+	}
+}
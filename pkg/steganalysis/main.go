@@ -7,33 +7,47 @@ import (
 	"image/png"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/standardrhyme/stegsecure/pkg/filetype"
 	"github.com/standardrhyme/stegsecure/pkg/interceptionfs"
 )
 
-// Returns whether the file has steganographic content.
-func runPython(path string) bool {
+// Returns the verdict of the Python Sample Pairs script on the file.
+func runPython(path string) Verdict {
 	fmt.Println("ARTIFICIAL TIME DELAY")
 	time.Sleep(20 * time.Second)
 	fmt.Println("RUNNING STEGA")
 	fmt.Println("")
+	verdict := Verdict{Detector: "samplepairs-python", Threshold: 0.5}
+	start := time.Now()
 	out, err := exec.Command("python3", "./python-scripts/samplepairs.py", path).Output()
+	verdict.Elapsed = time.Since(start)
 	if err != nil {
-		return false
+		return verdict
 	}
 
 	parts := strings.Split(strings.TrimSpace(string(out)), "\n")
 	output := parts[len(parts) - 1]
 	fmt.Print(string(out))
-	return output == "TRUE"
+
+	for _, line := range parts {
+		if p := strings.TrimPrefix(line, "Probability of being a stego image:"); p != line {
+			verdict.Probability, _ = strconv.ParseFloat(strings.TrimSpace(p), 64)
+		}
+	}
+	verdict.Stego = output == "TRUE"
+
+	return verdict
 }
 
-// Returns whether the file has steganographic content.
-func AnalyzeCreate(b []byte) bool {
+// Returns the verdict on whether the file has steganographic content.
+func AnalyzeCreate(b []byte) Verdict {
 	// convert []byte to image for saving to file
-	img, _, _ := image.Decode(bytes.NewReader(b))
+	img, format, _ := image.Decode(bytes.NewReader(b))
 
 	//save the imgByte to file
 	out, err := os.CreateTemp("", "*")
@@ -42,7 +56,7 @@ func AnalyzeCreate(b []byte) bool {
 
 	if err != nil {
 		fmt.Println(err)
-		return false
+		return Verdict{Detector: "samplepairs-python"}
 	}
 
 	err = png.Encode(out, img)
@@ -55,7 +69,11 @@ func AnalyzeCreate(b []byte) bool {
 	fmt.Println(out.Name())
 
 	//Run python script on file
-	return runPython(out.Name())
+	verdict := runPython(out.Name())
+	verdict.Format = format
+	verdict.Width = img.Bounds().Dx()
+	verdict.Height = img.Bounds().Dy()
+	return verdict
 }
 
 func Analyze(n interceptionfs.Node) {
@@ -69,18 +87,24 @@ func Analyze(n interceptionfs.Node) {
 	fmt.Println("===========")
 	fmt.Println("FILE NAME: ", fh.Name())
 
-	if !strings.HasSuffix(fh.Name(), ".png") {
-		fh.File.Release()
-		return
-	}
-
 	data, err := fh.InternalReadAll()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return
 	}
 
-	if AnalyzeCreate(data) {
+	t, ok := filetype.Detect(data)
+	if !ok {
+		fh.File.Release()
+		return
+	}
+	if !t.MatchesName(fh.Name()) {
+		fmt.Println("ANOMALY: extension", filepath.Ext(fh.Name()), "does not match", t.Name, "content")
+	}
+
+	verdict := AnalyzeCreate(data)
+	fmt.Println(verdict)
+	if verdict.Stego {
 		fmt.Println("SANITIZE")
 	}
 
@@ -0,0 +1,553 @@
+package steganalysis
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+
+	"github.com/standardrhyme/stegsecure/pkg/filetype"
+	"github.com/standardrhyme/stegsecure/pkg/interceptionfs"
+	"github.com/standardrhyme/stegsecure/pkg/metadata"
+	"github.com/standardrhyme/stegsecure/pkg/pngchunk"
+	"github.com/standardrhyme/stegsecure/pkg/sanitize"
+	"github.com/standardrhyme/stegsecure/pkg/trailing"
+	"github.com/standardrhyme/stegsecure/pkg/vp8l"
+)
+
+// DefaultDetectors are the detectors AnalyzeGo runs.
+var DefaultDetectors = []string{"samplepairs"}
+
+// Config selects the detectors run by the notifier returned from NewNotifier,
+// and how their verdicts are fused.
+type Config struct {
+	// Detectors are registered detector names. An empty list means
+	// DefaultDetectors.
+	Detectors []string
+	// JPEGDetectors are the detectors run over JPEG files instead. An empty
+	// list means DefaultJPEGDetectors.
+	JPEGDetectors []string
+	// PaletteDetectors are the detectors run over GIFs and palette PNGs
+	// instead. An empty list means DefaultPaletteDetectors.
+	PaletteDetectors []string
+
+	// Fusion, Weights and Threshold configure the Ensemble the detectors are
+	// run in. A zero Threshold means 0.5.
+	Fusion    FusionRule
+	Weights   map[string]float64
+	Threshold float64
+
+	// Workers is the number of goroutines each detector that supports it
+	// splits an image across. Zero means one per CPU.
+	Workers int
+	// RSMasks are the flipping masks of the rs detector, wherever it is
+	// selected. An empty list means DefaultRSMasks.
+	RSMasks []Mask
+
+	// Heatmap configures the per-tile heatmap computed for flagged images.
+	Heatmap HeatmapConfig
+
+	// Sanitize is the policy flagged files are sanitized with. Its Regions
+	// are replaced by the flagged tiles if Heatmap.Localize is set, and its
+	// Depth raised to that recommended by BitPlaneDepth. The metadata of
+	// every file is stripped according to its Metadata. Files whose quality
+	// would fall below its floor even with the gentlest policy are sanitized
+	// with that policy anyway, and files that cannot be sanitized at all are
+	// withheld.
+	Sanitize sanitize.Policy
+
+	// Truncate removes data appended after the end of files, even if their
+	// pixels are not flagged.
+	Truncate bool
+	// StripChunks rewrites PNGs with suspicious chunks, keeping only the
+	// chunks whitelisted by Sanitize.PNGChunks, even if their pixels are not
+	// flagged.
+	StripChunks bool
+
+	// Report, if set, is called for every file after it has been analyzed
+	// and (if needed) sanitized.
+	Report func(report Report)
+}
+
+// HeatmapConfig configures the heatmaps computed for flagged images.
+type HeatmapConfig struct {
+	// Detector is the registered detector scoring each tile. If empty, no
+	// heatmap is computed.
+	Detector string
+	// TileSize is the side of each tile. Zero means DefaultTileSize.
+	TileSize int
+	// Threshold is the score above which a tile counts as embedded. A zero
+	// Threshold means 0.5.
+	Threshold float64
+	// Dir, if set, is a directory the heatmap of each flagged image is
+	// written to, as a PNG overlay named after the file.
+	Dir string
+	// Localize limits sanitization to the tiles above Threshold.
+	Localize bool
+}
+
+// Report is everything the notifier found out about one downloaded file.
+type Report struct {
+	Name string
+	// Type is the name of the file's format, as detected from its contents.
+	Type   string
+	Result Fused
+	// Anomalies describe anything suspicious about the file besides its
+	// pixels, such as an extension which does not match its contents.
+	Anomalies []string
+	// Trailing is the data found after the end of the image, if any.
+	Trailing *trailing.Payload
+	// Metadata is the metadata of the file.
+	Metadata *metadata.Metadata
+	// Thumbnail is the verdict of the Thumbnail detector, if the file has
+	// an EXIF thumbnail.
+	Thumbnail *Verdict
+	// Depth is the number of low bit planes of a spatial image which look
+	// random, see BitPlaneDepth. Flagged images with more than one are
+	// sanitized to that depth; others only get an anomaly, as the
+	// bitplanes detector flags them if it is run.
+	Depth int
+	// Heatmap is set if the image was flagged and a heatmap detector is
+	// configured.
+	Heatmap   *Heatmap
+	Sanitized bool
+	// Quality compares the image with the original, if its pixels were
+	// sanitized.
+	Quality *sanitize.Quality
+	// Policy is the policy the pixels were sanitized with, and Fallbacks the
+	// gentler policies it fell back to, if the configured one fell below the
+	// quality floor.
+	Policy    *sanitize.Policy
+	Fallbacks []sanitize.Fallback
+	// BelowFloor is set if even the gentlest policy fell below the quality
+	// floor. The image is sanitized with it all the same.
+	BelowFloor bool
+	// Withheld is set if the image was flagged but could not be sanitized.
+	// It is then not released, rather than released with its payload.
+	Withheld bool
+}
+
+// analyzeBytes decodes b once and runs every detector over it. If every
+// detector works on the encoded bytes, b is not decoded at all.
+func analyzeBytes(b []byte, analyzers []Analyzer) ([]Verdict, error) {
+	pixels := false
+	for _, a := range analyzers {
+		if _, ok := a.(ByteAnalyzer); !ok {
+			pixels = true
+		}
+	}
+
+	var im image.Image
+	var format string
+	var err error
+	if pixels {
+		im, format, err = image.Decode(bytes.NewReader(b))
+	} else {
+		_, format, err = image.DecodeConfig(bytes.NewReader(b))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return analyzeImage(im, b, format, analyzers), nil
+}
+
+// analyzeImage runs every detector over b, which has been decoded to im from
+// the given format, or not at all if im is nil.
+func analyzeImage(im image.Image, b []byte, format string, analyzers []Analyzer) []Verdict {
+	verdicts := make([]Verdict, 0, len(analyzers))
+	for _, a := range analyzers {
+		verdict, err := runDetector(a, im, b, format)
+		if err != nil {
+			fmt.Println(a.Name()+":", err)
+			continue
+		}
+
+		verdicts = append(verdicts, verdict)
+	}
+
+	return verdicts
+}
+
+// notifier holds the state of a notifier returned from NewNotifier.
+type notifier struct {
+	cfg      Config
+	ensemble Ensemble
+	jpeg     Ensemble
+	palette  Ensemble
+	heatmap  Analyzer
+}
+
+// heatmapFor computes the heatmap of a flagged image, writing its overlay out
+// if configured to.
+func (nt *notifier) heatmapFor(name string, im image.Image) (*Heatmap, error) {
+	h, err := ComputeHeatmap(nt.heatmap, im, nt.cfg.Heatmap.TileSize, nt.cfg.Workers)
+	if err != nil {
+		return nil, err
+	}
+
+	if nt.cfg.Heatmap.Dir != "" {
+		out, err := os.Create(filepath.Join(nt.cfg.Heatmap.Dir, filepath.Base(name)+".heatmap.png"))
+		if err != nil {
+			return h, err
+		}
+		defer out.Close()
+
+		if err := h.EncodeOverlay(out, im); err != nil {
+			return h, err
+		}
+	}
+
+	return h, nil
+}
+
+// localize limits policy to the tiles of h scoring above threshold, and
+// returns a notice of what it did. If no tile does, the payload the ensemble
+// found is spread too thinly for any tile to show it, and the whole image is
+// sanitized rather than none of it.
+func localize(policy sanitize.Policy, h *Heatmap, threshold float64) (sanitize.Policy, string) {
+	regions := h.Regions(threshold)
+	if len(regions) == 0 {
+		policy.Regions = nil
+		return policy, fmt.Sprintf("NO TILE OF %d FLAGGED: SANITIZING THE WHOLE IMAGE", len(h.Scores))
+	}
+
+	policy.Regions = regions
+	return policy, fmt.Sprintf("LOCALIZED TO %d OF %d TILES", len(regions), len(h.Scores))
+}
+
+// sanitizeFlagged sanitizes the pixels of a flagged image with policy, and
+// records the outcome in report. It returns the sanitized image, or nil if the
+// image could not be sanitized and is withheld, as releasing it unchanged
+// would let its payload through.
+func sanitizeFlagged(policy sanitize.Policy, data []byte, report *Report) []byte {
+	result, err := policy.Sanitize(data)
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println("WITHHELD")
+		report.Withheld = true
+		return nil
+	}
+
+	for _, fallback := range result.Fallbacks {
+		fmt.Println("FALLBACK", fallback)
+	}
+	if depth := result.Policy.Depth; len(result.Fallbacks) > 0 && depth < report.Depth {
+		fmt.Printf("BELOW RECOMMENDED DEPTH: %d OF %d RANDOM BIT PLANES SANITIZED\n", depth, report.Depth)
+	}
+	fmt.Println("QUALITY", result.Quality)
+	if result.BelowFloor {
+		fmt.Println("BELOW QUALITY FLOOR: SANITIZED WITH THE GENTLEST POLICY")
+	}
+	report.Quality = &result.Quality
+	report.Policy = &result.Policy
+	report.Fallbacks = result.Fallbacks
+	report.BelowFloor = result.BelowFloor
+	report.Sanitized = true
+	return result.Data
+}
+
+// analyze scans a downloaded file with the configured detectors, sanitizes it
+// if needed, and releases it to the real filesystem.
+func (nt *notifier) analyze(n interceptionfs.Node) {
+	fh, ok := n.(*interceptionfs.FileHandle)
+	if !ok {
+		// Not a file handle
+		return
+	}
+
+	fmt.Println()
+	fmt.Println("===========")
+	fmt.Println("FILE NAME: ", fh.Name())
+
+	data, err := fh.InternalReadAll()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	// Files are recognized by their contents, as their names can be wrong.
+	t, ok := filetype.Detect(data)
+	if !ok {
+		// A name claiming an image type for content which is none is an
+		// anomaly, like any other mismatch.
+		if claimed, ok := filetype.ForName(fh.Name()); ok {
+			report := Report{Name: fh.Name(), Anomalies: []string{
+				fmt.Sprintf("extension %s claims %s but the content is not a known image", filepath.Ext(fh.Name()), claimed.Name),
+			}}
+			fmt.Println("ANOMALY:", report.Anomalies[0])
+			if nt.cfg.Report != nil {
+				nt.cfg.Report(report)
+			}
+		}
+		fh.File.Release()
+		return
+	}
+
+	report := Report{Name: fh.Name(), Type: t.Name}
+	if !t.MatchesName(fh.Name()) {
+		report.Anomalies = append(report.Anomalies, fmt.Sprintf("extension %s does not match %s content", filepath.Ext(fh.Name()), t.Name))
+	}
+	report.Trailing, err = trailing.Scan(data, t.Name)
+	if err != nil {
+		fmt.Println(err)
+	} else if report.Trailing != nil {
+		report.Anomalies = append(report.Anomalies, "trailing "+report.Trailing.String())
+	}
+	// Payloads can also hide in the text and private chunks of PNGs.
+	suspectChunks := false
+	if t.Name == "png" {
+		chunks, err := pngchunk.Parse(data)
+		if err != nil {
+			fmt.Println(err)
+		} else if problems := pngchunk.Inspect(chunks, pngchunk.DefaultMaxAncillary); len(problems) > 0 {
+			report.Anomalies = append(report.Anomalies, problems...)
+			suspectChunks = true
+		}
+	}
+	// And in metadata fields, or in a thumbnail which does not match the
+	// image.
+	report.Metadata, err = metadata.Extract(data, t.Name)
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		report.Anomalies = append(report.Anomalies, metadata.Inspect(report.Metadata, metadata.DefaultMaxField)...)
+	}
+	if report.Metadata != nil && report.Metadata.Thumbnail != nil {
+		verdict, err := RunBytes(Thumbnail{Threshold: 0.5}, data)
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			report.Thumbnail = &verdict
+			if verdict.Stego {
+				report.Anomalies = append(report.Anomalies, fmt.Sprintf("EXIF thumbnail differs from the image (distance %.2f)", verdict.Channels[0]))
+			}
+		}
+	}
+	// JPEG embedders work on the DCT coefficients rather than the pixels,
+	// and palette embedders on the palette and its indices, so JPEGs and
+	// palette images get their own detectors.
+	ensemble := nt.ensemble
+	spatial := false
+	format := t.Name
+	config, _, _ := image.DecodeConfig(bytes.NewReader(data))
+	if _, ok := config.ColorModel.(color.Palette); ok || format == "gif" {
+		ensemble = nt.palette
+	} else if format == "jpeg" {
+		ensemble = nt.jpeg
+	} else {
+		spatial = true
+	}
+	if format == "webp" && !vp8l.IsLossless(data) {
+		// The payload of a lossy WebP would be in its VP8 coefficients,
+		// which nothing parses, so the spatial detectors are a best effort.
+		fmt.Println("LOSSY WEBP: SPATIAL DETECTORS ONLY")
+	}
+	// Spatial images are decoded once, for the detectors, bit-plane analysis
+	// and the heatmap.
+	var im image.Image
+	var decoded string
+	if spatial {
+		if im, decoded, err = image.Decode(bytes.NewReader(data)); err != nil {
+			fmt.Println(err)
+		}
+	}
+	// LSB-2 and deeper embedders leave random bit planes under coherent
+	// ones, which also tells how deep to sanitize flagged images.
+	if im != nil {
+		if report.Depth, _ = BitPlaneDepth(im, nt.cfg.Workers); report.Depth > 1 {
+			report.Anomalies = append(report.Anomalies, fmt.Sprintf("bit planes 0-%d look random", report.Depth-1))
+		}
+	}
+	for _, anomaly := range report.Anomalies {
+		fmt.Println("ANOMALY:", anomaly)
+	}
+
+	report.Result, err = ensemble.run(data, im, decoded)
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	for _, verdict := range report.Result.Verdicts {
+		fmt.Println(verdict)
+	}
+	fmt.Println(report.Result)
+
+	if report.Result.Stego {
+		policy := nt.cfg.Sanitize
+		if report.Depth > policy.Depth {
+			policy.Depth = report.Depth
+			fmt.Printf("DEPTH %d\n", policy.Depth)
+		}
+
+		// The heatmap only covers the first page of a TIFF.
+		if nt.heatmap != nil && im != nil && report.Result.Pages < 2 {
+			report.Heatmap, err = nt.heatmapFor(fh.Name(), im)
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			if report.Heatmap != nil && nt.cfg.Heatmap.Localize {
+				var notice string
+				policy, notice = localize(policy, report.Heatmap, nt.cfg.Heatmap.Threshold)
+				fmt.Println(notice)
+			}
+		}
+
+		fmt.Println("SANITIZE")
+		if cleaned := sanitizeFlagged(policy, data, &report); cleaned != nil {
+			fh.InternalOverwrite(cleaned)
+		}
+	} else {
+		// Re-encoding drops trailing data and ancillary chunks, and strips
+		// metadata and rewrites thumbnails, along with any payload in the
+		// pixels, so this is only needed if they were not flagged.
+		cleaned := data
+		if report.Trailing != nil && nt.cfg.Truncate {
+			fmt.Println("TRUNCATE")
+			if cleaned, err = sanitize.Truncate(cleaned); err != nil {
+				fmt.Println(err)
+				cleaned = data
+			}
+		}
+		if suspectChunks && nt.cfg.StripChunks {
+			fmt.Println("STRIP CHUNKS")
+			if stripped, err := nt.cfg.Sanitize.StripPNGChunks(cleaned); err != nil {
+				fmt.Println(err)
+			} else {
+				cleaned = stripped
+			}
+		}
+		if report.Thumbnail != nil && report.Thumbnail.Stego {
+			fmt.Println("REWRITE THUMBNAIL")
+			if rewritten, err := nt.cfg.Sanitize.SanitizeThumbnail(cleaned); err != nil {
+				fmt.Println(err)
+			} else {
+				cleaned = rewritten
+			}
+		}
+		if stripped, err := nt.cfg.Sanitize.StripMetadata(cleaned); err != nil {
+			fmt.Println(err)
+		} else if !bytes.Equal(stripped, cleaned) {
+			fmt.Println("STRIP METADATA")
+			cleaned = stripped
+		}
+		if !bytes.Equal(cleaned, data) {
+			fh.InternalOverwrite(cleaned)
+			report.Sanitized = true
+		}
+	}
+
+	if nt.cfg.Report != nil {
+		nt.cfg.Report(report)
+	}
+
+	if !report.Withheld {
+		fh.File.Release()
+	}
+}
+
+// lookupDetectors resolves a list of detector names, setting the number of
+// workers of those which support it, and the masks of rs.
+func lookupDetectors(names []string, cfg Config) ([]Analyzer, error) {
+	analyzers, err := LookupAll(names)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, a := range analyzers {
+		if p, ok := a.(Parallel); ok {
+			a = p.WithWorkers(cfg.Workers)
+		}
+		analyzers[i] = withMasks(a, cfg.RSMasks)
+	}
+	return analyzers, nil
+}
+
+// withMasks returns a with the given masks if it is the rs detector and there
+// are any, and a itself otherwise.
+func withMasks(a Analyzer, masks []Mask) Analyzer {
+	if r, ok := a.(RS); ok && len(masks) > 0 {
+		r.Masks = masks
+		return r
+	}
+	return a
+}
+
+// NewNotifier returns an interceptionfs notifier which runs the detectors
+// selected by cfg over each downloaded PNG, JPEG, GIF, BMP, TIFF or WebP.
+func NewNotifier(cfg Config) (func(interceptionfs.Node), error) {
+	names := cfg.Detectors
+	if len(names) == 0 {
+		names = DefaultDetectors
+	}
+
+	analyzers, err := lookupDetectors(names, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	jpegNames := cfg.JPEGDetectors
+	if len(jpegNames) == 0 {
+		jpegNames = DefaultJPEGDetectors
+	}
+
+	jpegAnalyzers, err := lookupDetectors(jpegNames, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	paletteNames := cfg.PaletteDetectors
+	if len(paletteNames) == 0 {
+		paletteNames = DefaultPaletteDetectors
+	}
+
+	paletteAnalyzers, err := lookupDetectors(paletteNames, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Threshold == 0 {
+		cfg.Threshold = 0.5
+	}
+	if cfg.Heatmap.Threshold == 0 {
+		cfg.Heatmap.Threshold = 0.5
+	}
+
+	nt := &notifier{
+		cfg: cfg,
+		ensemble: Ensemble{
+			Detectors: analyzers,
+			Rule:      cfg.Fusion,
+			Weights:   cfg.Weights,
+			Threshold: cfg.Threshold,
+		},
+		jpeg: Ensemble{
+			Detectors: jpegAnalyzers,
+			Rule:      cfg.Fusion,
+			Weights:   cfg.Weights,
+			Threshold: cfg.Threshold,
+		},
+		palette: Ensemble{
+			Detectors: paletteAnalyzers,
+			Rule:      cfg.Fusion,
+			Weights:   cfg.Weights,
+			Threshold: cfg.Threshold,
+		},
+	}
+
+	if cfg.Heatmap.Localize && cfg.Heatmap.Detector == "" {
+		return nil, fmt.Errorf("Localized sanitization needs a heatmap detector.")
+	}
+
+	if cfg.Heatmap.Detector != "" {
+		nt.heatmap, err = Lookup(cfg.Heatmap.Detector)
+		if err != nil {
+			return nil, err
+		}
+		nt.heatmap = withMasks(nt.heatmap, cfg.RSMasks)
+	}
+
+	return nt.analyze, nil
+}
@@ -0,0 +1,60 @@
+package steganalysis
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/standardrhyme/stegsecure/pkg/sanitize"
+)
+
+func TestSanitizeFlagged(t *testing.T) {
+	data, err := os.ReadFile("../../testfiles/samplesmaller.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A floor no policy can meet still releases the gentlest result.
+	var report Report
+	cleaned := sanitizeFlagged(sanitize.Policy{Depth: 2, MinSSIM: 1}, data, &report)
+	if cleaned == nil || bytes.Equal(cleaned, data) {
+		t.Fatal("impossible floor: image not sanitized")
+	}
+	if !report.Sanitized || !report.BelowFloor || report.Withheld || report.Policy.Depth != 1 {
+		t.Errorf("impossible floor: sanitized %v, below floor %v, withheld %v, depth %d",
+			report.Sanitized, report.BelowFloor, report.Withheld, report.Policy.Depth)
+	}
+
+	// An image that cannot be sanitized is withheld rather than released.
+	report = Report{}
+	if cleaned := sanitizeFlagged(sanitize.DefaultPolicy, []byte("not an image"), &report); cleaned != nil {
+		t.Error("garbage: sanitized")
+	}
+	if report.Sanitized || !report.Withheld {
+		t.Errorf("garbage: sanitized %v, withheld %v", report.Sanitized, report.Withheld)
+	}
+}
+
+func TestLookupDetectors(t *testing.T) {
+	masks := []Mask{{{0, 1, 1, 0}}}
+	analyzers, err := lookupDetectors([]string{"rs", "samplepairs"}, Config{Workers: 2, RSMasks: masks})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rs := analyzers[0].(RS); !reflect.DeepEqual(rs.Masks, masks) || rs.Workers != 2 {
+		t.Errorf("rs: masks %v, workers %d, want %v and 2", rs.Masks, rs.Workers, masks)
+	}
+	if sp := analyzers[1].(SamplePairs); sp.Workers != 2 {
+		t.Errorf("samplepairs: workers %d, want 2", sp.Workers)
+	}
+
+	analyzers, err = lookupDetectors([]string{"rs"}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs := analyzers[0].(RS); !reflect.DeepEqual(rs.Masks, DefaultRSMasks) {
+		t.Errorf("rs without masks: %v, want %v", rs.Masks, DefaultRSMasks)
+	}
+}
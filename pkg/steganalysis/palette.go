@@ -0,0 +1,229 @@
+package steganalysis
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"math"
+	"sort"
+
+	"github.com/standardrhyme/stegsecure/pkg/sanitize"
+)
+
+// DefaultPaletteDetectors are the detectors run over GIFs and palette PNGs,
+// whose embedders hide data in the palette and the choice of index rather
+// than in the LSBs of the samples.
+var DefaultPaletteDetectors = []string{"palette"}
+
+// minPaletteFrame is the number of opaque pixels a frame needs for its parity
+// test to be trusted. Smaller frames of an animation are only scored if no
+// frame is this large.
+const minPaletteFrame = 4096
+
+// Palette detects embedding in palette images, frame by frame for animated
+// GIFs. It combines three tests:
+//
+//   - Parity: the chi-square attack over the index of each pixel, and over
+//     the rank of its colour in the palette sorted by luminance. EzStego
+//     embeds in the LSB of the latter, which equalizes the pairs of colours
+//     adjacent in luminance (Westfeld and Pfitzmann 1999).
+//   - Ordering: how close the palette is to being sorted by luminance, as
+//     EzStego and similar tools leave it. Some encoders sort their palettes
+//     too, so this is reported in Channels but does not flag an image.
+//   - Duplicates: the fraction of the colours used which have a
+//     near-duplicate, within Distance in every channel. S-Tools and similar
+//     tools shrink the palette and add near-duplicates of each colour to
+//     choose between (Johnson and Jajodia 1998).
+//
+// The probability of a frame is the larger of its parity and duplicates
+// scores, and that of the image the largest over its frames. Channels holds
+// the parity, ordering and duplicates scores of the frame it came from.
+type Palette struct {
+	// Distance is the largest difference in any 8-bit channel between two
+	// colours counted as near-duplicates.
+	Distance  int
+	Threshold float64
+}
+
+func (Palette) Name() string { return "palette" }
+
+func (p Palette) Analyze(im image.Image) (Verdict, error) {
+	m, ok := im.(*image.Paletted)
+	if !ok {
+		return Verdict{}, fmt.Errorf("Detector %s only works on palette images", p.Name())
+	}
+	return p.analyzeFrames([]*image.Paletted{m}), nil
+}
+
+// AnalyzeBytes runs the detector over every frame of a GIF, or over a palette
+// image in any other format.
+func (p Palette) AnalyzeBytes(data []byte, format string) (Verdict, error) {
+	if format != "gif" {
+		im, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return Verdict{}, err
+		}
+		return p.Analyze(im)
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	verdict := p.analyzeFrames(g.Image)
+	verdict.Width, verdict.Height = g.Config.Width, g.Config.Height
+	return verdict, nil
+}
+
+func (p Palette) analyzeFrames(frames []*image.Paletted) Verdict {
+	large := false
+	for _, m := range frames {
+		large = large || opaquePixels(m) >= minPaletteFrame
+	}
+
+	verdict := Verdict{Detector: p.Name(), Threshold: p.Threshold}
+	for _, m := range frames {
+		if large && opaquePixels(m) < minPaletteFrame {
+			continue
+		}
+
+		parity, ordering, duplicates := p.scores(m)
+		probability := math.Max(parity, duplicates)
+		if verdict.Channels == nil || probability > verdict.Probability {
+			verdict.Probability = probability
+			verdict.Channels = []float64{parity, ordering, duplicates}
+		}
+	}
+
+	verdict.Stego = verdict.Probability > p.Threshold
+	if len(frames) > 0 {
+		verdict.Width = frames[0].Bounds().Dx()
+		verdict.Height = frames[0].Bounds().Dy()
+	}
+	return verdict
+}
+
+// opaquePixels returns the number of pixels of m with an opaque colour.
+func opaquePixels(m *image.Paletted) int {
+	n := 0
+	for _, count := range indexCounts(m) {
+		n += count
+	}
+	return n
+}
+
+// indexCounts returns the number of pixels of m with each opaque palette
+// index.
+func indexCounts(m *image.Paletted) []int {
+	counts := make([]int, len(m.Palette))
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for _, i := range m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)] {
+			if int(i) < len(counts) {
+				counts[i]++
+			}
+		}
+	}
+	for i, c := range m.Palette {
+		if !sanitize.Opaque(c) {
+			counts[i] = 0
+		}
+	}
+	return counts
+}
+
+// scores computes the parity, ordering and duplicates scores of m.
+func (p Palette) scores(m *image.Paletted) (parity, ordering, duplicates float64) {
+	// Rank the opaque entries by luminance, as EzStego does.
+	var sorted []int
+	for i, c := range m.Palette {
+		if sanitize.Opaque(c) {
+			sorted = append(sorted, i)
+		}
+	}
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return sanitize.Luminance(m.Palette[sorted[a]]) < sanitize.Luminance(m.Palette[sorted[b]])
+	})
+	rank := make([]uint16, len(m.Palette))
+	for r, i := range sorted {
+		rank[i] = uint16(r)
+	}
+
+	var indices, ranks []uint16
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for _, i := range m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)] {
+			if int(i) < len(m.Palette) && sanitize.Opaque(m.Palette[i]) {
+				indices = append(indices, uint16(i))
+				ranks = append(ranks, rank[i])
+			}
+		}
+	}
+
+	chi := ChiSquare{Steps: 20, Threshold: p.Threshold}
+	for _, samples := range [][]uint16{indices, ranks} {
+		if scan := chi.scan(samples, 256); scan.Decided >= 0 {
+			parity = math.Max(parity, scan.Probabilities[scan.Decided])
+		}
+	}
+
+	// The fraction of consecutive opaque entries in luminance order, which is
+	// about a half for an unsorted palette, and 0 or 1 for a sorted one.
+	var opaqueEntries []color.Color
+	for _, c := range m.Palette {
+		if sanitize.Opaque(c) {
+			opaqueEntries = append(opaqueEntries, c)
+		}
+	}
+	if len(opaqueEntries) >= 8 {
+		ascending := 0
+		for i := 1; i < len(opaqueEntries); i++ {
+			if sanitize.Luminance(opaqueEntries[i]) >= sanitize.Luminance(opaqueEntries[i-1]) {
+				ascending++
+			}
+		}
+		ordering = math.Abs(2*float64(ascending)/float64(len(opaqueEntries)-1) - 1)
+	}
+
+	var used []color.NRGBA
+	for i, count := range indexCounts(m) {
+		if count > 0 {
+			used = append(used, color.NRGBAModel.Convert(m.Palette[i]).(color.NRGBA))
+		}
+	}
+	if len(used) > 1 {
+		near := 0
+		for i, c := range used {
+			for j, d := range used {
+				if i != j && colorDistance(c, d) <= p.Distance {
+					near++
+					break
+				}
+			}
+		}
+		duplicates = float64(near) / float64(len(used))
+	}
+
+	return parity, ordering, duplicates
+}
+
+// colorDistance returns the largest difference between c and d in any channel.
+func colorDistance(c, d color.NRGBA) int {
+	dist := 0
+	for _, diff := range []int{int(c.R) - int(d.R), int(c.G) - int(d.G), int(c.B) - int(d.B)} {
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > dist {
+			dist = diff
+		}
+	}
+	return dist
+}
+
+func init() {
+	Register(Palette{Distance: 2, Threshold: 0.5})
+}
@@ -0,0 +1,98 @@
+package steganalysis
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/gif"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/standardrhyme/stegsecure/pkg/sanitize"
+)
+
+// encodeTestGIF quantizes a test image to a GIF with the given number of
+// frames, each a copy of the first.
+func encodeTestGIF(t *testing.T, name string, frames int) *gif.GIF {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, decodeTestFile(t, name), &gif.Options{Drawer: draw.FloydSteinberg}); err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for len(g.Image) < frames {
+		m := *g.Image[0]
+		m.Pix = append([]uint8(nil), m.Pix...)
+		g.Image = append(g.Image, &m)
+		g.Delay = append(g.Delay, g.Delay[0])
+		g.Disposal = append(g.Disposal, g.Disposal[0])
+	}
+	return g
+}
+
+// embedEzStego simulates EzStego at full capacity, setting the LSB of the rank
+// of each pixel's colour in luminance order to a random bit.
+func embedEzStego(m *image.Paletted) {
+	rng := rand.New(rand.NewSource(1))
+
+	sorted := make([]int, len(m.Palette))
+	for i := range sorted {
+		sorted[i] = i
+	}
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return sanitize.Luminance(m.Palette[sorted[a]]) < sanitize.Luminance(m.Palette[sorted[b]])
+	})
+	rank := make([]int, len(m.Palette))
+	for r, i := range sorted {
+		rank[i] = r
+	}
+
+	for j, i := range m.Pix {
+		if r := rank[i]&^1 | rng.Intn(2); r < len(sorted) {
+			m.Pix[j] = uint8(sorted[r])
+		}
+	}
+}
+
+func TestPaletteDetector(t *testing.T) {
+	p := Palette{Distance: 2, Threshold: 0.5}
+
+	g := encodeTestGIF(t, "samplesmaller.png", 3)
+	if v := p.analyzeFrames(g.Image); v.Stego {
+		t.Errorf("cover GIF flagged: %v", v)
+	}
+
+	// Embedding in a single frame of the animation is enough to flag it.
+	embedEzStego(g.Image[1])
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	v, err := RunBytes(p, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Stego {
+		t.Errorf("EzStego frame not flagged: %v", v)
+	}
+
+	clean, err := sanitize.DefaultPolicy.SanitizeBytes(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := RunBytes(p, clean); err != nil || v.Stego {
+		t.Errorf("sanitized GIF still flagged: %v %v", v, err)
+	}
+}
+
+func TestPaletteDetectorNeedsPalette(t *testing.T) {
+	if _, err := (Palette{}).Analyze(decodeTestFile(t, "samplesmall.png")); err == nil {
+		t.Errorf("palette detector accepted an RGB image")
+	}
+}
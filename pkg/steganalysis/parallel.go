@@ -0,0 +1,87 @@
+package steganalysis
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Parallel is implemented by detectors whose work can be split across a pool
+// of goroutines.
+type Parallel interface {
+	Analyzer
+	// WithWorkers returns a copy of the detector using at most workers
+	// goroutines. Zero means one per CPU.
+	WithWorkers(workers int) Analyzer
+}
+
+// band is a horizontal strip of rows [y0, y1).
+type band struct {
+	y0, y1 int
+}
+
+// splitBands splits rows [0, height) into about n bands, each starting on a
+// multiple of align so that row groups are never split between bands.
+func splitBands(height, align, n int) []band {
+	if n < 1 {
+		n = 1
+	}
+
+	rows := (height + n - 1) / n
+	rows = ((rows + align - 1) / align) * align
+	if rows < align {
+		rows = align
+	}
+
+	bands := make([]band, 0, n)
+	for y := 0; y < height; y += rows {
+		end := y + rows
+		if end > height {
+			end = height
+		}
+		bands = append(bands, band{y, end})
+	}
+	return bands
+}
+
+// workerCount returns the number of goroutines to use for a Workers setting.
+func workerCount(workers int) int {
+	if workers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return workers
+}
+
+// workBands splits rows [0, height) into bands aligned to align for a pool of
+// workers. There are more bands than workers, which evens out uneven bands.
+func workBands(height, align, workers int) []band {
+	return splitBands(height, align, 4*workerCount(workers))
+}
+
+// runBands calls fn on each band from a pool of at most workers goroutines. fn
+// is given the index of the band, so results can be written to a per-band slot
+// and merged once runBands returns.
+func runBands(bands []band, workers int, fn func(i int, b band)) {
+	workers = workerCount(workers)
+	if workers > len(bands) {
+		workers = len(bands)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i, bands[i])
+			}
+		}()
+	}
+
+	for i := range bands {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
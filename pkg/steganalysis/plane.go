@@ -0,0 +1,274 @@
+package steganalysis
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// plane is a single colour channel of an image, stored row-major as native
+// samples.
+type plane struct {
+	width  int
+	height int
+	// depth is the number of bits per sample, 8 or 16.
+	depth int
+	pix   []uint16
+	// alpha is set for the alpha plane, which extractPlanes appends after
+	// the colour planes of images which are not opaque.
+	alpha bool
+}
+
+// at returns the sample at (x, y), relative to the top-left of the plane.
+func (p *plane) at(x, y int) int {
+	return int(p.pix[y*p.width+x])
+}
+
+// row returns the samples of row y.
+func (p *plane) row(y int) []uint16 {
+	return p.pix[y*p.width : (y+1)*p.width]
+}
+
+// levels returns the number of distinct sample values, 1 << depth.
+func (p *plane) levels() int {
+	return 1 << p.depth
+}
+
+func newPlanes(bounds image.Rectangle, channels int, depth int) []*plane {
+	planes := make([]*plane, channels)
+	for c := range planes {
+		planes[c] = &plane{
+			width:  bounds.Dx(),
+			height: bounds.Dy(),
+			depth:  depth,
+			pix:    make([]uint16, bounds.Dx()*bounds.Dy()),
+		}
+	}
+	return planes
+}
+
+// extractPlanes returns the colour planes of im, see extractColourPlanes,
+// followed by its alpha plane if it is not opaque. Payloads can be hidden in
+// alpha as well as in colour.
+func extractPlanes(im image.Image) []*plane {
+	planes := extractColourPlanes(im)
+	if a := extractAlpha(im); a != nil {
+		planes = append(planes, a)
+	}
+	return planes
+}
+
+// colourPlanes returns planes without the alpha plane.
+func colourPlanes(planes []*plane) []*plane {
+	if n := len(planes); n > 0 && planes[n-1].alpha {
+		return planes[:n-1]
+	}
+	return planes
+}
+
+// combine returns the mean of the estimates of the colour planes, or that of
+// the alpha plane if it is further from zero. The alpha plane is not averaged
+// in, as it is usually mostly opaque, which would dilute a payload in the
+// colour planes, and the other way round.
+func combine(planes []*plane, estimates []float64) float64 {
+	n := len(colourPlanes(planes))
+	mean := float64(0)
+	for _, e := range estimates[:n] {
+		mean += e
+	}
+	mean /= float64(n)
+
+	if n < len(estimates) && math.Abs(estimates[n]) > math.Abs(mean) {
+		return estimates[n]
+	}
+	return mean
+}
+
+// extractAlpha returns the alpha plane of im, in its native depth, or nil if
+// it has none or is opaque.
+func extractAlpha(im image.Image) *plane {
+	if o, ok := im.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return nil
+	}
+
+	bounds := im.Bounds()
+	depth := 8
+	switch im.(type) {
+	case *image.NRGBA64, *image.RGBA64:
+		depth = 16
+	case *image.Gray, *image.Gray16, *image.YCbCr, *image.CMYK:
+		return nil
+	}
+
+	a := newPlanes(bounds, 1, depth)[0]
+	a.alpha = true
+	i := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			switch m := im.(type) {
+			case *image.NRGBA:
+				a.pix[i] = uint16(m.Pix[m.PixOffset(x, y)+3])
+			case *image.RGBA:
+				a.pix[i] = uint16(m.Pix[m.PixOffset(x, y)+3])
+			case *image.NYCbCrA:
+				a.pix[i] = uint16(m.A[m.AOffset(x, y)])
+			default:
+				_, _, _, alpha := im.At(x, y).RGBA()
+				if depth == 8 {
+					alpha >>= 8
+				}
+				a.pix[i] = uint16(alpha)
+			}
+			i++
+		}
+	}
+	return a
+}
+
+// extractColourPlanes copies the colour channels of im out as their native samples,
+// as stored by the decoder rather than the 16-bit premultiplied values
+// returned by color.Color.RGBA. Grayscale images have one plane, all others
+// three (R, G, B). 16-bit images keep their 16-bit samples, so that the LSB
+// plane is the one an embedder would have written to; anything else is
+// converted to 8-bit non-premultiplied RGB.
+//
+// The common decoder output types are read straight from their Pix slices;
+// everything else goes through the image.Image interface.
+func extractColourPlanes(im image.Image) []*plane {
+	bounds := im.Bounds()
+
+	switch m := im.(type) {
+	case *image.NRGBA:
+		planes := newPlanes(bounds, 3, 8)
+		r, g, b := planes[0].pix, planes[1].pix, planes[2].pix
+		i := 0
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			row := m.Pix[m.PixOffset(bounds.Min.X, y):m.PixOffset(bounds.Max.X, y)]
+			for j := 0; j < len(row); j += 4 {
+				r[i] = uint16(row[j])
+				g[i] = uint16(row[j+1])
+				b[i] = uint16(row[j+2])
+				i++
+			}
+		}
+		return planes
+
+	case *image.RGBA:
+		planes := newPlanes(bounds, 3, 8)
+		r, g, b := planes[0].pix, planes[1].pix, planes[2].pix
+		i := 0
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			row := m.Pix[m.PixOffset(bounds.Min.X, y):m.PixOffset(bounds.Max.X, y)]
+			for j := 0; j < len(row); j += 4 {
+				if row[j+3] == 0xff {
+					// Decoders only produce RGBA for opaque images, where
+					// premultiplied and non-premultiplied samples are the same.
+					r[i] = uint16(row[j])
+					g[i] = uint16(row[j+1])
+					b[i] = uint16(row[j+2])
+				} else {
+					c := color.NRGBAModel.Convert(color.RGBA{R: row[j], G: row[j+1], B: row[j+2], A: row[j+3]}).(color.NRGBA)
+					r[i], g[i], b[i] = uint16(c.R), uint16(c.G), uint16(c.B)
+				}
+				i++
+			}
+		}
+		return planes
+
+	case *image.YCbCr:
+		planes := newPlanes(bounds, 3, 8)
+		r, g, b := planes[0].pix, planes[1].pix, planes[2].pix
+		i := 0
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				yi := m.YOffset(x, y)
+				ci := m.COffset(x, y)
+				cr, cg, cb := color.YCbCrToRGB(m.Y[yi], m.Cb[ci], m.Cr[ci])
+				r[i], g[i], b[i] = uint16(cr), uint16(cg), uint16(cb)
+				i++
+			}
+		}
+		return planes
+
+	case *image.Gray:
+		planes := newPlanes(bounds, 1, 8)
+		i := 0
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			row := m.Pix[m.PixOffset(bounds.Min.X, y):m.PixOffset(bounds.Max.X, y)]
+			for _, v := range row {
+				planes[0].pix[i] = uint16(v)
+				i++
+			}
+		}
+		return planes
+
+	case *image.Gray16:
+		planes := newPlanes(bounds, 1, 16)
+		i := 0
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				planes[0].pix[i] = m.Gray16At(x, y).Y
+				i++
+			}
+		}
+		return planes
+
+	case *image.NRGBA64:
+		planes := newPlanes(bounds, 3, 16)
+		i := 0
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := m.NRGBA64At(x, y)
+				planes[0].pix[i] = c.R
+				planes[1].pix[i] = c.G
+				planes[2].pix[i] = c.B
+				i++
+			}
+		}
+		return planes
+
+	case *image.RGBA64:
+		planes := newPlanes(bounds, 3, 16)
+		i := 0
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := m.RGBA64At(x, y)
+				planes[0].pix[i] = c.R
+				planes[1].pix[i] = c.G
+				planes[2].pix[i] = c.B
+				i++
+			}
+		}
+		return planes
+	}
+
+	planes := newPlanes(bounds, 3, 8)
+	i := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := nrgbaAt(im, x, y)
+			planes[0].pix[i] = uint16(c.R)
+			planes[1].pix[i] = uint16(c.G)
+			planes[2].pix[i] = uint16(c.B)
+			i++
+		}
+	}
+	return planes
+}
+
+// nrgbaAt returns the 8-bit sample values of the pixel at (x, y).
+func nrgbaAt(im image.Image, x, y int) color.NRGBA {
+	if m, ok := im.(*image.Paletted); ok {
+		// Palette entries are stored as the decoder read them.
+		switch p := m.Palette[m.ColorIndexAt(x, y)].(type) {
+		case color.NRGBA:
+			return p
+		case color.RGBA:
+			if p.A == 0xff {
+				return color.NRGBA{R: p.R, G: p.G, B: p.B, A: p.A}
+			}
+		}
+	}
+
+	return color.NRGBAModel.Convert(im.At(x, y)).(color.NRGBA)
+}
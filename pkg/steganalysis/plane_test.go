@@ -0,0 +1,87 @@
+package steganalysis
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// decodeTestFile decodes one of the images in testfiles.
+func decodeTestFile(t testing.TB, name string) image.Image {
+	t.Helper()
+
+	f, err := os.Open("../../testfiles/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	im, _, err := image.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return im
+}
+
+// embedLSB returns a copy of im with the low depth bit planes of a random
+// fraction rate of the colour samples in r replaced with random bits, as an
+// LSB embedder would. An empty r is the whole image.
+func embedLSB(im image.Image, rate float64, r image.Rectangle, depth int) *image.NRGBA {
+	rng := rand.New(rand.NewSource(1))
+	m := image.NewNRGBA(im.Bounds())
+	draw.Draw(m, m.Rect, im, im.Bounds().Min, draw.Src)
+	if r.Empty() {
+		r = m.Rect
+	}
+	samples := r.Dx() * r.Dy() * 3
+	mask := uint8(1)<<uint(depth) - 1
+
+	for _, s := range rng.Perm(samples)[:int(rate*float64(samples))] {
+		x, y := r.Min.X+s/3%r.Dx(), r.Min.Y+s/3/r.Dx()
+		i := m.PixOffset(x, y) + s%3
+		m.Pix[i] = m.Pix[i]&^mask | uint8(rng.Intn(256))&mask
+	}
+	return m
+}
+
+func TestExtractPlanes(t *testing.T) {
+	// The planes are relative to the image's bounds, not its origin.
+	im := image.NewNRGBA(image.Rect(2, 3, 5, 5))
+	for y := 3; y < 5; y++ {
+		for x := 2; x < 5; x++ {
+			im.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(10*y + x), B: 255, A: 255})
+		}
+	}
+
+	planes := extractPlanes(im)
+	if len(planes) != 3 {
+		t.Fatalf("%d planes, want 3", len(planes))
+	}
+	p := planes[1]
+	if p.width != 3 || p.height != 2 || p.depth != 8 {
+		t.Fatalf("plane is %dx%d with depth %d, want 3x2 with depth 8", p.width, p.height, p.depth)
+	}
+	for y := 0; y < p.height; y++ {
+		for x := 0; x < p.width; x++ {
+			if want := 10*(y+3) + x + 2; p.at(x, y) != want {
+				t.Errorf("at(%d, %d) = %d, want %d", x, y, p.at(x, y), want)
+			}
+		}
+	}
+
+	// Grayscale has a single plane, and 16-bit images keep their samples.
+	// Images which are not opaque have an alpha plane as well.
+	if planes := extractPlanes(image.NewGray(im.Rect)); len(planes) != 1 || planes[0].depth != 8 {
+		t.Errorf("gray: %d planes of depth %d, want 1 of depth 8", len(planes), planes[0].depth)
+	}
+	wide := image.NewNRGBA64(im.Rect)
+	wide.SetNRGBA64(2, 3, color.NRGBA64{R: 0x1234, A: 0xffff})
+	planes = extractPlanes(wide)
+	if len(planes) != 4 || planes[0].depth != 16 || planes[0].at(0, 0) != 0x1234 {
+		t.Errorf("16-bit: %d planes of depth %d starting %#x, want 4 of depth 16 starting 0x1234",
+			len(planes), planes[0].depth, planes[0].at(0, 0))
+	}
+}
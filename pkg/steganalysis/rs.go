@@ -0,0 +1,284 @@
+package steganalysis
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+// Mask is an RS flipping mask. Each entry is 1 where the flipping function is
+// applied to the pixel in that position of a group, and 0 where it is left
+// alone. The mask's shape is the shape of the pixel groups.
+type Mask [][]int
+
+// valid returns whether the mask is non-empty and rectangular.
+func (m Mask) valid() bool {
+	if len(m) == 0 || len(m[0]) == 0 {
+		return false
+	}
+	for _, row := range m {
+		if len(row) != len(m[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// DefaultRSMasks are the 2x2 checkerboard masks used by StegExpose.
+var DefaultRSMasks = []Mask{
+	{{0, 1}, {1, 0}},
+	{{1, 0}, {0, 1}},
+}
+
+// ParseMask parses a mask written as rows of 0s and 1s separated by "/", e.g.
+// "0110" for a 1x4 group or "01/10" for a 2x2 group.
+func ParseMask(s string) (Mask, error) {
+	rows := strings.Split(s, "/")
+	mask := make(Mask, len(rows))
+
+	for i, row := range rows {
+		if len(row) == 0 || len(row) != len(rows[0]) {
+			return nil, fmt.Errorf("Invalid mask: %q", s)
+		}
+
+		mask[i] = make([]int, len(row))
+		for j, c := range row {
+			switch c {
+			case '0':
+			case '1':
+				mask[i][j] = 1
+			default:
+				return nil, fmt.Errorf("Invalid mask: %q", s)
+			}
+		}
+	}
+
+	return mask, nil
+}
+
+// ParseMasks parses a comma-separated list of masks, each as for ParseMask.
+func ParseMasks(s string) ([]Mask, error) {
+	var masks []Mask
+	for _, m := range strings.Split(s, ",") {
+		mask, err := ParseMask(m)
+		if err != nil {
+			return nil, err
+		}
+		masks = append(masks, mask)
+	}
+	return masks, nil
+}
+
+// RS is Fridrich's Regular/Singular groups detector (Fridrich, Goljan and Du
+// 2001). Estimates from each mask are averaged.
+type RS struct {
+	Masks     []Mask
+	Threshold float64
+	// Workers is the number of goroutines group counting is split across.
+	// Zero means one per CPU.
+	Workers int
+}
+
+func (RS) Name() string { return "rs" }
+
+func (r RS) WithWorkers(workers int) Analyzer {
+	r.Workers = workers
+	return r
+}
+
+// Analyze runs RS analysis over im, estimating the message length in each
+// colour channel as a fraction of its samples.
+func (r RS) Analyze(im image.Image) (Verdict, error) {
+	masks := r.Masks
+	if len(masks) == 0 {
+		masks = DefaultRSMasks
+	}
+	for _, mask := range masks {
+		if !mask.valid() {
+			return Verdict{}, fmt.Errorf("Invalid RS mask: %v", mask)
+		}
+	}
+
+	planes := extractPlanes(im)
+	channels := make([]float64, len(planes))
+	for color, p := range planes {
+		for _, mask := range masks {
+			channels[color] += analyzeRS(p, mask, r.Workers)
+		}
+		channels[color] /= float64(len(masks))
+	}
+
+	probability := math.Min(math.Abs(combine(planes, channels)), 1)
+
+	return Verdict{
+		Detector:    r.Name(),
+		Stego:       probability > r.Threshold,
+		Probability: probability,
+		Channels:    channels,
+		Threshold:   r.Threshold,
+	}, nil
+}
+
+// rsCounts holds the number of regular and singular groups under a mask M and
+// its negation -M.
+type rsCounts struct {
+	rm, sm   float64
+	rnm, snm float64
+}
+
+// flipPos is the LSB flipping function F1, swapping 2i and 2i+1.
+func flipPos(x int) int {
+	return x ^ 1
+}
+
+// flipNeg is the shifted flipping function F-1, swapping 2i-1 and 2i.
+func flipNeg(x int) int {
+	return ((x + 1) ^ 1) - 1
+}
+
+// variation is the discrimination function f, the sum of absolute differences
+// between neighbouring pixels of a group.
+func variation(group []int) int {
+	sum := 0
+	for i := 1; i < len(group); i++ {
+		d := group[i] - group[i-1]
+		if d < 0 {
+			d = -d
+		}
+		sum += d
+	}
+	return sum
+}
+
+// add adds the counts of o to c.
+func (c *rsCounts) add(o rsCounts) {
+	c.rm += o.rm
+	c.sm += o.sm
+	c.rnm += o.rnm
+	c.snm += o.snm
+}
+
+// countGroups classifies every group of p under mask. If invert is set, the
+// LSBs of every sample are flipped first. Bands of groups are counted
+// concurrently on up to workers goroutines.
+func countGroups(p *plane, mask Mask, invert bool, workers int) rsCounts {
+	rows := len(mask)
+	cols := len(mask[0])
+
+	bands := workBands(p.height, rows, workers)
+	bandCounts := make([]rsCounts, len(bands))
+
+	runBands(bands, workers, func(i int, b band) {
+		var counts rsCounts
+
+		group := make([]int, rows*cols)
+		pos := make([]int, rows*cols)
+		neg := make([]int, rows*cols)
+
+		for y := b.y0; y+rows <= b.y1; y += rows {
+			for x := 0; x+cols <= p.width; x += cols {
+				i := 0
+				for my := 0; my < rows; my++ {
+					for mx := 0; mx < cols; mx++ {
+						v := p.at(x+mx, y+my)
+						if invert {
+							v = flipPos(v)
+						}
+
+						group[i] = v
+						pos[i] = v
+						neg[i] = v
+						if mask[my][mx] == 1 {
+							pos[i] = flipPos(v)
+							neg[i] = flipNeg(v)
+						}
+						i++
+					}
+				}
+
+				f := variation(group)
+
+				fPos := variation(pos)
+				if fPos > f {
+					counts.rm++
+				} else if fPos < f {
+					counts.sm++
+				}
+
+				fNeg := variation(neg)
+				if fNeg > f {
+					counts.rnm++
+				} else if fNeg < f {
+					counts.snm++
+				}
+			}
+		}
+
+		bandCounts[i] = counts
+	})
+
+	var counts rsCounts
+	for _, c := range bandCounts {
+		counts.add(c)
+	}
+	return counts
+}
+
+// analyzeRS returns the estimated message length in p, as a fraction of its
+// samples, using a single mask. Near full embedding the quadratic becomes
+// ill-conditioned, so the estimate is clamped to [0, 1].
+func analyzeRS(p *plane, mask Mask, workers int) float64 {
+	orig := countGroups(p, mask, false, workers)
+	flipped := countGroups(p, mask, true, workers)
+
+	d0 := orig.rm - orig.sm
+	d1 := flipped.rm - flipped.sm
+	dn0 := orig.rnm - orig.snm
+	dn1 := flipped.rnm - flipped.snm
+
+	// Solve 2(d1 + d0)z^2 + (dn0 - dn1 - d1 - 3d0)z + d0 - dn0 = 0. Only a
+	// root z <= 0 maps to a length in [0, 1), so prefer it, falling back to
+	// the root with the smallest magnitude.
+	a := 2 * (d1 + d0)
+	b := dn0 - dn1 - d1 - (3 * d0)
+	c := d0 - dn0
+
+	var z float64
+	if a == 0 {
+		if b == 0 {
+			return 0
+		}
+		z = -c / b
+	} else {
+		discriminant := (b * b) - (4 * a * c)
+		if discriminant < 0 {
+			discriminant = 0
+		}
+
+		posRoot := (-b + math.Sqrt(discriminant)) / (2 * a)
+		negRoot := (-b - math.Sqrt(discriminant)) / (2 * a)
+
+		switch {
+		case posRoot <= 0 && negRoot <= 0:
+			z = math.Max(posRoot, negRoot)
+		case posRoot <= 0:
+			z = posRoot
+		case negRoot <= 0:
+			z = negRoot
+		case math.Abs(posRoot) <= math.Abs(negRoot):
+			z = posRoot
+		default:
+			z = negRoot
+		}
+	}
+
+	if z == 0.5 {
+		return 1
+	}
+	return math.Max(0, math.Min(z/(z-0.5), 1))
+}
+
+func init() {
+	Register(RS{Masks: DefaultRSMasks, Threshold: 0.5})
+}
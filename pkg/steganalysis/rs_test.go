@@ -0,0 +1,92 @@
+package steganalysis
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+// testRates checks that a detector's estimate tracks the embedding rate, and
+// that every channel estimate stays a fraction.
+func testRates(t *testing.T, a Analyzer, tolerance float64) {
+	cover := decodeTestFile(t, "samplemedium.png")
+
+	for _, rate := range []float64{0, 0.5, 1} {
+		v, err := a.Analyze(embedLSB(cover, rate, image.Rectangle{}, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(v.Probability-rate) > tolerance {
+			t.Errorf("rate %.1f: %v, want about %.1f", rate, v, rate)
+		}
+		if v.Stego != (rate > 0) {
+			t.Errorf("rate %.1f: Stego = %v", rate, v.Stego)
+		}
+		for color, c := range v.Channels {
+			if c < 0 || c > 1 {
+				t.Errorf("rate %.1f: channel %d estimate %f is outside [0, 1]", rate, color, c)
+			}
+		}
+	}
+}
+
+func TestRSRates(t *testing.T) {
+	testRates(t, RS{Threshold: 0.2}, 0.05)
+}
+
+// Full embedding on a small image is where the RS quadratic degenerates.
+func TestRSFullEmbedding(t *testing.T) {
+	v, err := RS{Threshold: 0.5}.Analyze(embedLSB(decodeTestFile(t, "samplesmaller.png"), 1, image.Rectangle{}, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Stego {
+		t.Errorf("samplesmaller.png fully embedded: %v, want stego", v)
+	}
+	for color, c := range v.Channels {
+		if c < 0 || c > 1 {
+			t.Errorf("channel %d estimate %f is outside [0, 1]", color, c)
+		}
+	}
+}
+
+func TestParseMask(t *testing.T) {
+	m, err := ParseMask("0110")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 1 || len(m[0]) != 4 || m[0][1] != 1 || m[0][3] != 0 {
+		t.Errorf("ParseMask(0110) = %v", m)
+	}
+	m, err = ParseMask("01/10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 2 {
+		t.Errorf("ParseMask(01/10) = %v, want 2 rows", m)
+	}
+
+	for _, s := range []string{"", "01/1", "02", "01/", "0 1"} {
+		if m, err := ParseMask(s); err == nil {
+			t.Errorf("ParseMask(%q) = %v, want an error", s, m)
+		}
+	}
+
+	masks, err := ParseMasks("0110,01/10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(masks) != 2 || len(masks[0]) != 1 || len(masks[1]) != 2 {
+		t.Errorf("ParseMasks(0110,01/10) = %v", masks)
+	}
+	if masks, err := ParseMasks("01/10,"); err == nil {
+		t.Errorf("ParseMasks(01/10,) = %v, want an error", masks)
+	}
+}
+
+func TestRSInvalidMask(t *testing.T) {
+	_, err := RS{Masks: []Mask{{{0, 1}, {1}}}}.Analyze(decodeTestFile(t, "samplestego.png"))
+	if err == nil {
+		t.Error("Analyze with a ragged mask succeeded")
+	}
+}
@@ -1,185 +1,203 @@
 package steganalysis
 
 import (
-	"bytes"
 	"fmt"
 	"image"
-	imcolor "image/color"
 	"log"
 	"math"
 	"os"
-	"strings"
+	"sync"
 
 	"github.com/standardrhyme/stegsecure/pkg/interceptionfs"
-	"github.com/standardrhyme/stegsecure/pkg/sanitize"
 )
 
 // onlyLsb returns the LSB of x
-func onlyLsb(x uint32) uint32 {
+func onlyLsb(x int) int {
 	return x & 1
 }
 
 // exceptLsb returns all the bits other than the last one of x
-func exceptLsb(x uint32) uint32 {
-	return x << 1
+func exceptLsb(x int) int {
+	return x >> 1
 }
 
 // updateParams updates the provided parameters, depending on the LSB and MSBs
-func updateParams(u uint32, v uint32, params *[4]float64) {
-	uMsb := exceptLsb(u)
-	uLsb := onlyLsb(u)
-	vMsb := exceptLsb(v)
-	vLsb := onlyLsb(v)
-
-	// if only the LSB are different
-	if (uMsb == vMsb) && (uLsb != vMsb) {
-		params[0]++
-	}
-
+func updateParams(u int, v int, params *[4]float64) {
 	// if they are the same
 	if u == v {
 		params[3]++
+		return
 	}
 
-	if ((vLsb == 0) && (u < v)) || ((vLsb == 1) && (u > v)) {
-		params[1]++
+	// if only the LSB are different
+	if exceptLsb(u) == exceptLsb(v) {
+		params[0]++
 	}
 
-	if ((vLsb == 0) && (u > v)) || ((vLsb == 1) && (u < v)) {
+	// u != v, so the pair is in exactly one of X and Y
+	if (onlyLsb(v) == 0) == (u < v) {
+		params[1]++
+	} else {
 		params[2]++
 	}
 }
 
-func getColorComponent(color imcolor.Color, index int) uint32 {
-	r, g, b, a := color.RGBA()
-	switch index {
-	case 0:
-		return r
-	case 1:
-		return g
-	case 2:
-		return b
-	default:
-		return a
-	}
-}
-
-func analyzeSamplePairs(im image.Image, bounds image.Rectangle) (bool, float64) {
-	// Based off of https://github.com/b3dk7/StegExpose/blob/master/SamplePairs.java
-	avg := float64(0)
-	for color := 0; color < 3; color++ {
-		//                   W X Y Z
-		params := &[4]float64{0,0,0,0}
-		P := float64(0)
-
-		// Compute horizontal pairs
-		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-			for x := bounds.Min.X; x < bounds.Max.X - 1; x += 2 {
-				// Extract the color value for the pair pixels
-				u := getColorComponent(im.At(x, y), color)
-				v := getColorComponent(im.At(x+1, y), color)
-
-				updateParams(u, v, params)
-
-				P++
+// samplePairsParams accumulates the W, X, Y and Z pair counts of every plane in
+// a single pass over the rows, and returns them with the number of pairs P in
+// each plane. Bands of rows are counted concurrently on up to workers
+// goroutines and then merged.
+func samplePairsParams(planes []*plane, workers int) ([][4]float64, float64) {
+	width := planes[0].width
+	height := planes[0].height
+
+	// Vertical pairs start on even rows, so bands must too.
+	bands := workBands(height, 2, workers)
+	bandParams := make([][][4]float64, len(bands))
+
+	runBands(bands, workers, func(i int, b band) {
+		params := make([][4]float64, len(planes))
+
+		for y := b.y0; y < b.y1; y++ {
+			for c, p := range planes {
+				row := p.row(y)
+
+				// Compute horizontal pairs
+				for x := 0; x < width-1; x += 2 {
+					updateParams(int(row[x]), int(row[x+1]), &params[c])
+				}
+
+				// Compute vertical pairs
+				if y%2 == 0 && y < height-1 {
+					next := p.row(y + 1)
+					for x := 0; x < width; x++ {
+						updateParams(int(row[x]), int(next[x]), &params[c])
+					}
+				}
 			}
 		}
 
-		// Compute vertical pairs
-		for y := bounds.Min.Y; y < bounds.Max.Y - 1; y += 2 {
-			for x := bounds.Min.X; x < bounds.Max.X; x++ {
-				// Extract the color value for the pair pixels
-				u := getColorComponent(im.At(x, y), color)
-				v := getColorComponent(im.At(x, y+1), color)
+		bandParams[i] = params
+	})
 
-				updateParams(u, v, params)
-
-				P++
+	params := make([][4]float64, len(planes))
+	for _, bp := range bandParams {
+		for c := range params {
+			for k := range params[c] {
+				params[c][k] += bp[c][k]
 			}
 		}
+	}
 
-		// W, X, Y, Z := params
-		W := params[0]
-		X := params[1]
-		Y := params[2]
-		Z := params[3]
+	P := float64((width/2)*height + (height/2)*width)
+	return params, P
+}
 
-		a := (W+Z) / 2
-		b := (2*X) - P
-		c := Y-X
+// samplePairsEstimate returns the Sample Pairs estimate of the embedding rate
+// from one plane's pair counts.
+func samplePairsEstimate(params [4]float64, P float64) float64 {
+	// W, X, Y, Z := params
+	W := params[0]
+	X := params[1]
+	Y := params[2]
+	Z := params[3]
+
+	a := (W + Z) / 2
+	b := (2 * X) - P
+	c := Y - X
+
+	if a == 0 {
+		if b == 0 {
+			return 0
+		}
+		return c / b
+	}
 
-		var x float64
+	// Solve for the largest root
+	discriminant := math.Pow(b, 2) - (4 * a * c)
+	if discriminant < 0 {
+		return c / b
+	}
 
-		if a == 0 {
-			x = c / b
-		}
+	posRoot := ((-1 * b) + math.Sqrt(discriminant)) / (2.0 * a)
+	negRoot := ((-1 * b) - math.Sqrt(discriminant)) / (2.0 * a)
 
-		// Solve for the largest root
-		discriminant := math.Pow(b, 2) - (4*a*c)
-		if discriminant >= 0 {
-			posRoot := ((-1*b) + math.Sqrt(discriminant)) / (2.0*a)
-			negRoot := ((-1*b) - math.Sqrt(discriminant)) / (2.0*a)
+	if math.Abs(posRoot) <= math.Abs(negRoot) {
+		return posRoot
+	}
+	return negRoot
+}
 
-			if math.Abs(posRoot) <= math.Abs(negRoot) {
-				x = posRoot
-			} else {
-				x = negRoot
-			}
-		} else {
-			x = c / b
-		}
+// analyzeSamplePairs returns the Sample Pairs stego probability of the image,
+// along with the embedding-rate estimate for each colour channel, and alpha if
+// the image is not opaque. Samples are read natively, so 16-bit images are
+// analysed in their own LSB plane. Each estimate is taken as a magnitude before
+// they are combined, so that channels whose roots fall either side of zero do
+// not cancel each other out.
+func analyzeSamplePairs(im image.Image, workers int) (float64, []float64) {
+	// Based off of https://github.com/b3dk7/StegExpose/blob/master/SamplePairs.java
+	planes := extractPlanes(im)
+	params, P := samplePairsParams(planes, workers)
 
-		avg += x
+	channels := make([]float64, len(planes))
+	for color := range planes {
+		channels[color] = math.Abs(samplePairsEstimate(params[color], P))
 	}
 
-	average := avg / 3
-	probability := math.Min(math.Abs(float64(average)), 1)
+	probability := math.Min(combine(planes, channels), 1)
 
-	return probability > 0.5, probability
+	return probability, channels
 }
 
-func analyzeBytes(b []byte) bool {
-	r := bytes.NewReader(b)
-	im, _, err := image.Decode(r)
-	if err != nil {
-		fmt.Println(err)
-		return false
-	}
-	bounds := im.Bounds()
+// SamplePairs is the Sample Pairs detector (Dumitrescu 2002).
+type SamplePairs struct {
+	Threshold float64
+	// Workers is the number of goroutines pair counting is split across.
+	// Zero means one per CPU.
+	Workers int
+}
 
-	result, _ := analyzeSamplePairs(im, bounds)
-	return result
+func (SamplePairs) Name() string { return "samplepairs" }
+
+func (s SamplePairs) WithWorkers(workers int) Analyzer {
+	s.Workers = workers
+	return s
 }
 
-func AnalyzeGo(n interceptionfs.Node) {
-	fh, ok := n.(*interceptionfs.FileHandle)
-	if !ok {
-		// Not a file handle
-		return
-	}
+// Analyze runs Sample Pairs analysis over im.
+func (s SamplePairs) Analyze(im image.Image) (Verdict, error) {
+	probability, channels := analyzeSamplePairs(im, s.Workers)
+	return Verdict{
+		Detector:    s.Name(),
+		Stego:       probability > s.Threshold,
+		Probability: probability,
+		Channels:    channels,
+		Threshold:   s.Threshold,
+	}, nil
+}
 
-	fmt.Println()
-	fmt.Println("===========")
-	fmt.Println("FILE NAME: ", fh.Name())
+func init() {
+	Register(SamplePairs{Threshold: 0.5})
+}
 
-	if !strings.HasSuffix(fh.Name(), ".png") {
-		fh.File.Release()
-		return
-	}
+var (
+	defaultOnce     sync.Once
+	defaultNotifier func(interceptionfs.Node)
+	defaultErr      error
+)
 
-	data, err := fh.InternalReadAll()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+// AnalyzeGo is the default notifier, running DefaultDetectors. The notifier
+// is built on the first call, once every detector has been registered, and
+// reused after.
+func AnalyzeGo(n interceptionfs.Node) {
+	defaultOnce.Do(func() {
+		defaultNotifier, defaultErr = NewNotifier(Config{})
+	})
+	if defaultErr != nil {
+		fmt.Fprintln(os.Stderr, defaultErr)
 		return
 	}
 
-	if analyzeBytes(data) {
-		fmt.Println("SANITIZE")
-		cleaned := sanitize.SanitizeBytes(data, "png")
-		fh.InternalOverwrite(cleaned)
-	}
-
-	fh.File.Release()
+	defaultNotifier(n)
 }
 
 func main() {
@@ -203,12 +221,13 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	bounds := im.Bounds()
-
-	result, probability := analyzeSamplePairs(im, bounds)
+	verdict, err := SamplePairs{Threshold: 0.5}.Analyze(im)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	println("Probability of being a stego image:", probability)
-	if result {
+	println("Probability of being a stego image:", verdict.Probability)
+	if verdict.Stego {
 		fmt.Println("This is probably a stego image.")
 	} else {
 		fmt.Println("This is probably not a stego image.")
@@ -0,0 +1,170 @@
+package steganalysis
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/standardrhyme/stegsecure/pkg/sanitize"
+
+	_ "image/png"
+)
+
+func TestSamplePairsRegression(t *testing.T) {
+	tests := []struct {
+		file        string
+		probability float64
+		channels    []float64
+		stego       bool
+	}{
+		{"samplestego.png", 0.6288265814, []float64{0.4505100448, 0.8875826027, 0.5483870968}, true},
+		{"samplesmall.png", 0.6588054510, []float64{0.9376254944, 0.7614835193, 0.2773073393}, true},
+		{"samplesmaller.png", 0.0011628152, []float64{0.0017600468, 0.0012185920, 0.0005098067}, false},
+	}
+
+	for _, tt := range tests {
+		probability, channels := analyzeSamplePairs(decodeTestFile(t, tt.file), 0)
+
+		if stego := probability > 0.5; stego != tt.stego {
+			t.Errorf("%s: stego = %v, want %v", tt.file, stego, tt.stego)
+		}
+
+		if math.Abs(probability-tt.probability) > 1e-9 {
+			t.Errorf("%s: probability = %.10f, want %.10f", tt.file, probability, tt.probability)
+		}
+
+		if len(channels) != len(tt.channels) {
+			t.Fatalf("%s: got %d channels, want %d", tt.file, len(channels), len(tt.channels))
+		}
+		for i := range channels {
+			if math.Abs(channels[i]-tt.channels[i]) > 1e-9 {
+				t.Errorf("%s: channel %d = %.10f, want %.10f", tt.file, i, channels[i], tt.channels[i])
+			}
+		}
+	}
+}
+
+func TestParallelMatchesSerial(t *testing.T) {
+	im := decodeTestFile(t, "samplesmaller.png")
+
+	for _, name := range []string{"samplepairs", "rs", "ws"} {
+		a, err := Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		serial, _ := a.(Parallel).WithWorkers(1).Analyze(im)
+		parallel, _ := a.(Parallel).WithWorkers(7).Analyze(im)
+
+		for i := range serial.Channels {
+			if math.Abs(serial.Channels[i]-parallel.Channels[i]) > 1e-9 {
+				t.Errorf("%s channel %d: serial %.10f, parallel %.10f", name, i, serial.Channels[i], parallel.Channels[i])
+			}
+		}
+	}
+}
+
+func TestExceptLsb(t *testing.T) {
+	for _, tt := range []struct{ in, out int }{{0, 0}, {1, 0}, {2, 1}, {3, 1}, {255, 127}} {
+		if got := exceptLsb(tt.in); got != tt.out {
+			t.Errorf("exceptLsb(%d) = %d, want %d", tt.in, got, tt.out)
+		}
+	}
+}
+
+// embed replaces the LSB of a random fraction rate of the colour samples of im
+// with random bits.
+func embed(im *image.NRGBA64, rate float64) {
+	rng := rand.New(rand.NewSource(1))
+	samples := im.Rect.Dx() * im.Rect.Dy() * 3
+
+	for _, s := range rng.Perm(samples)[:int(rate*float64(samples))] {
+		// Each pixel is 8 bytes: 16-bit big-endian R, G, B, A.
+		i := (s/3)*8 + (s%3)*2 + 1
+		im.Pix[i] = im.Pix[i]&^1 | uint8(rng.Intn(2))
+	}
+}
+
+func TestSamplePairs16Bit(t *testing.T) {
+	src := decodeTestFile(t, "samplesmaller.png")
+
+	// Widening repeats each 8-bit sample in the low byte, so the 16-bit LSB
+	// plane carries the image's own LSB statistics rather than noise, which
+	// would look like a full embedding.
+	im := image.NewNRGBA64(src.Bounds())
+	draw.Draw(im, im.Rect, src, src.Bounds().Min, draw.Src)
+
+	clean, _ := analyzeSamplePairs(im, 0)
+	embed(im, 0.5)
+	stego, _ := analyzeSamplePairs(im, 0)
+
+	if clean > 0.1 || stego < 0.4 || stego > 0.6 {
+		t.Errorf("16-bit stego probability = %f, want about 0.5 (clean %f)", stego, clean)
+	}
+}
+
+func TestExtractPlanesNative(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 2, 1))
+	gray.Pix = []uint8{7, 200}
+	planes := extractPlanes(gray)
+	if len(planes) != 1 || planes[0].pix[0] != 7 || planes[0].pix[1] != 200 {
+		t.Errorf("Gray planes = %v, want one plane [7 200]", planes)
+	}
+
+	// Translucent NRGBA samples must not be premultiplied.
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	nrgba.SetNRGBA(0, 0, color.NRGBA{R: 201, G: 3, B: 77, A: 128})
+	planes = extractPlanes(nrgba)
+	if planes[0].pix[0] != 201 || planes[1].pix[0] != 3 || planes[2].pix[0] != 77 {
+		t.Errorf("NRGBA samples = %d %d %d, want 201 3 77", planes[0].pix[0], planes[1].pix[0], planes[2].pix[0])
+	}
+	if len(planes) != 4 || !planes[3].alpha || planes[3].pix[0] != 128 {
+		t.Errorf("NRGBA has %d planes, want an alpha plane of 128 after the colour planes", len(planes))
+	}
+
+	paletted := image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.NRGBA{}, color.NRGBA{R: 9, G: 10, B: 11, A: 255}})
+	paletted.SetColorIndex(0, 0, 1)
+	planes = extractPlanes(paletted)
+	if planes[0].pix[0] != 9 || planes[1].pix[0] != 10 || planes[2].pix[0] != 11 {
+		t.Errorf("Paletted samples = %d %d %d, want 9 10 11", planes[0].pix[0], planes[1].pix[0], planes[2].pix[0])
+	}
+
+	nrgba64 := image.NewNRGBA64(image.Rect(0, 0, 1, 1))
+	nrgba64.SetNRGBA64(0, 0, color.NRGBA64{R: 0x1235, G: 2, B: 0xffff, A: 0xffff})
+	planes = extractPlanes(nrgba64)
+	if planes[0].depth != 16 || planes[0].pix[0] != 0x1235 || planes[1].pix[0] != 2 {
+		t.Errorf("NRGBA64 plane depth %d samples %d %d, want 16 4661 2", planes[0].depth, planes[0].pix[0], planes[1].pix[0])
+	}
+}
+
+func TestAlphaPayload(t *testing.T) {
+	src := decodeTestFile(t, "samplesmaller.png")
+	im := image.NewNRGBA(src.Bounds())
+	draw.Draw(im, im.Rect, src, src.Bounds().Min, draw.Src)
+
+	if len(extractPlanes(im)) != 3 {
+		t.Fatalf("opaque image has an alpha plane")
+	}
+	clean, _ := analyzeSamplePairs(im, 0)
+
+	// Hide a payload in the LSB of alpha, leaving the colour clean.
+	rng := rand.New(rand.NewSource(3))
+	for i := 3; i < len(im.Pix); i += 4 {
+		im.Pix[i] = 0xfe | uint8(rng.Intn(2))
+	}
+	stego, channels := analyzeSamplePairs(im, 0)
+	if len(channels) != 4 || stego < 0.5 {
+		t.Errorf("alpha payload: probability %f, channels %v (clean %f)", stego, channels, clean)
+	}
+
+	sanitized, err := sanitize.DefaultPolicy.SanitizeImage(im)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sanitized.(*image.NRGBA).Opaque() {
+		t.Errorf("alpha payload not sanitized")
+	}
+}
@@ -0,0 +1,64 @@
+package steganalysis
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/standardrhyme/stegsecure/pkg/metadata"
+)
+
+// Thumbnail compares the EXIF thumbnail of a file with the image itself.
+// Thumbnails are a known hiding place, since viewers rarely show them, and
+// also leak what an image showed before it was edited. The thumbnail is
+// decoded, the image scaled down to match, and the two compared with
+// metadata.ThumbnailDistance, a perceptual distance which ignores
+// recompression but not a change of content.
+//
+// The probability is the distance scaled so that metadata.MaxThumbnailDistance
+// maps to 0.5, and Channels holds the distance itself. Files without a
+// thumbnail are not flagged.
+type Thumbnail struct {
+	Threshold float64
+}
+
+func (Thumbnail) Name() string { return "thumbnail" }
+
+func (t Thumbnail) Analyze(im image.Image) (Verdict, error) {
+	return Verdict{}, fmt.Errorf("Detector %s needs the encoded file", t.Name())
+}
+
+func (t Thumbnail) AnalyzeBytes(data []byte, format string) (Verdict, error) {
+	m, err := metadata.Extract(data, format)
+	if err != nil {
+		return Verdict{}, err
+	}
+	if m.Thumbnail == nil {
+		return Verdict{Detector: t.Name(), Threshold: t.Threshold}, nil
+	}
+
+	im, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Verdict{}, err
+	}
+	distance, err := metadata.ThumbnailDistance(m.Thumbnail, im)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	probability := math.Min(distance/(2*metadata.MaxThumbnailDistance), 1)
+	return Verdict{
+		Detector:    t.Name(),
+		Stego:       probability > t.Threshold,
+		Probability: probability,
+		Threshold:   t.Threshold,
+		Channels:    []float64{distance},
+		Width:       im.Bounds().Dx(),
+		Height:      im.Bounds().Dy(),
+	}, nil
+}
+
+func init() {
+	Register(Thumbnail{Threshold: 0.5})
+}
@@ -0,0 +1,107 @@
+package steganalysis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"testing"
+
+	"github.com/standardrhyme/stegsecure/pkg/sanitize"
+)
+
+// gradient returns an image getting brighter from left to right, or from
+// right to left if reversed.
+func gradient(w, h int, reversed bool) image.Image {
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := x * 255 / w
+			if reversed {
+				v = 255 - v
+			}
+			m.SetRGBA(x, y, color.RGBA{uint8(v), uint8(v / 2), 64, 255})
+		}
+	}
+	return m
+}
+
+// withThumbnail returns a JPEG of m with an EXIF thumbnail of thumb.
+func withThumbnail(t *testing.T, m, thumb image.Image) []byte {
+	var main, small bytes.Buffer
+	if err := jpeg.Encode(&main, m, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&small, thumb, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// An empty IFD0, then IFD1 pointing at the thumbnail.
+	exif := []byte("II*\x00\x08\x00\x00\x00\x00\x00\x0e\x00\x00\x00\x02\x00")
+	entry := func(tag uint16, value uint32) {
+		var e [12]byte
+		binary.LittleEndian.PutUint16(e[0:], tag)
+		binary.LittleEndian.PutUint16(e[2:], 4)
+		binary.LittleEndian.PutUint32(e[4:], 1)
+		binary.LittleEndian.PutUint32(e[8:], value)
+		exif = append(exif, e[:]...)
+	}
+	entry(513, 44)
+	entry(514, uint32(small.Len()))
+	exif = append(exif, 0, 0, 0, 0)
+	exif = append(exif, small.Bytes()...)
+
+	segment := append([]byte("\xff\xe1\x00\x00Exif\x00\x00"), exif...)
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(segment)-2))
+
+	data := append([]byte(nil), main.Bytes()[:2]...)
+	data = append(data, segment...)
+	return append(data, main.Bytes()[2:]...)
+}
+
+func TestThumbnailDetector(t *testing.T) {
+	sample, err := os.ReadFile("../../testfiles/sample.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plain bytes.Buffer
+	if err := jpeg.Encode(&plain, gradient(320, 200, false), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, test := range map[string]struct {
+		data  []byte
+		stego bool
+	}{
+		"sample":   {sample, false},
+		"matching": {withThumbnail(t, gradient(320, 200, false), gradient(160, 100, false)), false},
+		"reversed": {withThumbnail(t, gradient(320, 200, false), gradient(160, 100, true)), true},
+		"none":     {plain.Bytes(), false},
+	} {
+		verdict, err := RunBytes(Thumbnail{Threshold: 0.5}, test.data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if verdict.Stego != test.stego {
+			t.Errorf("%s: got %v, want stego=%v", name, verdict, test.stego)
+		}
+
+		if !test.stego {
+			continue
+		}
+		// A regenerated thumbnail matches the image.
+		cleaned, err := sanitize.DefaultPolicy.SanitizeThumbnail(test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verdict, err = RunBytes(Thumbnail{Threshold: 0.5}, cleaned)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if verdict.Stego || len(verdict.Channels) == 0 {
+			t.Errorf("%s: got %v after sanitizing, want a matching thumbnail", name, verdict)
+		}
+	}
+}
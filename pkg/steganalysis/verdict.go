@@ -0,0 +1,61 @@
+package steganalysis
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Verdict is the structured result of running a single detector over an image.
+type Verdict struct {
+	// Detector is the registered name of the detector.
+	Detector string
+	// Stego is whether Probability exceeded Threshold.
+	Stego       bool
+	Probability float64
+	Threshold   float64
+	// Channels holds the detector's embedding-rate estimate for each colour
+	// channel, where it produces one.
+	Channels []float64
+	// Intervals holds a confidence interval around each entry of Channels,
+	// for detectors which estimate one.
+	Intervals []Interval
+	// PayloadBytes is the estimated size of a sequentially embedded payload,
+	// for detectors which locate one, or 0.
+	PayloadBytes int
+	// Inconclusive is set by detectors which had too little data to decide.
+	// Stego is then false and Probability 0, but neither is evidence that
+	// the image is clean.
+	Inconclusive bool
+
+	// Format is the decoded image format, e.g. "png".
+	Format string
+	Width  int
+	Height int
+
+	// Elapsed is how long the detector took to run.
+	Elapsed time.Duration
+}
+
+func (v Verdict) String() string {
+	channels := make([]string, len(v.Channels))
+	for i, c := range v.Channels {
+		channels[i] = fmt.Sprintf("%.4f", c)
+		if i < len(v.Intervals) {
+			channels[i] += fmt.Sprintf("(%.4f..%.4f)", v.Intervals[i].Low, v.Intervals[i].High)
+		}
+	}
+
+	s := fmt.Sprintf("%s: stego=%t probability=%.4f threshold=%.2f channels=[%s] format=%s size=%dx%d elapsed=%s",
+		v.Detector, v.Stego, v.Probability, v.Threshold, strings.Join(channels, " "),
+		v.Format, v.Width, v.Height, v.Elapsed)
+
+	if v.Inconclusive {
+		s += " inconclusive"
+	}
+	if v.PayloadBytes > 0 {
+		s += fmt.Sprintf(" payload=%dB", v.PayloadBytes)
+	}
+
+	return s
+}
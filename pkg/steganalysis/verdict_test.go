@@ -0,0 +1,81 @@
+package steganalysis
+
+import (
+	"errors"
+	"image"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rawDetector is a ByteAnalyzer which reports nothing but the format it was
+// handed, leaving the rest to RunBytes.
+type rawDetector struct{}
+
+func (rawDetector) Name() string { return "raw" }
+
+func (rawDetector) Analyze(im image.Image) (Verdict, error) {
+	return Verdict{}, errors.New("Raw detector needs the encoded bytes")
+}
+
+func (rawDetector) AnalyzeBytes(data []byte, format string) (Verdict, error) {
+	return Verdict{Format: format}, nil
+}
+
+func TestRunBytes(t *testing.T) {
+	data, err := os.ReadFile("../../testfiles/samplesmaller.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rawDetector is handed the raw bytes, so the size comes from the header.
+	for _, a := range []Analyzer{SamplePairs{Threshold: 0.5}, rawDetector{}} {
+		v, err := RunBytes(a, data)
+		if err != nil {
+			t.Fatalf("%s: %v", a.Name(), err)
+		}
+		if v.Detector != a.Name() || v.Format != "png" || v.Elapsed <= 0 {
+			t.Errorf("%s: %v", a.Name(), v)
+		}
+		if v.Width != 624 || v.Height != 400 {
+			t.Errorf("%s: size %dx%d, want 624x400", a.Name(), v.Width, v.Height)
+		}
+	}
+
+	for _, a := range []Analyzer{SamplePairs{}, rawDetector{}} {
+		v, err := RunBytes(a, []byte("not an image"))
+		if err == nil {
+			t.Errorf("%s: RunBytes on garbage = %v, want an error", a.Name(), v)
+		}
+		if v.Detector != a.Name() {
+			t.Errorf("%s: failed verdict is for %q", a.Name(), v.Detector)
+		}
+	}
+}
+
+func TestVerdictString(t *testing.T) {
+	v := Verdict{
+		Detector:     "samplepairs",
+		Stego:        true,
+		Probability:  0.5,
+		Threshold:    0.25,
+		Channels:     []float64{0.5, 0.25},
+		Intervals:    []Interval{{0.4, 0.6}},
+		PayloadBytes: 12,
+		Format:       "png",
+		Width:        3,
+		Height:       2,
+		Elapsed:      time.Millisecond,
+	}
+	want := "samplepairs: stego=true probability=0.5000 threshold=0.25 channels=[0.5000(0.4000..0.6000) 0.2500] " +
+		"format=png size=3x2 elapsed=1ms payload=12B"
+	if got := v.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	v = Verdict{Detector: "chisquare", Inconclusive: true}
+	if got := v.String(); !strings.HasSuffix(got, " inconclusive") {
+		t.Errorf("inconclusive verdict printed as %s", got)
+	}
+}
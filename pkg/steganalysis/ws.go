@@ -0,0 +1,171 @@
+package steganalysis
+
+import (
+	"image"
+	"math"
+)
+
+// WS is the Weighted Stego-image embedding-rate estimator (Fridrich and Goljan
+// 2004), with the improved predictor, weights and bias correction of Ker and
+// Böhme (2008).
+type WS struct {
+	Threshold float64
+	// Workers is the number of goroutines the estimate is split across. Zero
+	// means one per CPU.
+	Workers int
+}
+
+// Interval is a confidence interval around an estimate.
+type Interval struct {
+	Low  float64
+	High float64
+}
+
+func (WS) Name() string { return "ws" }
+
+func (ws WS) WithWorkers(workers int) Analyzer {
+	ws.Workers = workers
+	return ws
+}
+
+// Analyze estimates the embedding rate of each colour channel of im, and alpha
+// if it is not opaque, as a fraction of its samples, along with a 95%
+// confidence interval. Estimates and intervals are clamped to [0, 1], which
+// sampling error can otherwise overshoot at rates near 0 or 1.
+func (ws WS) Analyze(im image.Image) (Verdict, error) {
+	planes := extractPlanes(im)
+	channels := make([]float64, len(planes))
+	intervals := make([]Interval, len(planes))
+
+	for color, p := range planes {
+		estimate, stderr := analyzeWS(p, ws.Workers)
+
+		channels[color] = clampRate(estimate)
+		intervals[color] = Interval{
+			Low:  clampRate(estimate - 1.96*stderr),
+			High: clampRate(estimate + 1.96*stderr),
+		}
+	}
+
+	probability := clampRate(combine(planes, channels))
+
+	return Verdict{
+		Detector:    ws.Name(),
+		Stego:       probability > ws.Threshold,
+		Probability: probability,
+		Threshold:   ws.Threshold,
+		Channels:    channels,
+		Intervals:   intervals,
+	}, nil
+}
+
+// clampRate clamps an embedding-rate estimate to [0, 1].
+func clampRate(rate float64) float64 {
+	return math.Min(math.Max(rate, 0), 1)
+}
+
+// wsTerms returns, for the sample at (x, y), its WS weight, its contribution
+// (s - s̄)(s - F(s)) to the estimate and its contribution (s - s̄)(F(s̄) - F(s))
+// to the bias term, where s̄ is s with its LSB flipped and F is the Ker-Böhme
+// predictor
+//
+//	-1  2 -1
+//	 2  0  2  / 4
+//	-1  2 -1
+func wsTerms(p *plane, x, y int) (float64, float64, float64) {
+	above := p.row(y - 1)[x-1 : x+2]
+	row := p.row(y)[x-1 : x+2]
+	below := p.row(y + 1)[x-1 : x+2]
+
+	s := int(row[1])
+	direct := [4]int{int(above[1]), int(row[0]), int(row[2]), int(below[1])}
+	diagonal := [4]int{int(above[0]), int(above[2]), int(below[0]), int(below[2])}
+
+	// Weight by the local variance of the four direct neighbours, so that
+	// flat regions, where prediction is accurate, count more.
+	mean := float64(direct[0]+direct[1]+direct[2]+direct[3]) / 4
+	variance := float64(0)
+	for _, v := range direct {
+		variance += (float64(v) - mean) * (float64(v) - mean)
+	}
+	variance /= 4
+	w := 1 / (5 + variance)
+
+	// F(s), and F(s̄) - F(s), where flipping v changes it by 1 - 2(v & 1).
+	predicted, change := 0, 0
+	for i := range direct {
+		predicted += 2*direct[i] - diagonal[i]
+		change += 2*(1-2*(direct[i]&1)) - (1 - 2*(diagonal[i]&1))
+	}
+
+	// s - s̄
+	diff := float64(s - flipPos(s))
+
+	return w, diff * (float64(s) - float64(predicted)/4), diff * float64(change) / 4
+}
+
+// wsSums are the running sums of the WS estimate over a band of rows.
+type wsSums struct {
+	total, beta, bias, variance float64
+}
+
+// sumWS runs fn over the interior rows of p, split into bands on up to workers
+// goroutines, and returns the sum of its results.
+func sumWS(p *plane, workers int, fn func(x, y int, sums *wsSums)) wsSums {
+	bands := workBands(p.height, 1, workers)
+	bandSums := make([]wsSums, len(bands))
+
+	runBands(bands, workers, func(i int, b band) {
+		var sums wsSums
+		for y := maxInt(b.y0, 1); y < b.y1 && y < p.height-1; y++ {
+			for x := 1; x < p.width-1; x++ {
+				fn(x, y, &sums)
+			}
+		}
+		bandSums[i] = sums
+	})
+
+	var sums wsSums
+	for _, s := range bandSums {
+		sums.total += s.total
+		sums.beta += s.beta
+		sums.bias += s.bias
+		sums.variance += s.variance
+	}
+	return sums
+}
+
+// analyzeWS returns the WS estimate of the embedding rate of p, and its
+// standard error.
+func analyzeWS(p *plane, workers int) (float64, float64) {
+	if p.width < 3 || p.height < 3 {
+		return 0, 0
+	}
+
+	sums := sumWS(p, workers, func(x, y int, sums *wsSums) {
+		w, term, b := wsTerms(p, x, y)
+		sums.total += w
+		sums.beta += w * term
+		sums.bias += w * b
+	})
+	total := sums.total
+	beta := sums.beta / total
+	bias := sums.bias / total
+
+	// The predictor itself sees stego neighbours, whose flipped LSBs correlate
+	// with the centre's and bias the estimate in proportion to it. Correct for
+	// the bias term measured on the image.
+	estimate := 2 * beta / (1 - bias)
+
+	sums = sumWS(p, workers, func(x, y int, sums *wsSums) {
+		w, term, _ := wsTerms(p, x, y)
+		d := 2*term/(1-bias) - estimate
+		sums.variance += (w / total) * (w / total) * d * d
+	})
+
+	return estimate, math.Sqrt(sums.variance)
+}
+
+func init() {
+	Register(WS{Threshold: 0.5})
+}
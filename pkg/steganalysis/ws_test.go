@@ -0,0 +1,75 @@
+package steganalysis
+
+import (
+	"image"
+	"testing"
+)
+
+func TestWSRates(t *testing.T) {
+	testRates(t, WS{Threshold: 0.2}, 0.05)
+}
+
+func TestWSClean(t *testing.T) {
+	v, err := WS{Threshold: 0.2}.Analyze(decodeTestFile(t, "samplemedium.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Stego || v.Probability > 0.01 {
+		t.Errorf("samplemedium.png: %v, want about 0", v)
+	}
+	for color, c := range v.Channels {
+		if c > 0.01 {
+			t.Errorf("channel %d estimate %f, want about 0", color, c)
+		}
+	}
+}
+
+func TestWSIntervals(t *testing.T) {
+	cover := decodeTestFile(t, "samplesmaller.png")
+
+	for _, rate := range []float64{0.25, 0.5, 0.75} {
+		v, err := WS{Threshold: 0.2}.Analyze(embedLSB(cover, rate, image.Rectangle{}, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(v.Intervals) != len(v.Channels) {
+			t.Fatalf("rate %.2f: %d intervals for %d channels", rate, len(v.Intervals), len(v.Channels))
+		}
+		for color, in := range v.Intervals {
+			if in.Low > v.Channels[color] || in.High < v.Channels[color] {
+				t.Errorf("rate %.2f: channel %d estimate %f is outside its interval %v", rate, color, v.Channels[color], in)
+			}
+			if in.Low > rate || in.High < rate {
+				t.Errorf("rate %.2f: channel %d interval %v misses the rate", rate, color, in)
+			}
+		}
+	}
+}
+
+// Near full embedding, and in images too small to estimate, sampling error
+// overshoots [0, 1], which the estimates and intervals are clamped to.
+func TestWSClamped(t *testing.T) {
+	for _, test := range []struct {
+		file string
+		rate float64
+	}{
+		{"samplemedium.png", 1},
+		{"samplesmaller.png", 1},
+		{"samplestego.png", 0},
+		{"samplestego.png", 1},
+	} {
+		v, err := WS{Threshold: 0.2}.Analyze(embedLSB(decodeTestFile(t, test.file), test.rate, image.Rectangle{}, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Probability < 0 || v.Probability > 1 {
+			t.Errorf("%s at rate %.0f: probability %f is outside [0, 1]", test.file, test.rate, v.Probability)
+		}
+		for color, c := range v.Channels {
+			in := v.Intervals[color]
+			if c < 0 || c > 1 || in.Low < 0 || in.High > 1 {
+				t.Errorf("%s at rate %.0f: channel %d estimate %f(%v) is outside [0, 1]", test.file, test.rate, color, c, in)
+			}
+		}
+	}
+}
@@ -0,0 +1,452 @@
+// Package tiffpage splits multi-page TIFFs into their pages and joins pages
+// back into one file. golang.org/x/image/tiff only decodes the first image
+// file directory (IFD) of a file and only encodes a single page, so this is
+// what lets every page be analyzed and sanitized.
+package tiffpage
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Tags whose values are offsets into the file.
+const (
+	tStripOffsets    = 273
+	tStripByteCounts = 279
+	tTileOffsets     = 324
+	tTileByteCounts  = 325
+	tSubIFDs         = 330
+	tExifIFD         = 34665
+	tGPSIFD          = 34853
+)
+
+// typeSizes are the sizes of the TIFF field types, from section 2 of the TIFF
+// 6.0 specification.
+var typeSizes = map[uint16]int{
+	1:  1, // BYTE
+	2:  1, // ASCII
+	3:  2, // SHORT
+	4:  4, // LONG
+	5:  8, // RATIONAL
+	6:  1, // SBYTE
+	7:  1, // UNDEFINED
+	8:  2, // SSHORT
+	9:  4, // SLONG
+	10: 8, // SRATIONAL
+	11: 4, // FLOAT
+	12: 8, // DOUBLE
+}
+
+// byteOrder returns the byte order of a TIFF file from its header.
+func byteOrder(data []byte) (binary.ByteOrder, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("Not a TIFF file")
+	}
+	switch string(data[:4]) {
+	case "II*\x00":
+		return binary.LittleEndian, nil
+	case "MM\x00*":
+		return binary.BigEndian, nil
+	}
+	return nil, fmt.Errorf("Not a TIFF file")
+}
+
+// Offsets returns the offsets of the IFDs of data, one per page, in order.
+func Offsets(data []byte) ([]uint32, error) {
+	order, err := byteOrder(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var offsets []uint32
+	seen := make(map[uint32]bool)
+	for offset := order.Uint32(data[4:8]); offset != 0; {
+		if seen[offset] {
+			return nil, fmt.Errorf("TIFF IFDs form a loop")
+		}
+		seen[offset] = true
+
+		if int64(offset)+2 > int64(len(data)) {
+			return nil, fmt.Errorf("Invalid TIFF IFD offset %d", offset)
+		}
+		n := int64(order.Uint16(data[offset:]))
+		next := int64(offset) + 2 + 12*n
+		if next+4 > int64(len(data)) {
+			return nil, fmt.Errorf("Invalid TIFF IFD at offset %d", offset)
+		}
+
+		offsets = append(offsets, offset)
+		offset = order.Uint32(data[next:])
+	}
+
+	if len(offsets) == 0 {
+		return nil, fmt.Errorf("TIFF has no pages")
+	}
+	return offsets, nil
+}
+
+// Split returns every page of data as a TIFF of its own, holding only the IFD
+// of that page, the values it points to and its strips or tiles. IFDs nested
+// through the SubIFDs, Exif and GPS tags are dropped.
+func Split(data []byte) ([][]byte, error) {
+	offsets, err := Offsets(data)
+	if err != nil {
+		return nil, err
+	}
+
+	order, _ := byteOrder(data)
+	pages := make([][]byte, len(offsets))
+	for i, offset := range offsets {
+		if pages[i], err = page(data, order, int(offset)); err != nil {
+			return nil, fmt.Errorf("TIFF page %d: %v", i, err)
+		}
+	}
+	return pages, nil
+}
+
+// page copies the IFD at ifd in data, and everything it refers to, into a
+// TIFF of its own, with the offsets relocated to match.
+func page(data []byte, order binary.ByteOrder, ifd int) ([]byte, error) {
+	out := append([]byte(nil), data[:8]...)
+	// align pads out to a word boundary, on which values and IFDs start.
+	align := func() {
+		if len(out)%2 != 0 {
+			out = append(out, 0)
+		}
+	}
+
+	n := int(order.Uint16(data[ifd:]))
+	var entries []byte
+	// The strip or tile offsets and byte counts of the page, as they are in
+	// data. The offsets are rewritten in the entry copied to entries at
+	// offsetsEntry, or, if they did not fit in it, in out at offsetsAt.
+	var offsets, counts []byte
+	var offsetsType, countsType uint16
+	offsetsEntry, offsetsAt := -1, -1
+	for e := 0; e < n; e++ {
+		entry := append([]byte(nil), data[ifd+2+12*e:ifd+14+12*e]...)
+		tag := order.Uint16(entry)
+		if tag == tSubIFDs || tag == tExifIFD || tag == tGPSIFD {
+			continue
+		}
+		typ := order.Uint16(entry[2:])
+		count := int(order.Uint32(entry[4:]))
+		size, ok := typeSizes[typ]
+		if !ok {
+			return nil, fmt.Errorf("Unknown TIFF field type %d", typ)
+		}
+		if count > len(data) {
+			return nil, fmt.Errorf("Invalid TIFF field count %d", count)
+		}
+
+		// Values which don't fit in the entry are stored elsewhere.
+		var values []byte
+		at := -1
+		if size*count <= 4 {
+			values = data[ifd+10+12*e : ifd+10+12*e+size*count]
+		} else {
+			from := int(order.Uint32(entry[8:]))
+			if from+size*count > len(data) {
+				return nil, fmt.Errorf("Invalid TIFF field offset %d", from)
+			}
+			values = data[from : from+size*count]
+			align()
+			at = len(out)
+			order.PutUint32(entry[8:], uint32(at))
+			out = append(out, values...)
+		}
+
+		switch tag {
+		case tStripOffsets, tTileOffsets:
+			offsets, offsetsType = values, typ
+			offsetsEntry, offsetsAt = len(entries), at
+		case tStripByteCounts, tTileByteCounts:
+			counts, countsType = values, typ
+		}
+		entries = append(entries, entry...)
+	}
+
+	offs, err := ints(offsets, order, offsetsType)
+	if err != nil {
+		return nil, err
+	}
+	lengths, err := ints(counts, order, countsType)
+	if err != nil {
+		return nil, err
+	}
+	if len(offs) != len(lengths) {
+		return nil, fmt.Errorf("TIFF IFD at offset %d has %d offsets but %d byte counts", ifd, len(offs), len(lengths))
+	}
+
+	moved := make([]int, len(offs))
+	for k := range offs {
+		if offs[k]+lengths[k] > len(data) {
+			return nil, fmt.Errorf("TIFF strip at offset %d runs past the end of the file", offs[k])
+		}
+		moved[k] = len(out)
+		out = append(out, data[offs[k]:offs[k]+lengths[k]]...)
+	}
+
+	var dst []byte
+	if offsetsAt >= 0 {
+		dst = out[offsetsAt:]
+	} else if offsetsEntry >= 0 {
+		dst = entries[offsetsEntry+8:]
+	}
+	for k, v := range moved {
+		if offsetsType == 3 {
+			if v > 0xffff {
+				return nil, fmt.Errorf("TIFF offset %d does not fit in a SHORT", v)
+			}
+			order.PutUint16(dst[2*k:], uint16(v))
+		} else {
+			order.PutUint32(dst[4*k:], uint32(v))
+		}
+	}
+
+	align()
+	order.PutUint32(out[4:], uint32(len(out)))
+	out = append(out, 0, 0)
+	order.PutUint16(out[len(out)-2:], uint16(len(entries)/12))
+	out = append(out, entries...)
+	return append(out, 0, 0, 0, 0), nil
+}
+
+// ints returns the SHORT or LONG values in raw.
+func ints(raw []byte, order binary.ByteOrder, typ uint16) ([]int, error) {
+	var vs []int
+	switch typ {
+	case 3:
+		for k := 0; k+2 <= len(raw); k += 2 {
+			vs = append(vs, int(order.Uint16(raw[k:])))
+		}
+	case 4:
+		for k := 0; k+4 <= len(raw); k += 4 {
+			vs = append(vs, int(order.Uint32(raw[k:])))
+		}
+	default:
+		if len(raw) > 0 {
+			return nil, fmt.Errorf("Invalid TIFF offset type %d", typ)
+		}
+	}
+	return vs, nil
+}
+
+// Join combines single-page TIFFs, such as those written by
+// golang.org/x/image/tiff, into one multi-page TIFF. The pages must share a
+// byte order and must not point into each other, or use offset tags other
+// than StripOffsets and TileOffsets.
+func Join(pages [][]byte) ([]byte, error) {
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("No TIFF pages to join")
+	}
+
+	order, err := byteOrder(pages[0])
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]byte(nil), pages[0]...)
+	// link is the offset in out of the pointer to the next IFD.
+	link := -1
+	for i, page := range pages {
+		if o, err := byteOrder(page); err != nil {
+			return nil, err
+		} else if o != order {
+			return nil, fmt.Errorf("TIFF page %d has a different byte order", i)
+		}
+
+		base := 0
+		if i > 0 {
+			// IFDs must start on a word boundary.
+			if len(out)%2 != 0 {
+				out = append(out, 0)
+			}
+			// The header of the page is dropped, so its offsets move by
+			// the length of out less the header.
+			base = len(out) - 8
+			out = append(out, page[8:]...)
+		}
+
+		ifd := int(order.Uint32(page[4:8])) + base
+		if err := relocate(out, order, ifd, base); err != nil {
+			return nil, fmt.Errorf("TIFF page %d: %v", i, err)
+		}
+
+		if link >= 0 {
+			order.PutUint32(out[link:], uint32(ifd))
+		}
+		link = ifd + 2 + 12*int(order.Uint16(out[ifd:]))
+		order.PutUint32(out[link:], 0)
+	}
+
+	return out, nil
+}
+
+// relocate adds base to the offsets in the IFD at ifd in data, which has been
+// moved by base along with everything it points to.
+func relocate(data []byte, order binary.ByteOrder, ifd, base int) error {
+	if ifd+2 > len(data) {
+		return fmt.Errorf("Invalid TIFF IFD offset %d", ifd)
+	}
+	n := int(order.Uint16(data[ifd:]))
+	if ifd+2+12*n+4 > len(data) {
+		return fmt.Errorf("Invalid TIFF IFD at offset %d", ifd)
+	}
+
+	for e := 0; e < n; e++ {
+		entry := data[ifd+2+12*e : ifd+14+12*e]
+		tag := order.Uint16(entry)
+		typ := order.Uint16(entry[2:])
+		count := int(order.Uint32(entry[4:]))
+		size, ok := typeSizes[typ]
+		if !ok {
+			return fmt.Errorf("Unknown TIFF field type %d", typ)
+		}
+		if count > len(data) {
+			return fmt.Errorf("Invalid TIFF field count %d", count)
+		}
+
+		// Values which don't fit in the entry are stored elsewhere.
+		values := entry[8:12]
+		if size*count > 4 {
+			at := int(order.Uint32(entry[8:])) + base
+			if at < 0 || at+size*count > len(data) {
+				return fmt.Errorf("Invalid TIFF field offset %d", at)
+			}
+			order.PutUint32(entry[8:], uint32(at))
+			values = data[at : at+size*count]
+		}
+
+		if tag != tStripOffsets && tag != tTileOffsets {
+			continue
+		}
+		for k := 0; k < count; k++ {
+			switch typ {
+			case 3:
+				v := int(order.Uint16(values[2*k:])) + base
+				if v > 0xffff {
+					return fmt.Errorf("TIFF offset %d does not fit in a SHORT", v)
+				}
+				order.PutUint16(values[2*k:], uint16(v))
+			case 4:
+				order.PutUint32(values[4*k:], order.Uint32(values[4*k:])+uint32(base))
+			default:
+				return fmt.Errorf("Invalid TIFF offset type %d", typ)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Extent returns the offset just past the last byte of data which the TIFF
+// refers to: its IFDs, the values they point to, and the strips or tiles of
+// every page. Anything after it is not part of the image. IFDs nested through
+// the SubIFDs, Exif and GPS tags are included.
+func Extent(data []byte) (int, error) {
+	offsets, err := Offsets(data)
+	if err != nil {
+		return 0, err
+	}
+	order, _ := byteOrder(data)
+
+	end := 8
+	seen := make(map[uint32]bool)
+	for _, offset := range offsets {
+		if err := extent(data, order, offset, seen, &end); err != nil {
+			return 0, err
+		}
+	}
+	return end, nil
+}
+
+// extent raises end to cover the IFD at offset and everything it refers to.
+func extent(data []byte, order binary.ByteOrder, offset uint32, seen map[uint32]bool, end *int) error {
+	if seen[offset] {
+		return nil
+	}
+	seen[offset] = true
+
+	ifd := int(offset)
+	if ifd+2 > len(data) {
+		return fmt.Errorf("Invalid TIFF IFD offset %d", ifd)
+	}
+	n := int(order.Uint16(data[ifd:]))
+	next := ifd + 2 + 12*n
+	if next+4 > len(data) {
+		return fmt.Errorf("Invalid TIFF IFD at offset %d", ifd)
+	}
+	grow := func(e int) {
+		if e > *end {
+			*end = e
+		}
+	}
+	grow(next + 4)
+
+	// values returns the values of an entry as integers.
+	values := func(entry []byte) ([]int, error) {
+		typ := order.Uint16(entry[2:])
+		count := int(order.Uint32(entry[4:]))
+		size, ok := typeSizes[typ]
+		if !ok {
+			return nil, fmt.Errorf("Unknown TIFF field type %d", typ)
+		}
+		if count > len(data) {
+			return nil, fmt.Errorf("Invalid TIFF field count %d", count)
+		}
+		raw := entry[8:12]
+		if size*count > 4 {
+			at := int(order.Uint32(entry[8:]))
+			if at+size*count > len(data) {
+				return nil, fmt.Errorf("Invalid TIFF field offset %d", at)
+			}
+			grow(at + size*count)
+			raw = data[at : at+size*count]
+		}
+
+		var vs []int
+		for k := 0; k < count; k++ {
+			switch typ {
+			case 3:
+				vs = append(vs, int(order.Uint16(raw[2*k:])))
+			case 4:
+				vs = append(vs, int(order.Uint32(raw[4*k:])))
+			}
+		}
+		return vs, nil
+	}
+
+	var offs, counts []int
+	for e := 0; e < n; e++ {
+		entry := data[ifd+2+12*e : ifd+14+12*e]
+		vs, err := values(entry)
+		if err != nil {
+			return err
+		}
+
+		switch order.Uint16(entry) {
+		case tStripOffsets, tTileOffsets:
+			offs = vs
+		case tStripByteCounts, tTileByteCounts:
+			counts = vs
+		case tSubIFDs, tExifIFD, tGPSIFD:
+			for _, v := range vs {
+				if err := extent(data, order, uint32(v), seen, end); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if len(offs) != len(counts) {
+		return fmt.Errorf("TIFF IFD at offset %d has %d offsets but %d byte counts", ifd, len(offs), len(counts))
+	}
+	for k := range offs {
+		if offs[k]+counts[k] > len(data) {
+			return fmt.Errorf("TIFF strip at offset %d runs past the end of the file", offs[k])
+		}
+		grow(offs[k] + counts[k])
+	}
+	return nil
+}
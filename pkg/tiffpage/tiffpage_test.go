@@ -0,0 +1,120 @@
+package tiffpage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+func TestJoinSplit(t *testing.T) {
+	var pages [][]byte
+	for i := 0; i < 3; i++ {
+		m := image.NewGray(image.Rect(0, 0, 5+i, 3))
+		for j := range m.Pix {
+			m.Pix[j] = uint8(i*40 + j)
+		}
+
+		var buf bytes.Buffer
+		if err := tiff.Encode(&buf, m, &tiff.Options{Compression: tiff.Deflate}); err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, buf.Bytes())
+	}
+
+	joined, err := Join(pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	split, err := Split(joined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(split) != 3 {
+		t.Fatalf("got %d pages, want 3", len(split))
+	}
+	// Each page holds only its own IFD and strips, plus a header.
+	total := 0
+	for _, page := range split {
+		total += len(page)
+	}
+	if total > len(joined)+16*len(split) {
+		t.Errorf("pages total %d bytes, from a %d byte file", total, len(joined))
+	}
+
+	for i, page := range split {
+		m, err := tiff.Decode(bytes.NewReader(page))
+		if err != nil {
+			t.Fatalf("page %d: %v", i, err)
+		}
+		if m.Bounds().Dx() != 5+i {
+			t.Errorf("page %d is %d pixels wide, want %d", i, m.Bounds().Dx(), 5+i)
+		}
+		if got := m.At(1, 0).(color.Gray).Y; got != uint8(i*40+1) {
+			t.Errorf("page %d: pixel (1, 0) = %d, want %d", i, got, i*40+1)
+		}
+	}
+}
+
+func TestOffsetsRejects(t *testing.T) {
+	for _, data := range [][]byte{
+		[]byte("\x89PNG\r\n\x1a\n"),
+		[]byte("II*\x00\x08\x00\x00\x00"),
+		// An IFD pointing back at itself.
+		[]byte("II*\x00\x08\x00\x00\x00\x00\x00\x08\x00\x00\x00"),
+	} {
+		if _, err := Offsets(data); err == nil {
+			t.Errorf("Offsets(%q) succeeded", data)
+		}
+	}
+}
+
+// stripedTIFF returns a 2x2 grayscale TIFF of pix with one strip per row, so
+// that its strip offsets don't fit in their entry.
+func stripedTIFF(pix [4]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("II*\x00\x1c\x00\x00\x00")
+	buf.Write(pix[:])
+	buf.Write([]byte{8, 0, 0, 0, 10, 0, 0, 0}) // StripOffsets at 12
+	buf.Write([]byte{2, 0, 0, 0, 2, 0, 0, 0})  // StripByteCounts at 20
+
+	type entry struct {
+		Tag, Type    uint16
+		Count, Value uint32
+	}
+	entries := []entry{
+		{256, 3, 1, 2}, {257, 3, 1, 2}, {258, 3, 1, 8}, {259, 3, 1, 1}, {262, 3, 1, 1},
+		{273, 4, 2, 12}, {277, 3, 1, 1}, {278, 3, 1, 1}, {279, 4, 2, 20},
+	}
+	binary.Write(&buf, binary.LittleEndian, uint16(len(entries)))
+	binary.Write(&buf, binary.LittleEndian, entries)
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+	return buf.Bytes()
+}
+
+func TestSplitStrips(t *testing.T) {
+	joined, err := Join([][]byte{stripedTIFF([4]byte{1, 2, 3, 4}), stripedTIFF([4]byte{5, 6, 7, 8})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	split, err := Split(joined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(split) != 2 {
+		t.Fatalf("got %d pages, want 2", len(split))
+	}
+
+	for i, page := range split {
+		m, err := tiff.Decode(bytes.NewReader(page))
+		if err != nil {
+			t.Fatalf("page %d: %v", i, err)
+		}
+		if got, want := m.(*image.Gray).Pix, []byte{uint8(4*i + 1), uint8(4*i + 2), uint8(4*i + 3), uint8(4*i + 4)}; !bytes.Equal(got, want) {
+			t.Errorf("page %d: pixels %v, want %v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// Package trailing finds data appended after the logical end of an image,
+// such as a ZIP archive after the IEND chunk of a PNG or the EOI marker of a
+// JPEG. Decoders stop at the end of the image, so anything after it is never
+// seen, and no statistic over the pixels can detect it.
+package trailing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/standardrhyme/stegsecure/pkg/filetype"
+	"github.com/standardrhyme/stegsecure/pkg/jpegcoef"
+	"github.com/standardrhyme/stegsecure/pkg/pngchunk"
+	"github.com/standardrhyme/stegsecure/pkg/tiffpage"
+)
+
+// highEntropy is the entropy, in bits per byte, above which data is taken to
+// be compressed or encrypted.
+const highEntropy = 7.5
+
+// Payload is data found after the end of an image.
+type Payload struct {
+	// Offset is where the payload starts, which is the length of the image.
+	Offset int
+	// Size is the length of the payload.
+	Size int
+	// Kind is what the payload looks like, e.g. "zip" or "text".
+	Kind string
+	// Entropy is the Shannon entropy of the payload, in bits per byte.
+	Entropy float64
+}
+
+func (p Payload) String() string {
+	return fmt.Sprintf("%d bytes of %s after offset %d (entropy %.2f bits/byte)", p.Size, p.Kind, p.Offset, p.Entropy)
+}
+
+// Scan returns the data after the end of the image in data, which is of the
+// given format as named by image.Decode. It returns nil if there is none.
+func Scan(data []byte, format string) (*Payload, error) {
+	end, err := End(data, format)
+	if err != nil {
+		return nil, err
+	}
+	if end >= len(data) {
+		return nil, nil
+	}
+
+	rest := data[end:]
+	return &Payload{
+		Offset:  end,
+		Size:    len(rest),
+		Kind:    Classify(rest),
+		Entropy: Entropy(rest),
+	}, nil
+}
+
+// End returns the length of the image at the start of data, which is of the
+// given format as named by image.Decode.
+func End(data []byte, format string) (int, error) {
+	switch format {
+	case "png":
+		return pngEnd(data)
+	case "jpeg":
+		img, err := jpegcoef.Decode(data)
+		if err != nil {
+			return 0, err
+		}
+		return img.Length, nil
+	case "gif":
+		return gifEnd(data)
+	case "bmp":
+		return bmpEnd(data)
+	case "tiff":
+		return tiffpage.Extent(data)
+	case "webp":
+		return webpEnd(data)
+	}
+	return 0, fmt.Errorf("Cannot find the end of %s files", format)
+}
+
+// pngEnd returns the offset just past the IEND chunk.
+func pngEnd(data []byte) (int, error) {
+	chunks, err := pngchunk.Parse(data)
+	if err != nil {
+		return 0, err
+	}
+	iend := chunks[len(chunks)-1]
+	return iend.Offset + 12 + len(iend.Data), nil
+}
+
+// gifEnd returns the offset just past the trailer of a GIF.
+func gifEnd(data []byte) (int, error) {
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return 0, fmt.Errorf("Not a GIF file")
+	}
+
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&7 + 1)
+	}
+
+	// subBlocks skips a sequence of data sub-blocks ending in an empty one.
+	subBlocks := func() bool {
+		for pos < len(data) {
+			n := int(data[pos])
+			pos += 1 + n
+			if n == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x3b: // Trailer
+			return pos + 1, nil
+		case 0x21: // Extension
+			pos += 2
+		case 0x2c: // Image descriptor
+			if pos+10 > len(data) {
+				return 0, fmt.Errorf("Unexpected end of GIF")
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&7 + 1)
+			}
+			// LZW minimum code size
+			pos++
+		default:
+			return 0, fmt.Errorf("Invalid GIF block 0x%02x at offset %d", data[pos], pos)
+		}
+		if !subBlocks() {
+			break
+		}
+	}
+	return 0, fmt.Errorf("GIF has no trailer")
+}
+
+// bmpEnd returns the size of a BMP file, from its header if set and otherwise
+// from the offset and size of its pixels.
+func bmpEnd(data []byte) (int, error) {
+	if len(data) < 54 || string(data[:2]) != "BM" {
+		return 0, fmt.Errorf("Not a BMP file")
+	}
+
+	size := int64(binary.LittleEndian.Uint32(data[2:]))
+	offset := int64(binary.LittleEndian.Uint32(data[10:]))
+	pixels := int64(binary.LittleEndian.Uint32(data[34:]))
+	if pixels == 0 {
+		// Uncompressed BMPs may leave the size of their pixels unset.
+		width := int64(int32(binary.LittleEndian.Uint32(data[18:])))
+		height := int64(int32(binary.LittleEndian.Uint32(data[22:])))
+		bpp := int64(binary.LittleEndian.Uint16(data[28:]))
+		if height < 0 {
+			height = -height
+		}
+		pixels = (bpp*width + 31) / 32 * 4 * height
+	}
+
+	if size < offset+pixels {
+		size = offset + pixels
+	}
+	if size > int64(len(data)) {
+		return 0, fmt.Errorf("BMP is truncated")
+	}
+	return int(size), nil
+}
+
+// webpEnd returns the length of the RIFF container of a WebP.
+func webpEnd(data []byte) (int, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, fmt.Errorf("Not a WebP file")
+	}
+
+	size := 8 + int64(binary.LittleEndian.Uint32(data[4:]))
+	size += size & 1
+	if size > int64(len(data)) {
+		return 0, fmt.Errorf("WebP is truncated")
+	}
+	return int(size), nil
+}
+
+// kinds are the magic numbers of common payloads.
+var kinds = []struct {
+	magic string
+	kind  string
+}{
+	{"PK\x03\x04", "zip"},
+	{"PK\x05\x06", "zip"},
+	{"Rar!\x1a\x07", "rar"},
+	{"7z\xbc\xaf\x27\x1c", "7z"},
+	{"\x1f\x8b", "gzip"},
+	{"BZh", "bzip2"},
+	{"\xfd7zXZ\x00", "xz"},
+	{"%PDF", "pdf"},
+	{"\x7fELF", "elf executable"},
+	{"MZ", "windows executable"},
+	{"-----BEGIN PGP", "pgp"},
+	{"\x85\x01\x0c", "pgp"},
+}
+
+// Classify returns what data looks like: a known file type if it starts with
+// a magic number, otherwise zero padding, text, or high-entropy (compressed or
+// encrypted) data if it looks like either.
+func Classify(data []byte) string {
+	for _, k := range kinds {
+		if bytes.HasPrefix(data, []byte(k.magic)) {
+			return k.kind
+		}
+	}
+	if t, ok := filetype.Detect(data); ok {
+		return t.Name + " image"
+	}
+
+	zero, text := true, true
+	for _, b := range data {
+		zero = zero && b == 0
+		text = text && (b >= 0x20 && b < 0x7f || b == '\t' || b == '\n' || b == '\r')
+	}
+	switch {
+	case zero:
+		return "zero padding"
+	case text:
+		return "text"
+	case Entropy(data) >= highEntropy:
+		return "high-entropy data"
+	}
+	return "data"
+}
+
+// Entropy returns the Shannon entropy of data, in bits per byte.
+func Entropy(data []byte) float64 {
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+
+	h := 0.0
+	for _, n := range counts {
+		if n > 0 {
+			p := float64(n) / float64(len(data))
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
@@ -0,0 +1,78 @@
+package trailing
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"math/rand"
+	"os"
+	"testing"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// images returns an image of every supported format, by format name.
+func images(t *testing.T) map[string][]byte {
+	files := map[string][]byte{}
+	for format, file := range map[string]string{
+		"jpeg": "../../testfiles/sampleprogressive.jpg",
+		"webp": "../../testfiles/samplelossy.webp",
+	} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[format] = data
+	}
+
+	m := image.NewPaletted(image.Rect(0, 0, 7, 5), color.Palette{color.Black, color.White, color.Gray{0x80}})
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i % 3)
+	}
+	for format, encode := range map[string]func(*bytes.Buffer) error{
+		"png":  func(b *bytes.Buffer) error { return png.Encode(b, m) },
+		"gif":  func(b *bytes.Buffer) error { return gif.Encode(b, m, nil) },
+		"bmp":  func(b *bytes.Buffer) error { return bmp.Encode(b, m) },
+		"tiff": func(b *bytes.Buffer) error { return tiff.Encode(b, m, &tiff.Options{Compression: tiff.Deflate}) },
+	} {
+		var buf bytes.Buffer
+		if err := encode(&buf); err != nil {
+			t.Fatal(err)
+		}
+		files[format] = buf.Bytes()
+	}
+	return files
+}
+
+func TestScan(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	payloads := map[string][]byte{
+		"zip":               append([]byte("PK\x03\x04"), random[:100]...),
+		"text":              []byte("attack at dawn\n"),
+		"zero padding":      make([]byte, 16),
+		"high-entropy data": random,
+	}
+
+	for format, data := range images(t) {
+		if p, err := Scan(data, format); err != nil || p != nil {
+			t.Errorf("%s: Scan = %v, %v; want no payload", format, p, err)
+		}
+
+		for kind, payload := range payloads {
+			stego := append(append([]byte(nil), data...), payload...)
+			p, err := Scan(stego, format)
+			if err != nil {
+				t.Errorf("%s with %s: %v", format, kind, err)
+				continue
+			}
+			if p == nil || p.Offset != len(data) || p.Size != len(payload) || p.Kind != kind {
+				t.Errorf("%s with %s: Scan = %v; want %d bytes at offset %d", format, kind, p, len(payload), len(data))
+			}
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package vp8l
+
+import (
+	"container/heap"
+)
+
+// Constants from the WebP lossless bitstream specification.
+const (
+	transformPredictor     = 0
+	transformSubtractGreen = 2
+
+	numLiteralCodes  = 256
+	numLengthCodes   = 24
+	numDistanceCodes = 40
+
+	// maxCodeLength is the longest code of a prefix code, and
+	// maxCodeLengthCodeLength that of the code its lengths are coded with.
+	maxCodeLength           = 15
+	maxCodeLengthCodeLength = 7
+
+	// Code length codes repeating zeros, 3 to 10 and 11 to 138 times.
+	repeatZeros     = 17
+	repeatManyZeros = 18
+)
+
+// codeLengthCodeOrder is the order the lengths of the code length code are
+// written in.
+var codeLengthCodeOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// prefixCode is a canonical prefix code over an alphabet.
+type prefixCode struct {
+	lengths []uint8
+	// codes are the bit-reversed codes, ready to be written LSB first.
+	codes []uint32
+	// symbols are the symbols with a code, in order.
+	symbols []int
+}
+
+// newPrefixCode builds a code of at most maxLength bits for the symbols with
+// nonzero counts.
+func newPrefixCode(counts []int, maxLength int) *prefixCode {
+	c := &prefixCode{
+		lengths: make([]uint8, len(counts)),
+		codes:   make([]uint32, len(counts)),
+	}
+	for s, n := range counts {
+		if n > 0 {
+			c.symbols = append(c.symbols, s)
+		}
+	}
+
+	switch len(c.symbols) {
+	case 0:
+		return c
+	case 1:
+		// A code with a single symbol takes no bits.
+		c.lengths[c.symbols[0]] = 1
+		return c
+	}
+
+	// Halve the counts until the Huffman code fits in maxLength bits.
+	scaled := append([]int(nil), counts...)
+	for {
+		huffmanLengths(scaled, c.lengths)
+		longest := uint8(0)
+		for _, l := range c.lengths {
+			if l > longest {
+				longest = l
+			}
+		}
+		if int(longest) <= maxLength {
+			break
+		}
+		for s, n := range scaled {
+			if n > 0 {
+				scaled[s] = (n + 1) / 2
+			}
+		}
+	}
+
+	// Assign canonical codes, shortest first and in symbol order within a
+	// length.
+	var count [maxCodeLength + 2]uint32
+	for _, l := range c.lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [maxCodeLength + 2]uint32
+	code := uint32(0)
+	for l := 1; l < len(next); l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	for s, l := range c.lengths {
+		if l > 0 {
+			c.codes[s] = reverse(next[l], l)
+			next[l]++
+		}
+	}
+
+	return c
+}
+
+// reverse returns the low n bits of code in reverse order.
+func reverse(code uint32, n uint8) uint32 {
+	r := uint32(0)
+	for i := uint8(0); i < n; i++ {
+		r = r<<1 | code&1
+		code >>= 1
+	}
+	return r
+}
+
+// emit writes the code for symbol s.
+func (c *prefixCode) emit(e *bitWriter, s int) {
+	if len(c.symbols) > 1 {
+		e.write(c.codes[s], uint(c.lengths[s]))
+	}
+}
+
+// node is a node of the tree huffmanLengths builds.
+type node struct {
+	count  int
+	symbol int // -1 for internal nodes
+	left   *node
+	right  *node
+}
+
+type nodeHeap []*node
+
+func (h nodeHeap) Len() int { return len(h) }
+func (h nodeHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].symbol > h[j].symbol
+}
+func (h nodeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nodeHeap) Push(x interface{}) { *h = append(*h, x.(*node)) }
+func (h *nodeHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// huffmanLengths sets lengths to the code lengths of a Huffman code for the
+// symbols with nonzero counts, of which there are at least two.
+func huffmanLengths(counts []int, lengths []uint8) {
+	h := &nodeHeap{}
+	for s, n := range counts {
+		lengths[s] = 0
+		if n > 0 {
+			*h = append(*h, &node{count: n, symbol: s})
+		}
+	}
+	heap.Init(h)
+
+	for h.Len() > 1 {
+		a := heap.Pop(h).(*node)
+		b := heap.Pop(h).(*node)
+		heap.Push(h, &node{count: a.count + b.count, symbol: -1, left: a, right: b})
+	}
+
+	var walk func(n *node, depth uint8)
+	walk = func(n *node, depth uint8) {
+		if n.symbol >= 0 {
+			lengths[n.symbol] = depth
+			return
+		}
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	walk(heap.Pop(h).(*node), 0)
+}
+
+// bitWriter packs bits LSB first, as VP8L reads them.
+type bitWriter struct {
+	buf  []byte
+	acc  uint64
+	bits uint
+}
+
+// write writes the low n <= 32 bits of v.
+func (e *bitWriter) write(v uint32, n uint) {
+	e.acc |= uint64(v&(1<<n-1)) << e.bits
+	e.bits += n
+	for e.bits >= 8 {
+		e.buf = append(e.buf, byte(e.acc))
+		e.acc >>= 8
+		e.bits -= 8
+	}
+}
+
+// bytes flushes the last partial byte and returns everything written.
+func (e *bitWriter) bytes() []byte {
+	if e.bits > 0 {
+		e.buf = append(e.buf, byte(e.acc))
+		e.acc, e.bits = 0, 0
+	}
+	return e.buf
+}
+
+// writeCode writes the lengths of c, as a simple code if it has at most two
+// symbols which fit, and otherwise coded with a code length code.
+func (e *bitWriter) writeCode(c *prefixCode) {
+	if len(c.symbols) <= 2 && (len(c.symbols) == 0 || c.symbols[len(c.symbols)-1] < 256) {
+		symbols := c.symbols
+		if len(symbols) == 0 {
+			// An unused code still needs a symbol.
+			symbols = []int{0}
+		}
+
+		e.write(1, 1)
+		e.write(uint32(len(symbols)-1), 1)
+		if symbols[0] < 2 {
+			e.write(0, 1)
+			e.write(uint32(symbols[0]), 1)
+		} else {
+			e.write(1, 1)
+			e.write(uint32(symbols[0]), 8)
+		}
+		if len(symbols) == 2 {
+			e.write(uint32(symbols[1]), 8)
+			// The simple code gives the first symbol 0 and the second 1.
+			c.codes[symbols[0]], c.codes[symbols[1]] = 0, 1
+		}
+		return
+	}
+
+	// Run-length code the lengths, repeating zeros only.
+	type token struct {
+		code  int
+		extra uint32
+		bits  uint
+	}
+	var tokens []token
+	lengths := c.lengths
+	for i := 0; i < len(lengths); {
+		if lengths[i] != 0 {
+			tokens = append(tokens, token{code: int(lengths[i])})
+			i++
+			continue
+		}
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == 0 && run < 138 {
+			run++
+		}
+		switch {
+		case run < 3:
+			for k := 0; k < run; k++ {
+				tokens = append(tokens, token{code: 0})
+			}
+		case run <= 10:
+			tokens = append(tokens, token{code: repeatZeros, extra: uint32(run - 3), bits: 3})
+		default:
+			tokens = append(tokens, token{code: repeatManyZeros, extra: uint32(run - 11), bits: 7})
+		}
+		i += run
+	}
+
+	counts := make([]int, len(codeLengthCodeOrder))
+	for _, t := range tokens {
+		counts[t.code]++
+	}
+	cl := newPrefixCode(counts, maxCodeLengthCodeLength)
+
+	n := 4
+	for i, s := range codeLengthCodeOrder {
+		if cl.lengths[s] != 0 && i+1 > n {
+			n = i + 1
+		}
+	}
+	e.write(0, 1)
+	e.write(uint32(n-4), 4)
+	for _, s := range codeLengthCodeOrder[:n] {
+		e.write(uint32(cl.lengths[s]), 3)
+	}
+
+	e.write(0, 1) // Code every symbol
+	for _, t := range tokens {
+		cl.emit(e, t.code)
+		e.write(t.extra, t.bits)
+	}
+}
@@ -0,0 +1,296 @@
+package vp8l
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// minMatch is the shortest backward reference worth coding, and
+	// maxMatch the longest a length code can hold.
+	minMatch = 3
+	maxMatch = 4096
+	// maxDistance is the furthest back a distance code can reach, less the
+	// 120 codes which stand for nearby pixels.
+	maxDistance = 1<<20 - 120
+
+	// matchHashBits is the size of the hash table of pixel pairs, and
+	// maxChain how many earlier pairs with the same hash are tried.
+	matchHashBits = 16
+	maxChain      = 8
+
+	// colorCacheMultiplier is the multiplicative hash of the colour cache.
+	colorCacheMultiplier = 0x1e35a7bd
+	// maxCacheBits is the log-2 size of the largest colour cache, and
+	// cacheSample how many tokens its size is picked on.
+	maxCacheBits = 10
+	cacheSample  = 1 << 20
+)
+
+// distanceMapTable maps the first 120 distance codes to the pixels near the
+// current one they stand for, as 16*dy + 8 - dx.
+var distanceMapTable = [120]uint8{
+	0x18, 0x07, 0x17, 0x19, 0x28, 0x06, 0x27, 0x29, 0x16, 0x1a,
+	0x26, 0x2a, 0x38, 0x05, 0x37, 0x39, 0x15, 0x1b, 0x36, 0x3a,
+	0x25, 0x2b, 0x48, 0x04, 0x47, 0x49, 0x14, 0x1c, 0x35, 0x3b,
+	0x46, 0x4a, 0x24, 0x2c, 0x58, 0x45, 0x4b, 0x34, 0x3c, 0x03,
+	0x57, 0x59, 0x13, 0x1d, 0x56, 0x5a, 0x23, 0x2d, 0x44, 0x4c,
+	0x55, 0x5b, 0x33, 0x3d, 0x68, 0x02, 0x67, 0x69, 0x12, 0x1e,
+	0x66, 0x6a, 0x22, 0x2e, 0x54, 0x5c, 0x43, 0x4d, 0x65, 0x6b,
+	0x32, 0x3e, 0x78, 0x01, 0x77, 0x79, 0x53, 0x5d, 0x11, 0x1f,
+	0x64, 0x6c, 0x42, 0x4e, 0x76, 0x7a, 0x21, 0x2f, 0x75, 0x7b,
+	0x31, 0x3f, 0x63, 0x6d, 0x52, 0x5e, 0x00, 0x74, 0x7c, 0x41,
+	0x4f, 0x10, 0x20, 0x62, 0x6e, 0x30, 0x73, 0x7d, 0x51, 0x5f,
+	0x40, 0x72, 0x7e, 0x61, 0x6f, 0x50, 0x71, 0x7f, 0x60, 0x70,
+}
+
+// tokenKind is what a token codes.
+type tokenKind uint8
+
+const (
+	literal tokenKind = iota
+	cacheHit
+	backRef
+)
+
+// token is a single symbol of the pixel stream: a literal pixel, a colour
+// cache index, or a backward reference of length pixels distance back.
+type token struct {
+	kind   tokenKind
+	index  uint16
+	length uint16
+	argb   uint32
+	// distance is the distance code, after mapping nearby pixels.
+	distance uint32
+}
+
+// distanceCodes returns the distance code of each distance up to the 120
+// nearby pixels of an image width pixels wide.
+func distanceCodes(width int) map[int]int {
+	codes := make(map[int]int, len(distanceMapTable))
+	for i, v := range distanceMapTable {
+		dy, dx := int(v>>4), 8-int(v&0xf)
+		d := dy*width + dx
+		if _, ok := codes[d]; d >= 1 && !ok {
+			codes[d] = i + 1
+		}
+	}
+	return codes
+}
+
+// matchHash hashes the pair of pixels at i.
+func matchHash(pix []uint32, i int) uint32 {
+	return (pix[i]*colorCacheMultiplier ^ pix[i+1]*0x9e3779b1) >> (32 - matchHashBits)
+}
+
+// backwardRefs tokenizes pix, which is width pixels wide, greedily replacing
+// runs which repeat earlier pixels with backward references.
+func backwardRefs(pix []uint32, width int) []token {
+	codes := distanceCodes(width)
+	head := make([]int32, 1<<matchHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(pix))
+	insert := func(i int) {
+		if i+1 < len(pix) {
+			h := matchHash(pix, i)
+			prev[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+
+	tokens := make([]token, 0, len(pix)/2)
+	for i := 0; i < len(pix); {
+		limit := len(pix) - i
+		if limit > maxMatch {
+			limit = maxMatch
+		}
+
+		length, distance := 0, 0
+		if i+1 < len(pix) {
+			j := head[matchHash(pix, i)]
+			for chain := 0; j >= 0 && i-int(j) <= maxDistance && chain < maxChain; chain++ {
+				n := 0
+				for n < limit && pix[int(j)+n] == pix[i+n] {
+					n++
+				}
+				if n > length {
+					length, distance = n, i-int(j)
+					if n == limit {
+						break
+					}
+				}
+				j = prev[j]
+			}
+		}
+
+		if length < minMatch {
+			tokens = append(tokens, token{kind: literal, argb: pix[i]})
+			insert(i)
+			i++
+			continue
+		}
+
+		code, ok := codes[distance]
+		if !ok {
+			code = distance + len(distanceMapTable)
+		}
+		tokens = append(tokens, token{kind: backRef, length: uint16(length), distance: uint32(code)})
+		for k := 0; k < length; k++ {
+			insert(i + k)
+		}
+		i += length
+	}
+	return tokens
+}
+
+// bestCacheBits returns the log-2 size of the colour cache, from 1 to
+// maxCacheBits or 0 for none, estimated to code tokens in the fewest bits.
+// pix are the pixels tokens code. Every size is tried in a single pass over
+// the first cacheSample tokens.
+func bestCacheBits(tokens []token, pix []uint32) uint {
+	if len(tokens) > cacheSample {
+		tokens = tokens[:cacheSample]
+	}
+	var caches [maxCacheBits + 1][]uint32
+	var h [maxCacheBits + 1]histograms
+	for bits := range h {
+		if bits > 0 {
+			caches[bits] = make([]uint32, 1<<bits)
+		}
+		h[bits] = newHistograms(uint(bits))
+	}
+
+	i := 0
+	for _, t := range tokens {
+		switch t.kind {
+		case literal:
+			hash := t.argb * colorCacheMultiplier
+			for bits := range h {
+				if bits > 0 {
+					index := hash >> (32 - bits)
+					if caches[bits][index] == t.argb {
+						h[bits][0][numLiteralCodes+numLengthCodes+int(index)]++
+						continue
+					}
+					caches[bits][index] = t.argb
+				}
+				h[bits].add(t)
+			}
+			i++
+		case backRef:
+			for bits := range h {
+				h[bits].add(t)
+				if bits > 0 {
+					for _, argb := range pix[i : i+int(t.length)] {
+						caches[bits][argb*colorCacheMultiplier>>(32-bits)] = argb
+					}
+				}
+			}
+			i += int(t.length)
+		}
+	}
+
+	best, bestCost := uint(0), h[0].entropy()
+	for bits := 1; bits < len(h); bits++ {
+		if cost := h[bits].entropy(); cost < bestCost {
+			best, bestCost = uint(bits), cost
+		}
+	}
+	return best
+}
+
+// useCache replaces the literals of tokens which are found in a colour cache
+// of 1<<cacheBits entries with cache hits. pix are the pixels tokens code.
+func useCache(tokens []token, pix []uint32, cacheBits uint) {
+	cache := make([]uint32, 1<<cacheBits)
+	shift := 32 - cacheBits
+
+	i := 0
+	for k, t := range tokens {
+		switch t.kind {
+		case literal:
+			index := t.argb * colorCacheMultiplier >> shift
+			if cache[index] == t.argb {
+				tokens[k] = token{kind: cacheHit, index: uint16(index)}
+			}
+			cache[index] = t.argb
+			i++
+		case backRef:
+			for _, argb := range pix[i : i+int(t.length)] {
+				cache[argb*colorCacheMultiplier>>shift] = argb
+			}
+			i += int(t.length)
+		}
+	}
+}
+
+// prefixEncode splits a length or distance code of at least 1 into the symbol
+// coding its magnitude and the extra bits which follow it.
+func prefixEncode(v int) (int, uint32, uint) {
+	v--
+	if v < 4 {
+		return v, 0, 0
+	}
+	highest := bits.Len(uint(v)) - 1
+	second := v >> (highest - 1) & 1
+	extraBits := uint(highest - 1)
+	return 2*highest + second, uint32(v) & (1<<extraBits - 1), extraBits
+}
+
+// histograms are the symbol counts of the green, red, blue, alpha and
+// distance codes.
+type histograms [5][]int
+
+// newHistograms returns empty histograms for a colour cache of 1<<cacheBits
+// entries, or none if cacheBits is 0.
+func newHistograms(cacheBits uint) histograms {
+	green := numLiteralCodes + numLengthCodes
+	if cacheBits > 0 {
+		green += 1 << cacheBits
+	}
+	return histograms{
+		make([]int, green),
+		make([]int, numLiteralCodes),
+		make([]int, numLiteralCodes),
+		make([]int, numLiteralCodes),
+		make([]int, numDistanceCodes),
+	}
+}
+
+// add counts the symbols of t.
+func (h histograms) add(t token) {
+	switch t.kind {
+	case literal:
+		h[0][t.argb>>8&0xff]++
+		h[1][t.argb>>16&0xff]++
+		h[2][t.argb&0xff]++
+		h[3][t.argb>>24]++
+	case cacheHit:
+		h[0][numLiteralCodes+numLengthCodes+int(t.index)]++
+	case backRef:
+		symbol, _, _ := prefixEncode(int(t.length))
+		h[0][numLiteralCodes+symbol]++
+		symbol, _, _ = prefixEncode(int(t.distance))
+		h[4][symbol]++
+	}
+}
+
+// entropy estimates the bits the counted symbols take, with a Huffman code
+// per histogram, leaving out the extra bits of lengths and distances.
+func (h histograms) entropy() float64 {
+	total := float64(0)
+	for _, counts := range h {
+		n := 0
+		for _, c := range counts {
+			n += c
+		}
+		for _, c := range counts {
+			if c > 0 {
+				total -= float64(c) * math.Log2(float64(c)/float64(n))
+			}
+		}
+	}
+	return total
+}
@@ -0,0 +1,217 @@
+package vp8l
+
+import (
+	"runtime"
+	"sync"
+)
+
+// predictorBits is the log-2 size of the tiles the predictor transform picks
+// a mode for.
+const predictorBits = 4
+
+// numPredictors is the number of predictor modes.
+const numPredictors = 14
+
+// channels splits an ARGB pixel into its alpha, red, green and blue samples.
+func channels(argb uint32) [4]int32 {
+	return [4]int32{int32(argb >> 24), int32(argb >> 16 & 0xff), int32(argb >> 8 & 0xff), int32(argb & 0xff)}
+}
+
+// pack joins alpha, red, green and blue samples into an ARGB pixel.
+func pack(c [4]int32) uint32 {
+	return uint32(c[0])<<24 | uint32(c[1])<<16 | uint32(c[2])<<8 | uint32(c[3])
+}
+
+// average2 is the per-channel mean of a and b, rounded down.
+func average2(a, b uint32) uint32 {
+	return (a & b) + ((a ^ b) & 0xfefefefe >> 1)
+}
+
+// clamp255 clamps x to a sample.
+func clamp255(x int32) int32 {
+	if x < 0 {
+		return 0
+	}
+	if x > 255 {
+		return 255
+	}
+	return x
+}
+
+// abs32 is the absolute value of x.
+func abs32(x int32) int32 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// predict returns the prediction of the given mode for the pixel at i of pix,
+// which is width pixels wide, from its left (L), top (T), top-right (TR) and
+// top-left (TL) neighbours. The pixel must not be in the first row or column.
+// As in the decoder, TR of the last column is the first pixel of the row.
+func predict(pix []uint32, i, width, mode int) uint32 {
+	l, t, tr, tl := pix[i-1], pix[i-width], pix[i-width+1], pix[i-width-1]
+
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return l
+	case 2:
+		return t
+	case 3:
+		return tr
+	case 4:
+		return tl
+	case 5:
+		return average2(average2(l, tr), t)
+	case 6:
+		return average2(l, tl)
+	case 7:
+		return average2(l, t)
+	case 8:
+		return average2(tl, t)
+	case 9:
+		return average2(t, tr)
+	case 10:
+		return average2(average2(l, tl), average2(t, tr))
+
+	case 11:
+		// Select: whichever of L and T is closer to the gradient L + T - TL.
+		cl, ct, ctl := channels(l), channels(t), channels(tl)
+		toL, toT := int32(0), int32(0)
+		for c := range cl {
+			toL += abs32(ctl[c] - ct[c])
+			toT += abs32(ctl[c] - cl[c])
+		}
+		if toL < toT {
+			return l
+		}
+		return t
+
+	case 12:
+		cl, ct, ctl := channels(l), channels(t), channels(tl)
+		var p [4]int32
+		for c := range p {
+			p[c] = clamp255(cl[c] + ct[c] - ctl[c])
+		}
+		return pack(p)
+
+	default:
+		ca, ctl := channels(average2(l, t)), channels(tl)
+		var p [4]int32
+		for c := range p {
+			p[c] = clamp255(ca[c] + (ca[c]-ctl[c])/2)
+		}
+		return pack(p)
+	}
+}
+
+// subtract is the per-channel difference a - b, modulo 256. Alpha and green,
+// and red and blue, are subtracted two at a time, with the bits between them
+// set so that no borrow crosses from one channel to the next.
+func subtract(a, b uint32) uint32 {
+	alphaGreen := (a | 0x00ff00ff) - (b & 0xff00ff00)
+	redBlue := (a | 0xff00ff00) - (b & 0x00ff00ff)
+	return alphaGreen&0xff00ff00 | redBlue&0x00ff00ff
+}
+
+// residualCost is how far a residual is from 0, as a cheap stand-in for the
+// bits it will take to code.
+func residualCost(r uint32) int32 {
+	cost := int32(0)
+	for shift := 0; shift < 32; shift += 8 {
+		cost += abs32(int32(int8(r >> shift)))
+	}
+	return cost
+}
+
+// applyPredictor picks, for each tile of pix, the predictor mode which leaves
+// the smallest residuals, and returns the residuals along with the tile image,
+// which holds each tile's mode in its green channel, and its width. Rows of
+// tiles are predicted concurrently.
+func applyPredictor(pix []uint32, width, height int) ([]uint32, []uint32, int) {
+	tiles := (width + 1<<predictorBits - 1) >> predictorBits
+	tileRows := (height + 1<<predictorBits - 1) >> predictorBits
+	modes := make([]uint32, tiles*tileRows)
+	residuals := make([]uint32, len(pix))
+
+	rows := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ty := range rows {
+				predictTileRow(pix, residuals, modes, width, height, ty)
+			}
+		}()
+	}
+	for ty := 0; ty < tileRows; ty++ {
+		rows <- ty
+	}
+	close(rows)
+	wg.Wait()
+
+	return residuals, modes, tiles
+}
+
+// predictTileRow picks the modes of the tiles in row ty of the tile image
+// modes, and writes the residuals of the pixels they cover.
+func predictTileRow(pix, residuals, modes []uint32, width, height, ty int) {
+	tiles := (width + 1<<predictorBits - 1) >> predictorBits
+	y0 := ty << predictorBits
+	y1 := y0 + 1<<predictorBits
+	if y1 > height {
+		y1 = height
+	}
+
+	for tx := 0; tx < tiles; tx++ {
+		x0 := tx << predictorBits
+		x1 := x0 + 1<<predictorBits
+		if x1 > width {
+			x1 = width
+		}
+
+		// Every other row is enough to tell the modes apart.
+		var costs [numPredictors]int32
+		for y := y0 | 1; y < y1; y += 2 {
+			for x := x0; x < x1; x++ {
+				if x == 0 {
+					continue
+				}
+				i := y*width + x
+				for mode := range costs {
+					costs[mode] += residualCost(subtract(pix[i], predict(pix, i, width, mode)))
+				}
+			}
+		}
+
+		best := 0
+		for mode, cost := range costs {
+			if cost < costs[best] {
+				best = mode
+			}
+		}
+		modes[ty*tiles+tx] = 0xff000000 | uint32(best)<<8
+
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				i := y*width + x
+				var prediction uint32
+				switch {
+				case x == 0 && y == 0:
+					prediction = 0xff000000
+				case y == 0:
+					prediction = pix[i-1]
+				case x == 0:
+					prediction = pix[i-width]
+				default:
+					prediction = predict(pix, i, width, best)
+				}
+				residuals[i] = subtract(pix[i], prediction)
+			}
+		}
+	}
+}
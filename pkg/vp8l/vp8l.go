@@ -0,0 +1,158 @@
+// Package vp8l encodes images as lossless WebP (VP8L), which
+// golang.org/x/image/webp can only decode.
+//
+// The encoder applies the subtract-green and predictor transforms, and codes
+// the residuals with backward references, a colour cache and a single group of
+// prefix codes. It does not search as hard as libwebp, nor use the
+// cross-colour or colour-indexing transforms, so its output is somewhat
+// larger, but it decodes to exactly the pixels it was given.
+package vp8l
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// maxDimension is the largest width or height VP8L can store.
+const maxDimension = 1 << 14
+
+// IsLossless reports whether data is a WebP file whose image is lossless.
+func IsLossless(data []byte) bool {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return false
+	}
+
+	for pos := 12; pos+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		switch string(data[pos : pos+4]) {
+		case "VP8L":
+			return true
+		case "VP8 ":
+			return false
+		}
+		if size < 0 || size > len(data) {
+			return false
+		}
+		// Chunks are padded to an even length.
+		pos += 8 + size + size&1
+	}
+	return false
+}
+
+// Encode writes m to w as a lossless WebP.
+func Encode(w io.Writer, m image.Image) error {
+	b := m.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > maxDimension || b.Dy() > maxDimension {
+		return fmt.Errorf("Image size %dx%d cannot be stored as WebP", b.Dx(), b.Dy())
+	}
+
+	// The pixels as ARGB, after the subtract-green transform.
+	width, height := b.Dx(), b.Dy()
+	pix := make([]uint32, 0, width*height)
+	alpha := false
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := nrgbaAt(m, x, y)
+			alpha = alpha || c.A != 0xff
+			pix = append(pix, uint32(c.A)<<24|uint32(c.R-c.G)<<16|uint32(c.G)<<8|uint32(c.B-c.G))
+		}
+	}
+	residuals, modes, tiles := applyPredictor(pix, width, height)
+
+	e := &bitWriter{}
+	e.write(0x2f, 8)
+	e.write(uint32(width-1), 14)
+	e.write(uint32(height-1), 14)
+	if alpha {
+		e.write(1, 1)
+	} else {
+		e.write(0, 1)
+	}
+	e.write(0, 3) // Version
+
+	// The subtract-green transform, then the predictor transform with the
+	// mode of each tile.
+	e.write(1, 1)
+	e.write(transformSubtractGreen, 2)
+	e.write(1, 1)
+	e.write(transformPredictor, 2)
+	e.write(predictorBits-2, 3)
+	e.writeImage(modes, tiles, false)
+	e.write(0, 1)
+
+	e.writeImage(residuals, width, true)
+
+	chunk := e.bytes()
+	riff := make([]byte, 20, 20+len(chunk)+1)
+	copy(riff, "RIFF\x00\x00\x00\x00WEBPVP8L")
+	binary.LittleEndian.PutUint32(riff[16:], uint32(len(chunk)))
+	riff = append(riff, chunk...)
+	if len(chunk)%2 != 0 {
+		riff = append(riff, 0)
+	}
+	binary.LittleEndian.PutUint32(riff[4:], uint32(len(riff)-8))
+
+	_, err := w.Write(riff)
+	return err
+}
+
+// writeImage writes pix, which is width pixels wide, as an entropy-coded image
+// with a single group of prefix codes, using backward references and whichever
+// colour cache size is estimated to code it in the fewest bits. Only the main
+// image, unlike the tile images of transforms, says whether it has meta prefix
+// codes.
+func (e *bitWriter) writeImage(pix []uint32, width int, main bool) {
+	tokens := backwardRefs(pix, width)
+	cacheBits := bestCacheBits(tokens, pix)
+	if cacheBits > 0 {
+		useCache(tokens, pix, cacheBits)
+		e.write(1, 1)
+		e.write(uint32(cacheBits), 4)
+	} else {
+		e.write(0, 1)
+	}
+	if main {
+		e.write(0, 1) // No meta prefix codes
+	}
+
+	// The prefix codes for green, red, blue, alpha and distances.
+	h := newHistograms(cacheBits)
+	for _, t := range tokens {
+		h.add(t)
+	}
+	var codes [5]*prefixCode
+	for i, counts := range h {
+		codes[i] = newPrefixCode(counts, maxCodeLength)
+		e.writeCode(codes[i])
+	}
+
+	for _, t := range tokens {
+		switch t.kind {
+		case literal:
+			codes[0].emit(e, int(t.argb>>8&0xff))
+			codes[1].emit(e, int(t.argb>>16&0xff))
+			codes[2].emit(e, int(t.argb&0xff))
+			codes[3].emit(e, int(t.argb>>24))
+		case cacheHit:
+			codes[0].emit(e, numLiteralCodes+numLengthCodes+int(t.index))
+		case backRef:
+			symbol, extra, n := prefixEncode(int(t.length))
+			codes[0].emit(e, numLiteralCodes+symbol)
+			e.write(extra, n)
+			symbol, extra, n = prefixEncode(int(t.distance))
+			codes[4].emit(e, symbol)
+			e.write(extra, n)
+		}
+	}
+}
+
+// nrgbaAt returns the non-premultiplied 8-bit colour of the pixel at (x, y).
+func nrgbaAt(m image.Image, x, y int) color.NRGBA {
+	if n, ok := m.(*image.NRGBA); ok {
+		return n.NRGBAAt(x, y)
+	}
+	return color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+}
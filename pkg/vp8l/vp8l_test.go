@@ -0,0 +1,106 @@
+package vp8l
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for _, tt := range []struct {
+		name string
+		w, h int
+		fill func(x, y int) color.NRGBA
+	}{
+		{"noise", 37, 23, func(x, y int) color.NRGBA {
+			return color.NRGBA{R: uint8(rng.Intn(256)), G: uint8(rng.Intn(256)), B: uint8(rng.Intn(256)), A: uint8(rng.Intn(256))}
+		}},
+		{"gradient", 300, 7, func(x, y int) color.NRGBA {
+			return color.NRGBA{R: uint8(x), G: uint8(x / 2), B: uint8(y), A: 0xff}
+		}},
+		{"flat", 1, 1, func(x, y int) color.NRGBA {
+			return color.NRGBA{R: 9, G: 8, B: 7, A: 0xff}
+		}},
+		{"two colours", 16, 16, func(x, y int) color.NRGBA {
+			return color.NRGBA{R: uint8(200 * ((x + y) % 2)), A: 0xff}
+		}},
+		// Runs longer than the longest backward reference.
+		{"flat wide", 5000, 3, func(x, y int) color.NRGBA {
+			return color.NRGBA{R: 1, G: 2, B: 3, A: 0xff}
+		}},
+		// Transparent black is in the colour cache before any pixel is.
+		{"transparent", 40, 40, func(x, y int) color.NRGBA {
+			return color.NRGBA{}
+		}},
+		{"repeats", 61, 45, func(x, y int) color.NRGBA {
+			return color.NRGBA{R: uint8(x % 7 * 30), G: uint8(y % 5 * 40), B: uint8((x + y) % 3), A: uint8(0xff - x%2)}
+		}},
+	} {
+		m := image.NewNRGBA(image.Rect(0, 0, tt.w, tt.h))
+		for y := 0; y < tt.h; y++ {
+			for x := 0; x < tt.w; x++ {
+				m.SetNRGBA(x, y, tt.fill(x, y))
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := Encode(&buf, m); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !IsLossless(buf.Bytes()) {
+			t.Errorf("%s: output not recognized as lossless", tt.name)
+		}
+
+		got, err := webp.Decode(&buf)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for y := 0; y < tt.h; y++ {
+			for x := 0; x < tt.w; x++ {
+				if c := got.(*image.NRGBA).NRGBAAt(x, y); c != m.NRGBAAt(x, y) {
+					t.Fatalf("%s: pixel (%d, %d) = %v, want %v", tt.name, x, y, c, m.NRGBAAt(x, y))
+				}
+			}
+		}
+	}
+}
+
+func TestEncodePhoto(t *testing.T) {
+	data, err := os.ReadFile("../../testfiles/samplesmaller.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	// Predicted and back-referenced, a photo codes smaller than as a PNG.
+	if buf.Len() >= len(data) {
+		t.Errorf("encoded to %d bytes, more than the %d-byte PNG", buf.Len(), len(data))
+	}
+	got, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if c, want := got.(*image.NRGBA).NRGBAAt(x, y), nrgbaAt(m, x, y); c != want {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, c, want)
+			}
+		}
+	}
+}
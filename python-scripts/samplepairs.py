@@ -7,7 +7,7 @@ def onlyLsb(x):
 
 # exceptLsb returns all the bits other than the last one of x
 def exceptLsb(x):
-    return x << 1
+    return x >> 1
 
 # updateParams updates the provided parameters, depending on the LSB and MSBs
 def updateParams(u, v, params):
@@ -17,7 +17,7 @@ def updateParams(u, v, params):
     vLsb = onlyLsb(v)
 
     # if only the LSB are different
-    if (uMsb == vMsb) and (uLsb != vMsb):
+    if (uMsb == vMsb) and (uLsb != vLsb):
         params[0] += 1
 
     # if they are the same